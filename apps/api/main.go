@@ -3,17 +3,37 @@ package main
 import (
 	"api/realtime"
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
 )
 
+const defaultPresenceDebounce = 50 * time.Millisecond
+
+// ipListPollInterval is how often the IP list file is checked for changes.
+const ipListPollInterval = 2 * time.Second
+
+// defaultAdmitPerIP and defaultAdmitPerIPRate cap concurrent connections and
+// connects per minute from one address, leaving room for a shared NAT.
+const (
+	defaultAdmitPerIP     = 32
+	defaultAdmitPerIPRate = 60
+)
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:    1024,
 	WriteBufferSize:   1024,
@@ -24,10 +44,71 @@ var upgrader = websocket.Upgrader{
 type config struct {
 	Addr           string
 	AllowedOrigins map[string]struct{}
+
+	// Netpoll serves /connect from the epoll reactor instead of a pair of
+	// goroutines per client. NetpollWorkers sizes the reactor's worker pool.
+	Netpoll        bool
+	NetpollWorkers int
+
+	// PresenceDebounce coalesces presence updates during connection storms.
+	PresenceDebounce time.Duration
+
+	// RegionSize, when positive, runs an unbounded canvas split into square
+	// regions of this many pixels.
+	RegionSize float64
+
+	// IdleAfter and AwayAfter override how long a quiet client takes to show
+	// as idle and then away. Zero keeps the hub's defaults.
+	IdleAfter time.Duration
+	AwayAfter time.Duration
+
+	// ProximityRadius, when positive, only connects users within this many
+	// pixels of each other.
+	ProximityRadius float64
+
+	// AdminAddr, when set, serves the admin API on its own listener so it can
+	// stay off the public network. AdminToken is the bearer token it requires.
+	AdminAddr  string
+	AdminToken string
+
+	// WordFilter masks or clears listed words as they are typed; nil turns
+	// the filter off.
+	WordFilter *realtime.WordFilter
+
+	// Admission caps connections per address, per room and overall.
+	Admission realtime.AdmissionLimits
+
+	// TrustedProxies are the ranges whose forwarding headers are believed
+	// when working out a client's address.
+	TrustedProxies []netip.Prefix
+	// ProxyHeader is the forwarding header those proxies write.
+	ProxyHeader string
+
+	// Reports sets how many distinct users must report someone, within what
+	// window, to mute them.
+	Reports realtime.ReportLimits
+
+	// Abuse, when set, turns on the abuse heuristics with these thresholds.
+	Abuse *realtime.AbuseThresholds
+
+	// ModerationFile, when set, is where sanctions are saved so bans survive
+	// restarts.
+	ModerationFile string
+
+	// Challenge, when set, makes clients solve a proof-of-work challenge
+	// before connecting.
+	Challenge *realtime.ChallengeSettings
+
+	// IPListFile, when set, lists address ranges to allow or deny. It is
+	// reloaded when it changes and on SIGHUP.
+	IPListFile string
 }
 
 var allowedOrigins map[string]struct{}
 
+// reactor, when set, takes over WebSocket upgrades on /connect.
+var reactor *realtime.Reactor
+
 func main() {
 	_ = godotenv.Load(".env.local", ".env")
 
@@ -37,16 +118,118 @@ func main() {
 	}
 	allowedOrigins = cfg.AllowedOrigins
 
+	moderation := realtime.NewModeration()
+	if cfg.ModerationFile != "" {
+		moderation, err = realtime.LoadModeration(cfg.ModerationFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Saving moderation sanctions to", cfg.ModerationFile)
+	}
+
+	ipList := realtime.NewIPList()
+	if cfg.IPListFile != "" {
+		ipList, err = realtime.LoadIPList(cfg.IPListFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go ipList.Watch(ipListPollInterval)
+		go reloadOnHangup(ipList)
+		fmt.Println("Allowing and denying addresses from", cfg.IPListFile)
+	}
+
 	// Create hub
-	hub := realtime.NewHub()
+	opts := []realtime.Option{
+		realtime.WithModeration(moderation),
+		realtime.WithWordFilter(cfg.WordFilter),
+		realtime.WithPresenceDebounce(cfg.PresenceDebounce),
+		realtime.WithRegionSize(cfg.RegionSize),
+		realtime.WithProximity(cfg.ProximityRadius),
+		realtime.WithIdleThresholds(cfg.IdleAfter, cfg.AwayAfter),
+		realtime.WithReportLimits(cfg.Reports),
+	}
+	if cfg.Abuse != nil {
+		opts = append(opts, realtime.WithAbuseHeuristics(*cfg.Abuse))
+		fmt.Println("Abuse heuristics on with a", cfg.Abuse.Cooldown, "cool-down")
+	}
+	hub := realtime.NewHub(opts...)
 	go hub.Run()
 
+	if cfg.WordFilter != nil {
+		expvar.Publish("word_filter_hits", expvar.Func(func() any { return cfg.WordFilter.Hits() }))
+	}
+
+	if cfg.RegionSize > 0 {
+		fmt.Println("Partitioning the canvas into regions of", cfg.RegionSize, "pixels")
+	}
+	if cfg.ProximityRadius > 0 {
+		fmt.Println("Proximity mode on with a radius of", cfg.ProximityRadius, "pixels")
+	}
+
+	if cfg.Netpoll {
+		reactor, err = realtime.NewReactor(hub, cfg.NetpollWorkers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Serving WebSocket clients from the netpoll reactor with", cfg.NetpollWorkers, "workers")
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", healthHandler)
-	mux.HandleFunc("/connect", websocketHandler(hub))
+	// Foreign origins, denied ranges, unsolved challenges and banned peers are
+	// refused first, then admission control decides whether there is room
+	// for the rest before anything is upgraded.
+	admission := realtime.NewAdmission(cfg.Admission)
+	expvar.Publish("admission", admission.Metrics())
+	var challenger *realtime.Challenger
+	if cfg.Challenge != nil {
+		challenger = realtime.NewChallenger(*cfg.Challenge)
+		expvar.Publish("challenge", challenger.Metrics())
+		fmt.Println("Requiring a proof-of-work challenge of", cfg.Challenge.Difficulty, "bits to connect")
+	}
+	mux.HandleFunc("/challenge", corsHandler(challengeHandler(challenger)))
+	admit := func(next http.HandlerFunc) http.HandlerFunc {
+		return banHandler(moderation, admission.Handler(hub, next))
+	}
+	mux.HandleFunc("/connect", originHandler(ipList.Handler(challenged(challenger, admit, websocketHandler(hub)))))
+
+	// Server-Sent Events + POST fallback for networks that break WebSocket
+	// upgrades. Posts need the session the stream handed out, so challenging
+	// the stream covers them too.
+	sse := realtime.NewSSEServer(hub)
+	mux.HandleFunc("/events", corsHandler(ipList.Handler(challenged(challenger, admit, sse.ServeEvents))))
+	mux.HandleFunc("/send", corsHandler(sse.ServeSend))
+
+	if cfg.AdminAddr != "" {
+		admin := realtime.NewAdminServer(cfg.AdminToken, moderation, ipList, map[string]*realtime.Hub{"default": hub})
+		go func() {
+			fmt.Println("Admin API listening on", cfg.AdminAddr)
+			log.Fatal(http.ListenAndServe(cfg.AdminAddr, admin))
+		}()
+	}
+
+	// Every handler sees the client's own address rather than the proxy's.
+	if len(cfg.TrustedProxies) > 0 {
+		fmt.Println("Trusting", cfg.ProxyHeader, "from", cfg.TrustedProxies)
+	}
+	proxies := realtime.NewTrustedProxies(cfg.TrustedProxies, cfg.ProxyHeader)
 
 	fmt.Println("Go API listening on", cfg.Addr)
-	log.Fatal(http.ListenAndServe(cfg.Addr, mux))
+	log.Fatal(http.ListenAndServe(cfg.Addr, proxies.Handler(mux)))
+}
+
+// reloadOnHangup rereads the IP list whenever the process gets SIGHUP, for
+// operators who would rather not wait for the file watcher.
+func reloadOnHangup(ipList *realtime.IPList) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		if err := ipList.Reload(); err != nil {
+			log.Printf("Error reloading IP list: %v", err)
+			continue
+		}
+		log.Printf("Reloaded IP list on SIGHUP")
+	}
 }
 
 func loadConfig() (config, error) {
@@ -60,12 +243,301 @@ func loadConfig() (config, error) {
 		return config{}, fmt.Errorf("ALLOWED_ORIGINS is not set")
 	}
 
+	netpoll, err := parseBool(os.Getenv("NETPOLL"))
+	if err != nil {
+		return config{}, fmt.Errorf("NETPOLL: %w", err)
+	}
+
+	workers := runtime.NumCPU()
+	if value := strings.TrimSpace(os.Getenv("NETPOLL_WORKERS")); value != "" {
+		workers, err = strconv.Atoi(value)
+		if err != nil || workers <= 0 {
+			return config{}, fmt.Errorf("NETPOLL_WORKERS must be a positive integer, got %q", value)
+		}
+	}
+
+	presenceDebounce, err := parseDuration(os.Getenv("PRESENCE_DEBOUNCE"), defaultPresenceDebounce)
+	if err != nil {
+		return config{}, fmt.Errorf("PRESENCE_DEBOUNCE: %w", err)
+	}
+
+	idleAfter, err := parseDuration(os.Getenv("IDLE_AFTER"), 0)
+	if err != nil {
+		return config{}, fmt.Errorf("IDLE_AFTER: %w", err)
+	}
+
+	awayAfter, err := parseDuration(os.Getenv("AWAY_AFTER"), 0)
+	if err != nil {
+		return config{}, fmt.Errorf("AWAY_AFTER: %w", err)
+	}
+
+	regionSize, err := parsePixels(os.Getenv("REGION_SIZE"))
+	if err != nil {
+		return config{}, fmt.Errorf("REGION_SIZE: %w", err)
+	}
+
+	proximity, err := parsePixels(os.Getenv("PROXIMITY_RADIUS"))
+	if err != nil {
+		return config{}, fmt.Errorf("PROXIMITY_RADIUS: %w", err)
+	}
+
+	wordFilter, err := loadWordFilter(os.Getenv("PROFANITY_WORDLIST"), os.Getenv("PROFANITY_ACTION"))
+	if err != nil {
+		return config{}, fmt.Errorf("PROFANITY_WORDLIST: %w", err)
+	}
+
+	admission, err := loadAdmissionLimits()
+	if err != nil {
+		return config{}, err
+	}
+
+	trustedProxies, err := realtime.ParsePrefixes(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return config{}, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+	}
+	proxyHeader := realtime.DefaultProxyHeader
+	if value := strings.TrimSpace(os.Getenv("TRUSTED_PROXY_HEADER")); value != "" {
+		proxyHeader = value
+	}
+
+	reports := realtime.DefaultReportLimits()
+	if value := strings.TrimSpace(os.Getenv("REPORT_THRESHOLD")); value != "" {
+		reports.Threshold, err = strconv.Atoi(value)
+		if err != nil || reports.Threshold <= 0 {
+			return config{}, fmt.Errorf("REPORT_THRESHOLD must be a positive integer, got %q", value)
+		}
+	}
+	reports.Window, err = parseDuration(os.Getenv("REPORT_WINDOW"), reports.Window)
+	if err != nil || reports.Window == 0 {
+		return config{}, fmt.Errorf("REPORT_WINDOW must be a positive duration, got %q", os.Getenv("REPORT_WINDOW"))
+	}
+
+	// Ranges trusted with more connections are shared by too many people
+	// to report or refuse by address either.
+	reports.Exempt = admission.Exempt
+
+	abuse, err := loadAbuseThresholds()
+	if err != nil {
+		return config{}, err
+	}
+	if abuse != nil {
+		abuse.Exempt = admission.Exempt
+	}
+
+	challenge, err := loadChallengeSettings()
+	if err != nil {
+		return config{}, err
+	}
+
+	adminAddr := strings.TrimSpace(os.Getenv("ADMIN_ADDR"))
+	adminToken := strings.TrimSpace(os.Getenv("ADMIN_TOKEN"))
+	if adminAddr != "" && adminToken == "" {
+		return config{}, fmt.Errorf("ADMIN_TOKEN must be set when ADMIN_ADDR is")
+	}
+
 	return config{
-		Addr:           ":" + port,
-		AllowedOrigins: origins,
+		Addr:             ":" + port,
+		AllowedOrigins:   origins,
+		Netpoll:          netpoll,
+		NetpollWorkers:   workers,
+		PresenceDebounce: presenceDebounce,
+		IdleAfter:        idleAfter,
+		AwayAfter:        awayAfter,
+		RegionSize:       regionSize,
+		ProximityRadius:  proximity,
+		AdminAddr:        adminAddr,
+		AdminToken:       adminToken,
+		WordFilter:       wordFilter,
+		Admission:        admission,
+		TrustedProxies:   trustedProxies,
+		ProxyHeader:      proxyHeader,
+		Reports:          reports,
+		Abuse:            abuse,
+		Challenge:        challenge,
+		ModerationFile:   strings.TrimSpace(os.Getenv("MODERATION_FILE")),
+		IPListFile:       strings.TrimSpace(os.Getenv("IP_LIST_FILE")),
 	}, nil
 }
 
+// loadWordFilter builds the composition filter from a word list file, or the
+// built-in list when path is empty. An action of "off" disables it.
+func loadWordFilter(path, action string) (*realtime.WordFilter, error) {
+	path = strings.TrimSpace(path)
+	action = strings.ToLower(strings.TrimSpace(action))
+	switch action {
+	case "off":
+		return nil, nil
+	case "":
+		action = string(realtime.FilterMask)
+	}
+
+	if path == "" {
+		return realtime.NewWordFilter(realtime.DefaultFilterWords(), realtime.FilterAction(action))
+	}
+	return realtime.LoadWordFilter(path, realtime.FilterAction(action))
+}
+
+// loadAdmissionLimits reads the connection limits. Loopback addresses are
+// always exempt from the per-address limits so local development and
+// end-to-end runs aren't throttled.
+func loadAdmissionLimits() (realtime.AdmissionLimits, error) {
+	limits := realtime.AdmissionLimits{
+		PerIP:     defaultAdmitPerIP,
+		PerIPRate: defaultAdmitPerIPRate,
+	}
+
+	for _, limit := range []struct {
+		name  string
+		value *int
+	}{
+		{"ADMIT_PER_IP", &limits.PerIP},
+		{"ADMIT_PER_IP_RATE", &limits.PerIPRate},
+		{"ADMIT_MAX_CLIENTS", &limits.MaxClients},
+		{"ADMIT_MAX_ROOM_CLIENTS", &limits.MaxRoomClients},
+	} {
+		if value := strings.TrimSpace(os.Getenv(limit.name)); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return realtime.AdmissionLimits{}, fmt.Errorf("%s must be a non-negative integer, got %q", limit.name, value)
+			}
+			*limit.value = n
+		}
+	}
+
+	exempt, err := realtime.ParsePrefixes("127.0.0.0/8,::1," + os.Getenv("ADMIT_EXEMPT"))
+	if err != nil {
+		return realtime.AdmissionLimits{}, fmt.Errorf("ADMIT_EXEMPT: %w", err)
+	}
+	limits.Exempt = exempt
+
+	return limits, nil
+}
+
+// loadAbuseThresholds reads the abuse heuristics settings. The heuristics are
+// off unless ABUSE_HEURISTICS is true, since local dev bots and end-to-end
+// runs type like bots from a single address.
+func loadAbuseThresholds() (*realtime.AbuseThresholds, error) {
+	enabled, err := parseBool(os.Getenv("ABUSE_HEURISTICS"))
+	if err != nil {
+		return nil, fmt.Errorf("ABUSE_HEURISTICS: %w", err)
+	}
+	if !enabled {
+		return nil, nil
+	}
+
+	limits := realtime.DefaultAbuseThresholds()
+	for _, score := range []struct {
+		name  string
+		value *float64
+	}{
+		{"ABUSE_MUTE_SCORE", &limits.MuteScore},
+		{"ABUSE_DISCONNECT_SCORE", &limits.DisconnectScore},
+	} {
+		if value := strings.TrimSpace(os.Getenv(score.name)); value != "" {
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil || !(n > 0) || math.IsInf(n, 0) {
+				return nil, fmt.Errorf("%s must be a positive number, got %q", score.name, value)
+			}
+			*score.value = n
+		}
+	}
+
+	if value := strings.TrimSpace(os.Getenv("ABUSE_CHURN_LIMIT")); value != "" {
+		limits.ChurnLimit, err = strconv.Atoi(value)
+		if err != nil || limits.ChurnLimit < 0 {
+			return nil, fmt.Errorf("ABUSE_CHURN_LIMIT must be a non-negative integer, got %q", value)
+		}
+	}
+
+	limits.Cooldown, err = parseDuration(os.Getenv("ABUSE_COOLDOWN"), limits.Cooldown)
+	if err != nil {
+		return nil, fmt.Errorf("ABUSE_COOLDOWN: %w", err)
+	}
+
+	return &limits, nil
+}
+
+// loadChallengeSettings reads the proof-of-work challenge settings. The
+// challenge is off unless POW_CHALLENGE is true, since every client then has
+// to solve one before connecting.
+func loadChallengeSettings() (*realtime.ChallengeSettings, error) {
+	enabled, err := parseBool(os.Getenv("POW_CHALLENGE"))
+	if err != nil {
+		return nil, fmt.Errorf("POW_CHALLENGE: %w", err)
+	}
+	if !enabled {
+		return nil, nil
+	}
+
+	settings := realtime.DefaultChallengeSettings()
+	if value := strings.TrimSpace(os.Getenv("POW_DIFFICULTY")); value != "" {
+		settings.Difficulty, err = strconv.Atoi(value)
+		if err != nil || settings.Difficulty < 1 || settings.Difficulty > 32 {
+			return nil, fmt.Errorf("POW_DIFFICULTY must be between 1 and 32 bits, got %q", value)
+		}
+		settings.MaxDifficulty = max(settings.MaxDifficulty, settings.Difficulty)
+	}
+	if value := strings.TrimSpace(os.Getenv("POW_MAX_DIFFICULTY")); value != "" {
+		settings.MaxDifficulty, err = strconv.Atoi(value)
+		if err != nil || settings.MaxDifficulty < settings.Difficulty || settings.MaxDifficulty > 32 {
+			return nil, fmt.Errorf("POW_MAX_DIFFICULTY must be between POW_DIFFICULTY and 32 bits, got %q", value)
+		}
+	}
+	if value := strings.TrimSpace(os.Getenv("POW_SPIKE_RATE")); value != "" {
+		settings.SpikeRate, err = strconv.Atoi(value)
+		if err != nil || settings.SpikeRate < 0 {
+			return nil, fmt.Errorf("POW_SPIKE_RATE must be a non-negative integer, got %q", value)
+		}
+	}
+	settings.Secret = []byte(os.Getenv("POW_SECRET"))
+
+	return &settings, nil
+}
+
+// parseDuration parses a Go duration such as "250ms", falling back to def when
+// value is empty.
+func parseDuration(value string, def time.Duration) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("must not be negative, got %s", value)
+	}
+
+	return d, nil
+}
+
+// parsePixels parses a positive canvas distance, returning zero when value is
+// empty.
+func parsePixels(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	px, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(px > 0) || math.IsInf(px, 0) {
+		return 0, fmt.Errorf("must be a positive number of pixels, got %q", value)
+	}
+
+	return px, nil
+}
+
+func parseBool(value string) (bool, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return false, nil
+	}
+
+	return strconv.ParseBool(value)
+}
+
 func parseAllowedOrigins(value string) map[string]struct{} {
 	origins := make(map[string]struct{})
 	for origin := range strings.SplitSeq(value, ",") {
@@ -87,7 +559,7 @@ func checkOrigin(r *http.Request) bool {
 		return true
 	}
 
-	log.Printf("Rejected WebSocket origin %q; add it to ALLOWED_ORIGINS to allow this client", origin)
+	log.Printf("Rejected origin %q; add it to ALLOWED_ORIGINS to allow this client", origin)
 	return false
 }
 
@@ -116,15 +588,103 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// corsHandler guards the plain-HTTP transport endpoints with the same origin
+// allowlist as the WebSocket upgrade and answers CORS preflight requests.
+func corsHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkOrigin(r) {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		origin, _ := normalizeOrigin(r.Header.Get("Origin"))
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// challengeHandler hands out proof-of-work challenges, or answers 204 when
+// they are off so clients can tell that apart from a failed request.
+func challengeHandler(challenger *realtime.Challenger) http.HandlerFunc {
+	if challenger != nil {
+		return challenger.ServeChallenge
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// challenged wraps admit(next) in the challenge when there is one. The
+// challenge is checked before bans and admission but only spent once they
+// let the connection through, so a client turned away there can retry with
+// the same solution.
+func challenged(challenger *realtime.Challenger, admit func(http.HandlerFunc) http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	if challenger == nil {
+		return admit(next)
+	}
+	return challenger.Handler(admit(challenger.Spend(next)))
+}
+
+// originHandler refuses upgrades from origins off the allowlist before they
+// reach admission control, so junk from other sites can't spend a shared
+// address's connect budget.
+func originHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkOrigin(r) {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// banHandler refuses connections from banned addresses and identities before
+// they reach the hub.
+func banHandler(moderation *realtime.Moderation, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		peer := realtime.PeerFromRequest(r)
+		if ban, ok := moderation.Banned(peer); ok {
+			log.Printf("Refused banned connection from %s (%s)", peer.IP(), ban.Subject)
+			http.Error(w, "banned", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
 func websocketHandler(hub *realtime.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if reactor != nil {
+			if !checkOrigin(r) {
+				http.Error(w, "origin not allowed", http.StatusForbidden)
+				return
+			}
+
+			if err := reactor.Upgrade(w, r); err != nil {
+				log.Printf("WebSocket upgrade failed: %v", err)
+			}
+			return
+		}
+
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			log.Printf("WebSocket upgrade failed: %v", err)
 			return
 		}
 
-		client := realtime.NewClient(hub, conn)
+		client := realtime.NewClient(hub, realtime.NewWebsocketConn(conn), realtime.PeerFromRequest(r))
 		hub.Register(client)
 
 		go client.WritePump()
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
@@ -156,3 +157,164 @@ func TestIntegration_WebsocketTypingFlow(t *testing.T) {
 		t.Fatalf("expected user ID in typing update")
 	}
 }
+
+func TestBanHandler_RefusesBannedPeers(t *testing.T) {
+	setAllowedOriginsForTest(t, "http://example.com")
+
+	moderation := realtime.NewModeration()
+	if err := moderation.Add(realtime.Sanction{Kind: realtime.SanctionBan, Subject: "identity:browser-1"}); err != nil {
+		t.Fatalf("add ban: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/connect?identity=browser-1", nil)
+	req.Header.Set("Origin", "http://example.com")
+	rr := httptest.NewRecorder()
+
+	banHandler(moderation, websocketHandler(realtime.NewHub()))(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d", http.StatusForbidden, rr.Code)
+	}
+}
+
+func TestOriginHandler_RefusesBeforeAdmission(t *testing.T) {
+	setAllowedOriginsForTest(t, "http://example.com")
+
+	admission := realtime.NewAdmission(realtime.AdmissionLimits{PerIPRate: 1})
+	handler := originHandler(admission.Handler(realtime.NewHub(), func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	request := func(origin string) int {
+		req := httptest.NewRequest(http.MethodGet, "/connect", nil)
+		req.RemoteAddr = "192.0.2.1:4000"
+		req.Header.Set("Origin", origin)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr.Code
+	}
+
+	for range 3 {
+		if code := request("https://other.com"); code != http.StatusForbidden {
+			t.Fatalf("expected a foreign origin to get 403, got %d", code)
+		}
+	}
+	if code := request("http://example.com"); code != http.StatusNoContent {
+		t.Fatalf("expected refused origins not to spend the address's rate, got %d", code)
+	}
+}
+
+func TestChallenged_RefusesUnsolvedEvents(t *testing.T) {
+	setAllowedOriginsForTest(t, "http://example.com")
+
+	challenger := realtime.NewChallenger(realtime.ChallengeSettings{Difficulty: 0, TTL: time.Minute})
+	admission := realtime.NewAdmission(realtime.AdmissionLimits{PerIPRate: 1})
+	hub := realtime.NewHub()
+	admit := func(next http.HandlerFunc) http.HandlerFunc {
+		return banHandler(realtime.NewModeration(), admission.Handler(hub, next))
+	}
+	handler := corsHandler(challenged(challenger, admit, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	request := func(query string) int {
+		req := httptest.NewRequest(http.MethodGet, "/events"+query, nil)
+		req.RemoteAddr = "192.0.2.1:4000"
+		req.Header.Set("Origin", "http://example.com")
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr.Code
+	}
+
+	if code := request(""); code != http.StatusPreconditionRequired {
+		t.Fatalf("expected a stream without a challenge to get 428, got %d", code)
+	}
+	if code := request("?challenge=forged&nonce=1"); code != http.StatusForbidden {
+		t.Fatalf("expected a forged challenge to get 403, got %d", code)
+	}
+	solved := "?challenge=" + url.QueryEscape(challenger.Issue().Challenge) + "&nonce=1"
+	if code := request(solved); code != http.StatusNoContent {
+		t.Fatalf("expected a solved challenge to open the stream, got %d", code)
+	}
+	if code := request(solved); code != http.StatusForbidden {
+		t.Fatalf("expected a spent challenge to get 403, got %d", code)
+	}
+}
+
+func TestChallengeHandler_AnswersWhenChallengesAreOff(t *testing.T) {
+	setAllowedOriginsForTest(t, "http://example.com")
+
+	req := httptest.NewRequest(http.MethodGet, "/challenge", nil)
+	req.Header.Set("Origin", "http://example.com")
+	rr := httptest.NewRecorder()
+
+	corsHandler(challengeHandler(nil))(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "http://example.com" {
+		t.Fatalf("expected allow origin header, got %q", got)
+	}
+}
+
+func TestCORSHandler(t *testing.T) {
+	setAllowedOriginsForTest(t, "http://example.com")
+
+	called := false
+	handler := corsHandler(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	t.Run("rejects disallowed origin", func(t *testing.T) {
+		called = false
+		req := httptest.NewRequest(http.MethodPost, "/send", nil)
+		req.Header.Set("Origin", "https://other.com")
+		rr := httptest.NewRecorder()
+
+		handler(rr, req)
+
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("expected status 403, got %d", rr.Code)
+		}
+		if called {
+			t.Fatal("expected wrapped handler not to be called")
+		}
+	})
+
+	t.Run("answers preflight", func(t *testing.T) {
+		called = false
+		req := httptest.NewRequest(http.MethodOptions, "/send", nil)
+		req.Header.Set("Origin", "http://example.com")
+		rr := httptest.NewRecorder()
+
+		handler(rr, req)
+
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("expected status 204, got %d", rr.Code)
+		}
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "http://example.com" {
+			t.Fatalf("expected allow origin header, got %q", got)
+		}
+		if called {
+			t.Fatal("expected preflight not to reach wrapped handler")
+		}
+	})
+
+	t.Run("passes allowed origin through", func(t *testing.T) {
+		called = false
+		req := httptest.NewRequest(http.MethodPost, "/send", nil)
+		req.Header.Set("Origin", "http://example.com")
+		rr := httptest.NewRecorder()
+
+		handler(rr, req)
+
+		if !called {
+			t.Fatal("expected wrapped handler to be called")
+		}
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "http://example.com" {
+			t.Fatalf("expected allow origin header, got %q", got)
+		}
+	})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func setAllowedOriginsForTest(t *testing.T, value string) {
@@ -142,4 +143,387 @@ func TestLoadConfig(t *testing.T) {
 			t.Fatal("expected normalized http://localhost:3000 origin")
 		}
 	})
+	t.Run("netpoll disabled by default", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("ALLOWED_ORIGINS", "http://localhost:3000")
+		t.Setenv("NETPOLL", "")
+		t.Setenv("NETPOLL_WORKERS", "")
+
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+
+		if cfg.Netpoll {
+			t.Fatal("expected netpoll to be disabled")
+		}
+		if cfg.NetpollWorkers <= 0 {
+			t.Fatalf("expected a positive default worker count, got %d", cfg.NetpollWorkers)
+		}
+	})
+
+	t.Run("loads netpoll settings", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("ALLOWED_ORIGINS", "http://localhost:3000")
+		t.Setenv("NETPOLL", "true")
+		t.Setenv("NETPOLL_WORKERS", "16")
+
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+
+		if !cfg.Netpoll || cfg.NetpollWorkers != 16 {
+			t.Fatalf("expected netpoll with 16 workers, got %v/%d", cfg.Netpoll, cfg.NetpollWorkers)
+		}
+	})
+
+	t.Run("rejects invalid netpoll workers", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("ALLOWED_ORIGINS", "http://localhost:3000")
+		t.Setenv("NETPOLL_WORKERS", "0")
+
+		if _, err := loadConfig(); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+	t.Run("loads presence debounce", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("ALLOWED_ORIGINS", "http://localhost:3000")
+
+		t.Setenv("PRESENCE_DEBOUNCE", "")
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		if cfg.PresenceDebounce != defaultPresenceDebounce {
+			t.Fatalf("expected default debounce %s, got %s", defaultPresenceDebounce, cfg.PresenceDebounce)
+		}
+
+		t.Setenv("PRESENCE_DEBOUNCE", "250ms")
+		cfg, err = loadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		if cfg.PresenceDebounce != 250*time.Millisecond {
+			t.Fatalf("expected 250ms debounce, got %s", cfg.PresenceDebounce)
+		}
+
+		t.Setenv("PRESENCE_DEBOUNCE", "soon")
+		if _, err := loadConfig(); err == nil {
+			t.Fatal("expected error for invalid duration")
+		}
+	})
+
+	t.Run("loads region size", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("ALLOWED_ORIGINS", "http://localhost:3000")
+
+		t.Setenv("REGION_SIZE", "")
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		if cfg.RegionSize != 0 {
+			t.Fatalf("expected region mode off by default, got %v", cfg.RegionSize)
+		}
+
+		t.Setenv("REGION_SIZE", "2048")
+		cfg, err = loadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		if cfg.RegionSize != 2048 {
+			t.Fatalf("expected region size 2048, got %v", cfg.RegionSize)
+		}
+
+		t.Setenv("REGION_SIZE", "-1")
+		if _, err := loadConfig(); err == nil {
+			t.Fatal("expected error for negative region size")
+		}
+	})
+
+	t.Run("loads proximity radius", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("ALLOWED_ORIGINS", "http://localhost:3000")
+
+		t.Setenv("PROXIMITY_RADIUS", "400")
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		if cfg.ProximityRadius != 400 {
+			t.Fatalf("expected radius 400, got %v", cfg.ProximityRadius)
+		}
+
+		t.Setenv("PROXIMITY_RADIUS", "far")
+		if _, err := loadConfig(); err == nil {
+			t.Fatal("expected error for invalid radius")
+		}
+	})
+
+	t.Run("loads idle thresholds", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("ALLOWED_ORIGINS", "http://localhost:3000")
+		t.Setenv("IDLE_AFTER", "30s")
+		t.Setenv("AWAY_AFTER", "10m")
+
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		if cfg.IdleAfter != 30*time.Second || cfg.AwayAfter != 10*time.Minute {
+			t.Fatalf("expected 30s/10m thresholds, got %s/%s", cfg.IdleAfter, cfg.AwayAfter)
+		}
+
+		t.Setenv("AWAY_AFTER", "later")
+		if _, err := loadConfig(); err == nil {
+			t.Fatal("expected error for invalid duration")
+		}
+	})
+
+	t.Run("loads admin listener", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("ALLOWED_ORIGINS", "http://localhost:3000")
+		t.Setenv("ADMIN_ADDR", "127.0.0.1:9090")
+		t.Setenv("ADMIN_TOKEN", "")
+
+		if _, err := loadConfig(); err == nil {
+			t.Fatal("expected error for admin listener without a token")
+		}
+
+		t.Setenv("ADMIN_TOKEN", "secret")
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		if cfg.AdminAddr != "127.0.0.1:9090" || cfg.AdminToken != "secret" {
+			t.Fatalf("expected admin listener on 127.0.0.1:9090, got %q", cfg.AdminAddr)
+		}
+	})
+
+	t.Run("loads moderation file", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("ALLOWED_ORIGINS", "http://localhost:3000")
+		t.Setenv("MODERATION_FILE", " data/moderation.json ")
+
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		if cfg.ModerationFile != "data/moderation.json" {
+			t.Fatalf("expected moderation file data/moderation.json, got %q", cfg.ModerationFile)
+		}
+	})
+
+	t.Run("loads word filter", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("ALLOWED_ORIGINS", "http://localhost:3000")
+		t.Setenv("PROFANITY_WORDLIST", "")
+		t.Setenv("PROFANITY_ACTION", "")
+
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		if cfg.WordFilter == nil {
+			t.Fatal("expected the built-in word filter by default")
+		}
+
+		t.Setenv("PROFANITY_ACTION", "off")
+		if cfg, err = loadConfig(); err != nil || cfg.WordFilter != nil {
+			t.Fatalf("expected the filter to be off, got %v (err %v)", cfg.WordFilter, err)
+		}
+
+		t.Setenv("PROFANITY_ACTION", "shout")
+		if _, err := loadConfig(); err == nil {
+			t.Fatal("expected error for an unknown action")
+		}
+
+		t.Setenv("PROFANITY_ACTION", "clear")
+		t.Setenv("PROFANITY_WORDLIST", "does-not-exist.txt")
+		if _, err := loadConfig(); err == nil {
+			t.Fatal("expected error for a missing word list")
+		}
+	})
+
+	t.Run("loads abuse thresholds", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("ALLOWED_ORIGINS", "http://localhost:3000")
+		t.Setenv("ABUSE_HEURISTICS", "")
+
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		if cfg.Abuse != nil {
+			t.Fatal("expected abuse heuristics to be off by default")
+		}
+
+		t.Setenv("ABUSE_HEURISTICS", "true")
+		t.Setenv("ABUSE_MUTE_SCORE", "4")
+		t.Setenv("ABUSE_DISCONNECT_SCORE", "9.5")
+		t.Setenv("ABUSE_CHURN_LIMIT", "20")
+		t.Setenv("ABUSE_COOLDOWN", "10m")
+		cfg, err = loadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		if cfg.Abuse == nil {
+			t.Fatal("expected abuse heuristics to be on")
+		}
+		if cfg.Abuse.MuteScore != 4 || cfg.Abuse.DisconnectScore != 9.5 || cfg.Abuse.ChurnLimit != 20 || cfg.Abuse.Cooldown != 10*time.Minute {
+			t.Fatalf("unexpected thresholds %+v", *cfg.Abuse)
+		}
+		if len(cfg.Abuse.Exempt) != len(cfg.Admission.Exempt) {
+			t.Fatalf("expected the admission exemptions to spare addresses from abuse bans, got %v", cfg.Abuse.Exempt)
+		}
+
+		t.Setenv("ABUSE_MUTE_SCORE", "-1")
+		if _, err := loadConfig(); err == nil {
+			t.Fatal("expected error for a negative score")
+		}
+	})
+
+	t.Run("loads report limits", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("ALLOWED_ORIGINS", "http://localhost:3000")
+		t.Setenv("REPORT_THRESHOLD", "5")
+		t.Setenv("REPORT_WINDOW", "30m")
+
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		if cfg.Reports.Threshold != 5 || cfg.Reports.Window != 30*time.Minute {
+			t.Fatalf("expected 5 reports in 30m, got %+v", cfg.Reports)
+		}
+
+		t.Setenv("REPORT_THRESHOLD", "0")
+		if _, err := loadConfig(); err == nil {
+			t.Fatal("expected error for a zero threshold")
+		}
+
+		t.Setenv("REPORT_THRESHOLD", "")
+		t.Setenv("REPORT_WINDOW", "0s")
+		if _, err := loadConfig(); err == nil {
+			t.Fatal("expected error for a zero window")
+		}
+	})
+
+	t.Run("loads admission limits", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("ALLOWED_ORIGINS", "http://localhost:3000")
+		t.Setenv("ADMIT_PER_IP", "")
+		t.Setenv("ADMIT_PER_IP_RATE", "")
+		t.Setenv("ADMIT_MAX_CLIENTS", "5000")
+		t.Setenv("ADMIT_MAX_ROOM_CLIENTS", "")
+		t.Setenv("ADMIT_EXEMPT", "203.0.113.0/24")
+
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		if cfg.Admission.PerIP != defaultAdmitPerIP || cfg.Admission.PerIPRate != defaultAdmitPerIPRate {
+			t.Fatalf("expected default per-address limits, got %+v", cfg.Admission)
+		}
+		if cfg.Admission.MaxClients != 5000 || cfg.Admission.MaxRoomClients != 0 {
+			t.Fatalf("expected a 5000 client cap, got %+v", cfg.Admission)
+		}
+		if len(cfg.Admission.Exempt) != 3 {
+			t.Fatalf("expected loopback and the office range to be exempt, got %v", cfg.Admission.Exempt)
+		}
+
+		t.Setenv("ADMIT_PER_IP", "-1")
+		if _, err := loadConfig(); err == nil {
+			t.Fatal("expected error for a negative limit")
+		}
+
+		t.Setenv("ADMIT_PER_IP", "")
+		t.Setenv("ADMIT_EXEMPT", "office")
+		if _, err := loadConfig(); err == nil {
+			t.Fatal("expected error for a bad range")
+		}
+	})
+
+	t.Run("loads trusted proxies", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("ALLOWED_ORIGINS", "http://localhost:3000")
+		t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.1")
+
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		if len(cfg.TrustedProxies) != 2 {
+			t.Fatalf("expected two trusted ranges, got %v", cfg.TrustedProxies)
+		}
+		if cfg.ProxyHeader != "X-Forwarded-For" {
+			t.Fatalf("expected X-Forwarded-For by default, got %q", cfg.ProxyHeader)
+		}
+
+		t.Setenv("TRUSTED_PROXY_HEADER", "Forwarded")
+		if cfg, err := loadConfig(); err != nil || cfg.ProxyHeader != "Forwarded" {
+			t.Fatalf("expected the Forwarded header, got %q (err %v)", cfg.ProxyHeader, err)
+		}
+
+		t.Setenv("TRUSTED_PROXIES", "vercel")
+		if _, err := loadConfig(); err == nil {
+			t.Fatal("expected error for a bad range")
+		}
+	})
+
+	t.Run("loads proof-of-work challenge settings", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("ALLOWED_ORIGINS", "http://localhost:3000")
+		t.Setenv("POW_CHALLENGE", "")
+		t.Setenv("POW_DIFFICULTY", "")
+		t.Setenv("POW_MAX_DIFFICULTY", "")
+		t.Setenv("POW_SPIKE_RATE", "")
+		t.Setenv("POW_SECRET", "")
+
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		if cfg.Challenge != nil {
+			t.Fatalf("expected the challenge to be off by default, got %+v", cfg.Challenge)
+		}
+
+		t.Setenv("POW_CHALLENGE", "true")
+		t.Setenv("POW_DIFFICULTY", "24")
+		t.Setenv("POW_SECRET", "shared")
+		cfg, err = loadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		if cfg.Challenge == nil || cfg.Challenge.Difficulty != 24 || cfg.Challenge.MaxDifficulty != 24 || string(cfg.Challenge.Secret) != "shared" {
+			t.Fatalf("expected 24 bits with the ceiling raised to match, got %+v", cfg.Challenge)
+		}
+
+		t.Setenv("POW_MAX_DIFFICULTY", "20")
+		if _, err := loadConfig(); err == nil {
+			t.Fatal("expected error for a ceiling below the difficulty")
+		}
+
+		t.Setenv("POW_MAX_DIFFICULTY", "")
+		t.Setenv("POW_DIFFICULTY", "64")
+		if _, err := loadConfig(); err == nil {
+			t.Fatal("expected error for an unsolvable difficulty")
+		}
+	})
+
+	t.Run("loads IP list file", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("ALLOWED_ORIGINS", "http://localhost:3000")
+		t.Setenv("IP_LIST_FILE", " /etc/ephemeral/iplist ")
+
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		if cfg.IPListFile != "/etc/ephemeral/iplist" {
+			t.Fatalf("expected IP list file to be trimmed, got %q", cfg.IPListFile)
+		}
+	})
 }
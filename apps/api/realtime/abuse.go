@@ -0,0 +1,355 @@
+package realtime
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Abuse signals, as they appear in the audit trail.
+const (
+	SignalRepetition = "repetition"
+	SignalCadence    = "cadence"
+	SignalChurn      = "churn"
+)
+
+// Actions the abuse detector takes, as they appear in the audit trail.
+const (
+	ActionNone       = "none"
+	ActionMute       = "mute"
+	ActionDisconnect = "disconnect"
+)
+
+const (
+	// minRepeatedRunes keeps short replies like "ok" or "lol" from counting
+	// as repetition.
+	minRepeatedRunes = 4
+
+	// maxCadenceGap is the longest pause still counted as part of a typing
+	// run when measuring cadence.
+	maxCadenceGap = time.Second
+
+	// auditSize is how many audit entries the detector keeps.
+	auditSize = 256
+
+	// maxChurnAddresses bounds the connection history kept per address
+	// before stale addresses are swept.
+	maxChurnAddresses = 4096
+)
+
+// AbuseThresholds tunes the abuse detector. Each signal adds its weight to
+// the client's score, which halves every DecayHalfLife; a score reaching
+// MuteScore mutes the client for Cooldown and one reaching DisconnectScore
+// disconnects it and refuses its identity key, or else its address, for
+// Cooldown.
+type AbuseThresholds struct {
+	// RepeatHistory is how many recent messages a paste or a finished
+	// composition is compared against.
+	RepeatHistory int
+	// CadenceWindow is how many keystroke gaps are measured at once, and
+	// CadenceMinVariation the coefficient of variation below which they are
+	// too regular for a person.
+	CadenceWindow       int
+	CadenceMinVariation float64
+	// ChurnLimit is how many connections one address may open per
+	// ChurnWindow.
+	ChurnLimit  int
+	ChurnWindow time.Duration
+	// Exempt addresses, such as offices and carrier NATs, are shared by
+	// too many people to refuse or to count connections from.
+	Exempt []netip.Prefix
+
+	RepetitionWeight float64
+	CadenceWeight    float64
+	MuteScore        float64
+	DisconnectScore  float64
+	DecayHalfLife    time.Duration
+	Cooldown         time.Duration
+}
+
+// DefaultAbuseThresholds returns thresholds that leave people typing normally
+// alone.
+func DefaultAbuseThresholds() AbuseThresholds {
+	return AbuseThresholds{
+		RepeatHistory:       8,
+		CadenceWindow:       24,
+		CadenceMinVariation: 0.1,
+		ChurnLimit:          10,
+		ChurnWindow:         time.Minute,
+		RepetitionWeight:    1,
+		CadenceWeight:       2,
+		MuteScore:           3,
+		DisconnectScore:     6,
+		DecayHalfLife:       time.Minute,
+		Cooldown:            2 * time.Minute,
+	}
+}
+
+// AuditEntry records one abuse signal and what the detector did about it.
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	UserID string    `json:"userId,omitempty"`
+	IP     string    `json:"ip,omitempty"`
+	Signal string    `json:"signal"`
+	Detail string    `json:"detail"`
+	Score  float64   `json:"score"`
+	Action string    `json:"action"`
+}
+
+// abuseState is what the detector remembers about one client.
+type abuseState struct {
+	mu        sync.Mutex
+	score     float64
+	scoredAt  time.Time
+	recent    []string
+	lastKey   time.Time
+	lastChar  string
+	gaps      []time.Duration
+	mutedTill time.Time
+}
+
+// abuseDetector scores clients on spam-like behaviour and cools them down.
+type abuseDetector struct {
+	limits     AbuseThresholds
+	moderation *Moderation
+	now        func() time.Time
+
+	mu       sync.Mutex
+	connects map[string][]time.Time
+	audit    []AuditEntry
+	next     int
+}
+
+func newAbuseDetector(limits AbuseThresholds, moderation *Moderation) *abuseDetector {
+	return &abuseDetector{
+		limits:     limits,
+		moderation: moderation,
+		now:        time.Now,
+		connects:   make(map[string][]time.Time),
+	}
+}
+
+// observe scores a relayable message from c before it is relayed. composed is
+// the client's composition before the message applies. It reports whether the
+// detector cut the client off, in which case the message is dropped.
+func (d *abuseDetector) observe(c *Client, msgType, char, composed string) bool {
+	now := d.now()
+	state := &c.abuse
+
+	state.mu.Lock()
+	var signal, detail string
+	var weight float64
+
+	switch {
+	case msgType == "typing_update" && utf8.RuneCountInString(char) >= minRepeatedRunes:
+		if state.repeated(char, d.limits.RepeatHistory) {
+			signal, detail, weight = SignalRepetition, "pasted text seen recently", d.limits.RepetitionWeight
+		}
+	case msgType == "typing_clear" && utf8.RuneCountInString(composed) >= minRepeatedRunes:
+		if state.repeated(composed, d.limits.RepeatHistory) {
+			signal, detail, weight = SignalRepetition, "message seen recently", d.limits.RepetitionWeight
+		}
+	}
+
+	// Only typed characters are timed: a held backspace or key repeats at
+	// the keyboard's own even rate, however human the typist.
+	if msgType == "typing_update" {
+		cv, ok := state.keystroke(now, char, d.limits.CadenceWindow)
+		if signal == "" && ok && cv < d.limits.CadenceMinVariation {
+			signal, detail, weight = SignalCadence, fmt.Sprintf("keystroke variation %.3f", cv), d.limits.CadenceWeight
+		}
+	}
+
+	if signal == "" {
+		state.mu.Unlock()
+		return false
+	}
+
+	score := state.add(now, weight, d.limits.DecayHalfLife)
+	action := ActionNone
+	switch {
+	case score >= d.limits.DisconnectScore:
+		action = ActionDisconnect
+	case score >= d.limits.MuteScore && now.After(state.mutedTill):
+		action = ActionMute
+		state.mutedTill = now.Add(d.limits.Cooldown)
+	}
+	state.mu.Unlock()
+
+	d.record(AuditEntry{
+		Time:   now,
+		UserID: c.userID,
+		IP:     c.peer.IP(),
+		Signal: signal,
+		Detail: detail,
+		Score:  score,
+		Action: action,
+	})
+
+	switch action {
+	case ActionMute:
+		d.sanction(SanctionMute, subjectUser+c.userID, signal, now)
+		return true
+	case ActionDisconnect:
+		if subject, ok := c.peer.sanctionSubject(d.limits.Exempt); ok {
+			d.sanction(SanctionBan, subject, signal, now)
+		}
+		c.hub.unregister <- c
+		return true
+	}
+	return false
+}
+
+// connected records a new connection from c's address and reports whether
+// the address is churning and the client should be turned away.
+func (d *abuseDetector) connected(c *Client) bool {
+	ip := c.peer.IP()
+	if ip == "" || d.limits.ChurnLimit <= 0 || exemptIP(ip, d.limits.Exempt) {
+		return false
+	}
+
+	now := d.now()
+	since := now.Add(-d.limits.ChurnWindow)
+
+	d.mu.Lock()
+	if len(d.connects) >= maxChurnAddresses {
+		for addr, times := range d.connects {
+			if times[len(times)-1].Before(since) {
+				delete(d.connects, addr)
+			}
+		}
+	}
+
+	times := d.connects[ip]
+	times = slices.DeleteFunc(times, func(t time.Time) bool { return t.Before(since) })
+	times = append(times, now)
+	d.connects[ip] = times
+	count := len(times)
+	d.mu.Unlock()
+
+	if count <= d.limits.ChurnLimit {
+		return false
+	}
+
+	d.record(AuditEntry{
+		Time:   now,
+		UserID: c.userID,
+		IP:     ip,
+		Signal: SignalChurn,
+		Detail: fmt.Sprintf("%d connections in %s", count, d.limits.ChurnWindow),
+		Action: ActionDisconnect,
+	})
+	if subject, ok := c.peer.sanctionSubject(d.limits.Exempt); ok {
+		d.sanction(SanctionBan, subject, SignalChurn, now)
+	}
+	return true
+}
+
+// sanction applies a cool-down at once and saves it in the background, since
+// churn is caught on the hub loop.
+func (d *abuseDetector) sanction(kind SanctionKind, subject, signal string, now time.Time) {
+	err := d.moderation.addInBackground(Sanction{
+		Kind:      kind,
+		Subject:   subject,
+		Reason:    "automatic: " + signal,
+		CreatedAt: now,
+		ExpiresAt: now.Add(d.limits.Cooldown),
+	})
+	if err != nil {
+		log.Printf("Error applying automatic %s on %s: %v", kind, subject, err)
+	}
+}
+
+func (d *abuseDetector) record(entry AuditEntry) {
+	log.Printf("Abuse: %s from %s (%s): %s, score %.2f, %s",
+		entry.Signal, entry.UserID, entry.IP, entry.Detail, entry.Score, entry.Action)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.audit) < auditSize {
+		d.audit = append(d.audit, entry)
+		return
+	}
+	d.audit[d.next] = entry
+	d.next = (d.next + 1) % auditSize
+}
+
+// auditTrail returns the kept audit entries, oldest first.
+func (d *abuseDetector) auditTrail() []AuditEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	trail := make([]AuditEntry, 0, len(d.audit))
+	trail = append(trail, d.audit[d.next:]...)
+	return append(trail, d.audit[:d.next]...)
+}
+
+// repeated reports whether text matches one of the last history messages,
+// and remembers it.
+func (s *abuseState) repeated(text string, history int) bool {
+	seen := slices.Contains(s.recent, text)
+	s.recent = append(s.recent, text)
+	if over := len(s.recent) - history; over > 0 {
+		s.recent = s.recent[over:]
+	}
+	return seen
+}
+
+// keystroke records char typed at now. Once window gaps within a typing run
+// have been measured it returns their coefficient of variation and starts a
+// new window. A character repeating the last one isn't measured, since key
+// auto-repeat is as even as any bot.
+func (s *abuseState) keystroke(now time.Time, char string, window int) (float64, bool) {
+	last := s.lastKey
+	s.lastKey = now
+	repeat := char == s.lastChar
+	s.lastChar = char
+	if last.IsZero() || repeat || window <= 1 {
+		return 0, false
+	}
+
+	gap := now.Sub(last)
+	if gap > maxCadenceGap {
+		s.gaps = s.gaps[:0]
+		return 0, false
+	}
+
+	s.gaps = append(s.gaps, gap)
+	if len(s.gaps) < window {
+		return 0, false
+	}
+
+	var mean float64
+	for _, g := range s.gaps {
+		mean += float64(g)
+	}
+	mean /= float64(len(s.gaps))
+
+	var variance float64
+	for _, g := range s.gaps {
+		variance += (float64(g) - mean) * (float64(g) - mean)
+	}
+	variance /= float64(len(s.gaps))
+	s.gaps = s.gaps[:0]
+
+	if mean == 0 {
+		return 0, true
+	}
+	return math.Sqrt(variance) / mean, true
+}
+
+// add decays the score to now and adds weight to it.
+func (s *abuseState) add(now time.Time, weight float64, halfLife time.Duration) float64 {
+	if !s.scoredAt.IsZero() && halfLife > 0 {
+		s.score *= math.Exp2(-float64(now.Sub(s.scoredAt)) / float64(halfLife))
+	}
+	s.score += weight
+	s.scoredAt = now
+	return s.score
+}
@@ -0,0 +1,261 @@
+package realtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock hands out times the given steps apart in turn, starting from
+// now.
+func fakeClock(steps ...time.Duration) func() time.Time {
+	now := time.Now()
+	next := 0
+	return func() time.Time {
+		now = now.Add(steps[next%len(steps)])
+		next++
+		return now
+	}
+}
+
+func TestAbuseState_KeystrokeVariation(t *testing.T) {
+	var robot abuseState
+	now := time.Now()
+	var cv float64
+	var ok bool
+	for i := range 5 {
+		now = now.Add(100 * time.Millisecond)
+		cv, ok = robot.keystroke(now, string(rune('a'+i)), 4)
+	}
+	if !ok || cv != 0 {
+		t.Fatalf("regular typing: cv = %v (ok %v), want 0", cv, ok)
+	}
+
+	var person abuseState
+	now = time.Now()
+	for i, gap := range []time.Duration{0, 80, 250, 120, 400} {
+		now = now.Add(gap * time.Millisecond)
+		cv, ok = person.keystroke(now, string(rune('a'+i)), 4)
+	}
+	if !ok || cv < 0.3 {
+		t.Fatalf("irregular typing: cv = %v (ok %v), want well above 0.3", cv, ok)
+	}
+
+	var paused abuseState
+	now = time.Now()
+	for i, gap := range []time.Duration{0, 100, 100, 5000, 100, 100} {
+		now = now.Add(gap * time.Millisecond)
+		if _, ok = paused.keystroke(now, string(rune('a'+i)), 4); ok {
+			t.Fatal("expected a long pause to restart the window")
+		}
+	}
+
+	var held abuseState
+	now = time.Now()
+	for range 20 {
+		now = now.Add(33 * time.Millisecond)
+		if _, ok = held.keystroke(now, "a", 4); ok {
+			t.Fatal("expected a held key not to be measured")
+		}
+	}
+}
+
+func newAbuseHub(t *testing.T, limits AbuseThresholds) (*Hub, *Client, *Client) {
+	t.Helper()
+
+	h := NewHub(WithAbuseHeuristics(limits))
+	h.abuse.now = fakeClock(50*time.Millisecond, 300*time.Millisecond, 120*time.Millisecond)
+	go h.Run()
+
+	sender := newTestClient(h, "sender-1", 64)
+	sender.peer = Peer{RemoteAddr: "203.0.113.7:5000"}
+	receiver := newTestClient(h, "receiver-1", 64)
+	h.Register(sender)
+	h.Register(receiver)
+	drainChannel(sender.send)
+	drainChannel(receiver.send)
+
+	return h, sender, receiver
+}
+
+func TestAbuse_RepeatedMessagesMuteTheSender(t *testing.T) {
+	h, sender, _ := newAbuseHub(t, DefaultAbuseThresholds())
+
+	for range 5 {
+		typeText(sender, "buy cheap followers")
+		sender.handleMessage([]byte(`{"type":"typing_clear"}`))
+	}
+	drainChannel(sender.send)
+
+	if _, ok := sender.sanction(SanctionMute); !ok {
+		t.Fatal("expected repeated messages to mute the sender")
+	}
+
+	sender.handleMessage([]byte(`{"type":"typing_update","char":"x"}`))
+	var reply ErrorMessage
+	if err := json.Unmarshal(readWithTimeout(sender.send, 200*time.Millisecond), &reply); err != nil {
+		t.Fatalf("failed to decode reply: %v", err)
+	}
+	if reply.Code != "muted" {
+		t.Fatalf("expected a muted error, got %+v", reply)
+	}
+
+	trail := h.Audit()
+	if len(trail) != 4 {
+		t.Fatalf("expected 4 audit entries, got %+v", trail)
+	}
+	last := trail[len(trail)-1]
+	if last.Signal != SignalRepetition || last.Action != ActionMute || last.UserID != "sender-1" || last.IP != "203.0.113.7" {
+		t.Fatalf("unexpected audit entry %+v", last)
+	}
+}
+
+func TestAbuse_RobotCadenceDisconnects(t *testing.T) {
+	limits := DefaultAbuseThresholds()
+	limits.CadenceWindow = 4
+	limits.MuteScore = 100
+	limits.DecayHalfLife = 0
+	h, sender, _ := newAbuseHub(t, limits)
+	h.abuse.now = fakeClock(100 * time.Millisecond)
+
+	// Three windows of perfectly even keystrokes add 2 points each.
+	typeText(sender, "abcdefghijklmno")
+
+	deadline := time.Now().Add(time.Second)
+	for !sender.isClosed() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the client to be disconnected, audit: %+v", h.Audit())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if _, ok := h.moderation.Banned(sender.peer); !ok {
+		t.Fatal("expected the address to be cooled down")
+	}
+	trail := h.Audit()
+	if last := trail[len(trail)-1]; last.Signal != SignalCadence || last.Action != ActionDisconnect {
+		t.Fatalf("unexpected audit entry %+v", last)
+	}
+}
+
+func TestAbuse_AutoRepeatIsNotSanctioned(t *testing.T) {
+	limits := DefaultAbuseThresholds()
+	limits.CadenceWindow = 4
+	limits.DecayHalfLife = 0
+	h, sender, _ := newAbuseHub(t, limits)
+	h.abuse.now = fakeClock(33 * time.Millisecond)
+
+	// A person holds down a key, then backspace to take it all back.
+	typeText(sender, "no")
+	typeText(sender, strings.Repeat("o", 60))
+	for range 62 {
+		sender.handleMessage([]byte(`{"type":"typing_back"}`))
+	}
+
+	if _, ok := sender.sanction(SanctionMute); ok {
+		t.Fatalf("expected key auto-repeat not to mute, audit: %+v", h.Audit())
+	}
+	if sender.isClosed() {
+		t.Fatalf("expected key auto-repeat not to disconnect, audit: %+v", h.Audit())
+	}
+	if trail := h.Audit(); len(trail) != 0 {
+		t.Fatalf("expected no audit entries, got %+v", trail)
+	}
+}
+
+func TestAbuse_ConnectionChurnIsRefused(t *testing.T) {
+	limits := DefaultAbuseThresholds()
+	limits.ChurnLimit = 3
+	h := NewHub(WithAbuseHeuristics(limits))
+	go h.Run()
+
+	var clients []*Client
+	for i := range 4 {
+		c := newTestClient(h, fmt.Sprintf("churn-%d", i), 10)
+		c.peer = Peer{RemoteAddr: fmt.Sprintf("198.51.100.9:%d", 4000+i)}
+		h.Register(c)
+		clients = append(clients, c)
+	}
+
+	if got := len(h.Clients()); got != 3 {
+		t.Fatalf("got %d clients, want 3", got)
+	}
+	if !clients[3].isClosed() {
+		t.Fatal("expected the connection over the limit to be closed")
+	}
+	if _, ok := h.moderation.Banned(Peer{RemoteAddr: "198.51.100.9:1"}); !ok {
+		t.Fatal("expected the churning address to be cooled down")
+	}
+
+	other := newTestClient(h, "other", 10)
+	other.peer = Peer{RemoteAddr: "198.51.100.10:4000"}
+	h.Register(other)
+	if got := len(h.Clients()); got != 4 {
+		t.Fatalf("got %d clients, want other addresses unaffected", got)
+	}
+}
+
+func TestAbuse_DisconnectPrefersIdentityAndSparesExemptAddresses(t *testing.T) {
+	limits := DefaultAbuseThresholds()
+	limits.CadenceWindow = 4
+	limits.MuteScore = 100
+	limits.DecayHalfLife = 0
+	limits.Exempt = []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+
+	for _, tt := range []struct {
+		name       string
+		peer       Peer
+		bystander  Peer
+		wantBanned bool
+	}{
+		{"identity", Peer{RemoteAddr: "203.0.113.7:5000", IdentityKey: "bot"}, Peer{RemoteAddr: "203.0.113.7:6000"}, true},
+		{"exempt address", Peer{RemoteAddr: "192.0.2.1:5000"}, Peer{RemoteAddr: "192.0.2.1:6000"}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h, sender, _ := newAbuseHub(t, limits)
+			h.abuse.now = fakeClock(100 * time.Millisecond)
+			sender.peer = tt.peer
+
+			typeText(sender, "abcdefghijklmno")
+
+			deadline := time.Now().Add(time.Second)
+			for !sender.isClosed() {
+				if time.Now().After(deadline) {
+					t.Fatalf("expected the client to be disconnected, audit: %+v", h.Audit())
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			if _, ok := h.moderation.Banned(sender.peer); ok != tt.wantBanned {
+				t.Fatalf("sender banned = %v, want %v", ok, tt.wantBanned)
+			}
+			if _, ok := h.moderation.Banned(tt.bystander); ok {
+				t.Fatal("expected others sharing the address to be left alone")
+			}
+		})
+	}
+}
+
+func TestAbuse_ExemptAddressesAreNotCountedForChurn(t *testing.T) {
+	limits := DefaultAbuseThresholds()
+	limits.ChurnLimit = 1
+	limits.Exempt = []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+	h := NewHub(WithAbuseHeuristics(limits))
+	go h.Run()
+
+	for i := range 3 {
+		c := newTestClient(h, fmt.Sprintf("office-%d", i), 10)
+		c.peer = Peer{RemoteAddr: fmt.Sprintf("192.0.2.1:%d", 4000+i)}
+		h.Register(c)
+	}
+
+	if got := len(h.Clients()); got != 3 {
+		t.Fatalf("got %d clients, want every connection from the office", got)
+	}
+	if _, ok := h.moderation.Banned(Peer{RemoteAddr: "192.0.2.1:1"}); ok {
+		t.Fatal("expected the exempt address not to be banned")
+	}
+}
@@ -0,0 +1,504 @@
+package realtime
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxAnnouncementRunes bounds operator announcements to what a client can
+// comfortably show in a banner.
+const maxAnnouncementRunes = 280
+
+// ClientInfo describes one live connection for operators.
+type ClientInfo struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	IP          string    `json:"ip"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	Status      string    `json:"status"`
+	BufferDepth int       `json:"bufferDepth"`
+	BufferCap   int       `json:"bufferCap"`
+	MessagesIn  uint64    `json:"messagesIn"`
+	MessagesOut uint64    `json:"messagesOut"`
+	Coalesced   uint64    `json:"coalesced"`
+	IdentityKey string    `json:"identityKey,omitempty"`
+	Muted       bool      `json:"muted"`
+	ShadowBan   bool      `json:"shadowBanned"`
+}
+
+// Clients lists the hub's live connections, oldest first.
+func (h *Hub) Clients() []ClientInfo {
+	var infos []ClientInfo
+	h.do(func() {
+		infos = make([]ClientInfo, 0, len(h.clients))
+		for client := range h.clients {
+			infos = append(infos, client.info())
+		}
+	})
+
+	slices.SortFunc(infos, func(a, b ClientInfo) int {
+		if c := a.ConnectedAt.Compare(b.ConnectedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return infos
+}
+
+// Kick disconnects the client with userID, reporting whether it was connected.
+func (h *Hub) Kick(userID string) bool {
+	var found bool
+	h.do(func() {
+		if client := h.client(userID); client != nil {
+			log.Printf("Kicking client %s", userID)
+			h.remove(client)
+			found = true
+		}
+	})
+	return found
+}
+
+// Subjects returns what a sanction of kind against the client with userID
+// should target: its user ID for mutes and shadow-bans, which last as long as
+// the connection, and its address and identity key for bans.
+func (h *Hub) Subjects(userID string, kind SanctionKind) ([]string, bool) {
+	var subjects []string
+	h.do(func() {
+		if client := h.client(userID); client != nil {
+			if kind == SanctionBan {
+				subjects = client.peer.subjects()
+			} else {
+				subjects = []string{subjectUser + client.userID}
+			}
+		}
+	})
+	return subjects, subjects != nil
+}
+
+// Enforce disconnects every client a ban covers and returns how many it
+// dropped. Other sanctions take effect on the client's next message.
+func (h *Hub) Enforce(s Sanction) int {
+	if s.Kind != SanctionBan {
+		return 0
+	}
+
+	var kicked int
+	h.do(func() {
+		for client := range h.clients {
+			if slices.Contains(client.subjects(), s.Subject) {
+				log.Printf("Disconnecting banned client %s (%s)", client.userID, s.Subject)
+				h.remove(client)
+				kicked++
+			}
+		}
+	})
+	return kicked
+}
+
+// Announce broadcasts a system announcement to every client.
+func (h *Hub) Announce(text string) {
+	h.BroadcastMessageExcept(nil, AnnouncementMessage{Type: "announcement", Text: text})
+}
+
+// Audit returns what the abuse detector has flagged recently, oldest first.
+func (h *Hub) Audit() []AuditEntry {
+	if h.abuse == nil {
+		return []AuditEntry{}
+	}
+	return h.abuse.auditTrail()
+}
+
+// client finds a connected client by user ID. It must run on the Run loop.
+func (h *Hub) client(userID string) *Client {
+	for client := range h.clients {
+		if client.userID == userID {
+			return client
+		}
+	}
+	return nil
+}
+
+func (c *Client) info() ClientInfo {
+	c.stateMu.RLock()
+	status := c.status
+	c.stateMu.RUnlock()
+
+	_, muted := c.sanction(SanctionMute)
+	_, shadowBanned := c.sanction(SanctionShadowBan)
+
+	return ClientInfo{
+		ID:          c.userID,
+		Name:        c.Identity().Name,
+		RemoteAddr:  c.peer.RemoteAddr,
+		IP:          c.peer.IP(),
+		ConnectedAt: c.connectedAt,
+		Status:      status,
+		BufferDepth: len(c.send),
+		BufferCap:   cap(c.send),
+		MessagesIn:  c.messagesIn.Load(),
+		MessagesOut: c.messagesOut.Load(),
+		Coalesced:   c.messagesCoalesced.Load(),
+		IdentityKey: c.peer.IdentityKey,
+		Muted:       muted,
+		ShadowBan:   shadowBanned,
+	}
+}
+
+// AdminServer is the operator API for inspecting and managing live
+// connections. Every request must carry the configured bearer token.
+type AdminServer struct {
+	token      string
+	moderation *Moderation
+	ipList     *IPList
+	rooms      map[string]*Hub
+	mux        *http.ServeMux
+}
+
+// NewAdminServer serves the given rooms, keyed by name, and the sanction and
+// IP lists they share to requests authenticated with token.
+func NewAdminServer(token string, moderation *Moderation, ipList *IPList, rooms map[string]*Hub) *AdminServer {
+	s := &AdminServer{
+		token:      token,
+		moderation: moderation,
+		ipList:     ipList,
+		rooms:      rooms,
+		mux:        http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("GET /admin/rooms", s.listRooms)
+	s.mux.HandleFunc("GET /admin/rooms/{room}/clients", s.room(s.listClients))
+	s.mux.HandleFunc("POST /admin/rooms/{room}/clients/{id}/kick", s.room(s.kick))
+	s.mux.HandleFunc("PUT /admin/rooms/{room}/clients/{id}/{kind}", s.room(s.sanctionClient))
+	s.mux.HandleFunc("DELETE /admin/rooms/{room}/clients/{id}/{kind}", s.room(s.liftClientSanction))
+	s.mux.HandleFunc("POST /admin/rooms/{room}/announce", s.room(s.announce))
+	s.mux.HandleFunc("GET /admin/rooms/{room}/audit", s.room(s.audit))
+	s.mux.HandleFunc("GET /admin/rooms/{room}/events", s.room(s.streamEvents))
+	s.mux.Handle("GET /debug/vars", expvar.Handler())
+	s.mux.HandleFunc("GET /admin/sanctions", s.listSanctions)
+	s.mux.HandleFunc("POST /admin/sanctions", s.addSanction)
+	s.mux.HandleFunc("DELETE /admin/sanctions/{kind}/{subject}", s.removeSanction)
+	s.mux.HandleFunc("GET /admin/iplist", s.showIPList)
+	s.mux.HandleFunc("POST /admin/iplist/reload", s.reloadIPList)
+
+	return s
+}
+
+func (s *AdminServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *AdminServer) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || s.token == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// room resolves the {room} path value before calling next.
+func (s *AdminServer) room(next func(http.ResponseWriter, *http.Request, *Hub)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hub, ok := s.rooms[r.PathValue("room")]
+		if !ok {
+			http.Error(w, "room not found", http.StatusNotFound)
+			return
+		}
+
+		next(w, r, hub)
+	}
+}
+
+type roomInfo struct {
+	Name    string `json:"name"`
+	Clients int    `json:"clients"`
+}
+
+func (s *AdminServer) listRooms(w http.ResponseWriter, r *http.Request) {
+	rooms := make([]roomInfo, 0, len(s.rooms))
+	for name, hub := range s.rooms {
+		rooms = append(rooms, roomInfo{Name: name, Clients: int(hub.population.Load())})
+	}
+	slices.SortFunc(rooms, func(a, b roomInfo) int { return strings.Compare(a.Name, b.Name) })
+
+	writeJSON(w, rooms)
+}
+
+func (s *AdminServer) listClients(w http.ResponseWriter, r *http.Request, hub *Hub) {
+	writeJSON(w, hub.Clients())
+}
+
+func (s *AdminServer) audit(w http.ResponseWriter, r *http.Request, hub *Hub) {
+	writeJSON(w, hub.Audit())
+}
+
+// streamEvents lists the room's recent admin events, or streams them and every
+// later one as Server-Sent Events when the client asks for text/event-stream.
+func (s *AdminServer) streamEvents(w http.ResponseWriter, r *http.Request, hub *Hub) {
+	backlog, events, cancel := hub.events.subscribe()
+	defer cancel()
+
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		if backlog == nil {
+			backlog = []AdminEvent{}
+		}
+		writeJSON(w, backlog)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(e AdminEvent) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return writeEvent(w, rc, data)
+	}
+
+	for _, e := range backlog {
+		if err := send(e); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case e := <-events:
+			if err := send(e); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *AdminServer) kick(w http.ResponseWriter, r *http.Request, hub *Hub) {
+	if !hub.Kick(r.PathValue("id")) {
+		http.Error(w, "client not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sanctionRequest is the body of a request to sanction someone. Duration is a
+// Go duration; empty means the sanction never expires.
+type sanctionRequest struct {
+	Kind     SanctionKind `json:"kind"`
+	Subject  string       `json:"subject"`
+	Reason   string       `json:"reason"`
+	Duration string       `json:"duration"`
+}
+
+func (req sanctionRequest) sanction(now time.Time) (Sanction, error) {
+	s := Sanction{
+		Kind:      req.Kind,
+		Subject:   req.Subject,
+		Reason:    strings.TrimSpace(req.Reason),
+		CreatedAt: now,
+	}
+
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			return Sanction{}, errors.New("duration must be a positive Go duration such as 10m")
+		}
+		s.ExpiresAt = now.Add(d)
+	}
+
+	return s, s.validate()
+}
+
+// decodeSanction reads a sanction request. An empty body is allowed for the
+// client routes, which take everything else from the path.
+func decodeSanction(r *http.Request) (sanctionRequest, error) {
+	var req sanctionRequest
+	err := json.NewDecoder(io.LimitReader(r.Body, maxMessageSize)).Decode(&req)
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return req, err
+}
+
+func (s *AdminServer) sanctionClient(w http.ResponseWriter, r *http.Request, hub *Hub) {
+	req, err := decodeSanction(r)
+	if err != nil {
+		http.Error(w, "invalid sanction", http.StatusBadRequest)
+		return
+	}
+	req.Kind = SanctionKind(r.PathValue("kind"))
+
+	subjects, ok := hub.Subjects(r.PathValue("id"), req.Kind)
+	if !ok {
+		http.Error(w, "client not found", http.StatusNotFound)
+		return
+	}
+
+	added := make([]Sanction, 0, len(subjects))
+	for _, subject := range subjects {
+		req.Subject = subject
+		sanction, ok := s.add(w, req)
+		if !ok {
+			return
+		}
+		added = append(added, sanction)
+	}
+
+	writeJSON(w, added)
+}
+
+func (s *AdminServer) liftClientSanction(w http.ResponseWriter, r *http.Request, hub *Hub) {
+	kind := SanctionKind(r.PathValue("kind"))
+	if kind == SanctionBan {
+		http.Error(w, "lift bans through /admin/sanctions", http.StatusBadRequest)
+		return
+	}
+
+	subjects, ok := hub.Subjects(r.PathValue("id"), kind)
+	if !ok {
+		http.Error(w, "client not found", http.StatusNotFound)
+		return
+	}
+
+	s.remove(w, kind, subjects[0])
+}
+
+func (s *AdminServer) listSanctions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.moderation.List())
+}
+
+func (s *AdminServer) addSanction(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeSanction(r)
+	if err != nil {
+		http.Error(w, "invalid sanction", http.StatusBadRequest)
+		return
+	}
+
+	if sanction, ok := s.add(w, req); ok {
+		// writeJSON sets the content type too late once the status is out.
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, sanction)
+	}
+}
+
+func (s *AdminServer) showIPList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.ipList.Status())
+}
+
+// reloadIPList rereads the IP list file now rather than waiting for the
+// watcher to notice it changed.
+func (s *AdminServer) reloadIPList(w http.ResponseWriter, r *http.Request) {
+	if err := s.ipList.Reload(); err != nil {
+		log.Printf("Error reloading IP list: %v", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	writeJSON(w, s.ipList.Status())
+}
+
+func (s *AdminServer) removeSanction(w http.ResponseWriter, r *http.Request) {
+	s.remove(w, SanctionKind(r.PathValue("kind")), r.PathValue("subject"))
+}
+
+// add records req and disconnects whoever a ban covers. It writes the error
+// response itself when it fails.
+func (s *AdminServer) add(w http.ResponseWriter, req sanctionRequest) (Sanction, bool) {
+	sanction, err := req.sanction(s.moderation.now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return Sanction{}, false
+	}
+
+	if err := s.moderation.Add(sanction); err != nil {
+		log.Printf("Error saving sanction on %s: %v", sanction.Subject, err)
+		http.Error(w, "could not save sanction", http.StatusInternalServerError)
+		return Sanction{}, false
+	}
+
+	log.Printf("Moderation: %s on %s (%s)", sanction.Kind, sanction.Subject, sanction.Reason)
+	for _, hub := range s.rooms {
+		hub.Enforce(sanction)
+	}
+	return sanction, true
+}
+
+func (s *AdminServer) remove(w http.ResponseWriter, kind SanctionKind, subject string) {
+	removed, err := s.moderation.Remove(kind, subject)
+	if err != nil {
+		log.Printf("Error saving sanctions after lifting %s on %s: %v", kind, subject, err)
+		http.Error(w, "could not save sanctions", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "sanction not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Moderation: lifted %s on %s", kind, subject)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type announceRequest struct {
+	Text string `json:"text"`
+}
+
+func (s *AdminServer) announce(w http.ResponseWriter, r *http.Request, hub *Hub) {
+	var req announceRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxMessageSize)).Decode(&req); err != nil {
+		http.Error(w, "invalid announcement", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateAnnouncement(req.Text); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Announcing to the room: %q", req.Text)
+	hub.Announce(strings.TrimSpace(req.Text))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validateAnnouncement(text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return errors.New("announcement text is required")
+	}
+	if !utf8.ValidString(text) || utf8.RuneCountInString(text) > maxAnnouncementRunes {
+		return errors.New("announcement text must be valid UTF-8 of at most 280 characters")
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding admin response: %v", err)
+	}
+}
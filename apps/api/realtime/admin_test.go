@@ -0,0 +1,258 @@
+package realtime
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAdminToken = "secret"
+
+func adminRequest(t *testing.T, s *AdminServer, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminServer_RejectsMissingOrWrongToken(t *testing.T) {
+	s := NewAdminServer(testAdminToken, NewModeration(), NewIPList(), map[string]*Hub{"default": NewHub()})
+
+	for _, header := range []string{"", "Bearer wrong", "Basic " + testAdminToken, testAdminToken} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/rooms", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status = %d, want %d", header, rec.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestAdminServer_ListsRoomsAndClients(t *testing.T) {
+	h, sender, receiver := setupHubWithClients(t)
+	s := NewAdminServer(testAdminToken, h.moderation, NewIPList(), map[string]*Hub{"default": h})
+
+	sender.handleMessage([]byte(`{"type":"typing_update","char":"a"}`))
+	readWithTimeout(receiver.send, 200*time.Millisecond)
+
+	rec := adminRequest(t, s, http.MethodGet, "/admin/rooms", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rooms status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var rooms []roomInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &rooms); err != nil {
+		t.Fatalf("failed to decode rooms: %v", err)
+	}
+	if len(rooms) != 1 || rooms[0].Name != "default" || rooms[0].Clients != 2 {
+		t.Fatalf("rooms = %+v, want one default room with 2 clients", rooms)
+	}
+
+	rec = adminRequest(t, s, http.MethodGet, "/admin/rooms/default/clients", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("clients status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var clients []ClientInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &clients); err != nil {
+		t.Fatalf("failed to decode clients: %v", err)
+	}
+	if len(clients) != 2 {
+		t.Fatalf("got %d clients, want 2", len(clients))
+	}
+
+	byID := make(map[string]ClientInfo)
+	for _, info := range clients {
+		byID[info.ID] = info
+	}
+	if got := byID["sender-1"].MessagesIn; got != 1 {
+		t.Errorf("sender messagesIn = %d, want 1", got)
+	}
+	if got := byID["receiver-1"].MessagesOut; got == 0 {
+		t.Error("receiver messagesOut = 0, want the relayed keystroke counted")
+	}
+	if got := byID["receiver-1"].BufferCap; got != 10 {
+		t.Errorf("receiver bufferCap = %d, want 10", got)
+	}
+
+	if rec := adminRequest(t, s, http.MethodGet, "/admin/rooms/missing/clients", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown room status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestAdminServer_KickClosesTheClient(t *testing.T) {
+	h, sender, _ := setupHubWithClients(t)
+	s := NewAdminServer(testAdminToken, h.moderation, NewIPList(), map[string]*Hub{"default": h})
+
+	rec := adminRequest(t, s, http.MethodPost, "/admin/rooms/default/clients/sender-1/kick", "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("kick status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if !sender.isClosed() {
+		t.Fatal("expected the kicked client's send buffer to be closed")
+	}
+	if got := len(h.Clients()); got != 1 {
+		t.Fatalf("got %d clients after kick, want 1", got)
+	}
+
+	rec = adminRequest(t, s, http.MethodPost, "/admin/rooms/default/clients/sender-1/kick", "")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("second kick status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestAdminServer_MuteDropsTypingWithAnError(t *testing.T) {
+	h, sender, receiver := setupHubWithClients(t)
+	s := NewAdminServer(testAdminToken, h.moderation, NewIPList(), map[string]*Hub{"default": h})
+	drainChannel(sender.send)
+
+	rec := adminRequest(t, s, http.MethodPut, "/admin/rooms/default/clients/sender-1/mute", `{"reason":"spam"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("mute status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	sender.handleMessage([]byte(`{"type":"typing_update","char":"a"}`))
+	if raw := readWithTimeout(receiver.send, 100*time.Millisecond); raw != nil {
+		t.Fatalf("expected muted typing to be dropped, got %s", raw)
+	}
+
+	var reply ErrorMessage
+	if err := json.Unmarshal(readWithTimeout(sender.send, 200*time.Millisecond), &reply); err != nil {
+		t.Fatalf("failed to decode reply: %v", err)
+	}
+	if reply.Type != "error" || reply.Code != "muted" {
+		t.Fatalf("expected a muted error, got %+v", reply)
+	}
+
+	rec = adminRequest(t, s, http.MethodDelete, "/admin/rooms/default/clients/sender-1/mute", "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("unmute status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	sender.handleMessage([]byte(`{"type":"typing_update","char":"b"}`))
+	if raw := readWithTimeout(receiver.send, 200*time.Millisecond); raw == nil {
+		t.Fatal("expected typing to be relayed after unmuting")
+	}
+}
+
+func TestAdminServer_ShadowBanEchoesTypingToTheSenderOnly(t *testing.T) {
+	h, sender, receiver := setupHubWithClients(t)
+	s := NewAdminServer(testAdminToken, h.moderation, NewIPList(), map[string]*Hub{"default": h})
+	drainChannel(sender.send)
+
+	rec := adminRequest(t, s, http.MethodPut, "/admin/rooms/default/clients/sender-1/shadow_ban", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("shadow-ban status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	sender.handleMessage([]byte(`{"type":"typing_update","char":"a"}`))
+	if raw := readWithTimeout(receiver.send, 100*time.Millisecond); raw != nil {
+		t.Fatalf("expected shadow-banned typing to be hidden, got %s", raw)
+	}
+
+	var echo RelayMessage
+	if err := json.Unmarshal(readWithTimeout(sender.send, 200*time.Millisecond), &echo); err != nil {
+		t.Fatalf("failed to decode echo: %v", err)
+	}
+	if echo.Type != "typing_update" || echo.UserID != "sender-1" || echo.Char != "a" {
+		t.Fatalf("expected the keystroke echoed back, got %+v", echo)
+	}
+	if got := sender.Composition(); got != "" {
+		t.Fatalf("expected no tracked composition, got %q", got)
+	}
+}
+
+func TestAdminServer_BanDisconnectsAndIsListed(t *testing.T) {
+	moderation := NewModeration()
+	h := NewHub(WithModeration(moderation))
+	go h.Run()
+
+	s := NewAdminServer(testAdminToken, moderation, NewIPList(), map[string]*Hub{"default": h})
+
+	banned := newTestClient(h, "banned-1", 10)
+	banned.peer = Peer{RemoteAddr: "203.0.113.7:5000", IdentityKey: "browser-1"}
+	bystander := newTestClient(h, "bystander-1", 10)
+	bystander.peer = Peer{RemoteAddr: "198.51.100.2:5000"}
+	h.Register(banned)
+	h.Register(bystander)
+
+	rec := adminRequest(t, s, http.MethodPut, "/admin/rooms/default/clients/banned-1/ban", `{"duration":"1h"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ban status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !banned.isClosed() || bystander.isClosed() {
+		t.Fatal("expected only the banned client to be disconnected")
+	}
+
+	for _, peer := range []Peer{{RemoteAddr: "203.0.113.7:6000"}, {RemoteAddr: "192.0.2.1:1", IdentityKey: "browser-1"}} {
+		if _, ok := moderation.Banned(peer); !ok {
+			t.Errorf("expected %+v to be banned", peer)
+		}
+	}
+
+	rec = adminRequest(t, s, http.MethodGet, "/admin/sanctions", "")
+	var sanctions []Sanction
+	if err := json.Unmarshal(rec.Body.Bytes(), &sanctions); err != nil {
+		t.Fatalf("failed to decode sanctions: %v", err)
+	}
+	if len(sanctions) != 2 || sanctions[0].ExpiresAt.IsZero() {
+		t.Fatalf("expected two expiring bans, got %+v", sanctions)
+	}
+
+	rec = adminRequest(t, s, http.MethodDelete, "/admin/sanctions/ban/ip:203.0.113.7", "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("lift status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if _, ok := moderation.Banned(Peer{RemoteAddr: "203.0.113.7:6000"}); ok {
+		t.Fatal("expected the address ban to be lifted")
+	}
+
+	rec = adminRequest(t, s, http.MethodPost, "/admin/sanctions", `{"kind":"ban","subject":"identity:browser-2"}`)
+	if rec.Code != http.StatusCreated || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("add status = %d with %q, want %d with JSON", rec.Code, rec.Header().Get("Content-Type"), http.StatusCreated)
+	}
+
+	rec = adminRequest(t, s, http.MethodPost, "/admin/sanctions", `{"kind":"ban","subject":"nobody"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid subject status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAdminServer_AnnounceReachesEveryClient(t *testing.T) {
+	h, sender, receiver := setupHubWithClients(t)
+	s := NewAdminServer(testAdminToken, h.moderation, NewIPList(), map[string]*Hub{"default": h})
+	drainChannel(sender.send)
+
+	rec := adminRequest(t, s, http.MethodPost, "/admin/rooms/default/announce", `{"text":"  Maintenance at noon  "}`)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("announce status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	for _, c := range []*Client{sender, receiver} {
+		raw := readWithTimeout(c.send, 200*time.Millisecond)
+		if raw == nil {
+			t.Fatalf("client %s got no announcement", c.userID)
+		}
+		var msg AnnouncementMessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			t.Fatalf("failed to decode announcement: %v", err)
+		}
+		if msg.Type != "announcement" || msg.Text != "Maintenance at noon" {
+			t.Fatalf("client %s got %+v", c.userID, msg)
+		}
+	}
+
+	for _, body := range []string{`{"text":"   "}`, `not json`, `{"text":"` + strings.Repeat("a", maxAnnouncementRunes+1) + `"}`} {
+		if rec := adminRequest(t, s, http.MethodPost, "/admin/rooms/default/announce", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("announce %.20q status = %d, want %d", body, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package realtime
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// concurrentRetryAfter and capacityRetryAfter are what clients turned
+	// away for holding too many connections, or for a full server, are told
+	// to wait.
+	concurrentRetryAfter = 5 * time.Second
+	capacityRetryAfter   = 10 * time.Second
+
+	// maxRateBuckets bounds the per-address connect rate state before idle
+	// addresses are swept.
+	maxRateBuckets = 4096
+
+	// ipv6SubnetBits is how much of an IPv6 address the per-address limits
+	// count as one client. Hosts are usually handed a whole /64, which would
+	// otherwise let one rotate through fresh addresses.
+	ipv6SubnetBits = 64
+)
+
+// AdmissionLimits caps who may connect. Zero turns a limit off.
+type AdmissionLimits struct {
+	// PerIP caps concurrent connections from one address, and PerIPRate
+	// how many it may open per minute.
+	PerIP     int
+	PerIPRate int
+	// MaxClients caps connections across every room, MaxRoomClients those
+	// in one room.
+	MaxClients     int
+	MaxRoomClients int
+	// Exempt addresses skip the per-address limits, though not the capacity
+	// ones.
+	Exempt []netip.Prefix
+}
+
+// Admission decides, before a connection is upgraded, whether the server
+// has room for it. Each admitted connection holds a ticket until the hub
+// removes its client.
+type Admission struct {
+	limits AdmissionLimits
+	now    func() time.Time
+
+	mu      sync.Mutex
+	total   int
+	rooms   map[*Hub]int
+	perIP   map[netip.Addr]int         // by limitKey
+	buckets map[netip.Addr]*rateBucket // by limitKey
+
+	metrics *expvar.Map
+}
+
+// rateBucket is a token bucket refilling PerIPRate tokens a minute.
+type rateBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// admissionTicket is an admitted connection's share of the limits.
+type admissionTicket struct {
+	claimed atomic.Bool
+	once    sync.Once
+	release func()
+}
+
+type admissionKey struct{}
+
+// NewAdmission enforces limits.
+func NewAdmission(limits AdmissionLimits) *Admission {
+	a := &Admission{
+		limits:  limits,
+		now:     time.Now,
+		rooms:   make(map[*Hub]int),
+		perIP:   make(map[netip.Addr]int),
+		buckets: make(map[netip.Addr]*rateBucket),
+		metrics: new(expvar.Map).Init(),
+	}
+	for _, name := range []string{"admitted", "exempt", "rejected_ip_concurrent", "rejected_ip_rate", "rejected_global", "rejected_room"} {
+		a.metrics.Add(name, 0)
+	}
+	return a
+}
+
+// Metrics counts admitted and rejected connections by reason, for expvar.
+func (a *Admission) Metrics() expvar.Var {
+	return a.metrics
+}
+
+// Handler admits requests to next on behalf of hub, turning the rest away
+// with 429 or 503 and a Retry-After. A request whose client never reaches the
+// hub gives its ticket back when next returns.
+func (a *Admission) Handler(hub *Hub, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ticket, status, retry := a.admit(hub, PeerFromRequest(r).IP())
+		if ticket == nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), admissionKey{}, ticket)))
+		if !ticket.claimed.Load() {
+			ticket.done()
+		}
+	}
+}
+
+func (a *Admission) admit(hub *Hub, ip string) (*admissionTicket, int, time.Duration) {
+	addr, err := netip.ParseAddr(ip)
+	addr = addr.Unmap()
+	limited := err == nil && !a.exempt(addr)
+	key := limitKey(addr)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch {
+	case a.limits.MaxClients > 0 && a.total >= a.limits.MaxClients:
+		return a.reject("rejected_global", ip, http.StatusServiceUnavailable, capacityRetryAfter)
+	case a.limits.MaxRoomClients > 0 && a.rooms[hub] >= a.limits.MaxRoomClients:
+		return a.reject("rejected_room", ip, http.StatusServiceUnavailable, capacityRetryAfter)
+	case limited && a.limits.PerIP > 0 && a.perIP[key] >= a.limits.PerIP:
+		return a.reject("rejected_ip_concurrent", ip, http.StatusTooManyRequests, concurrentRetryAfter)
+	}
+	if limited && a.limits.PerIPRate > 0 {
+		if wait := a.takeToken(key); wait > 0 {
+			return a.reject("rejected_ip_rate", ip, http.StatusTooManyRequests, wait)
+		}
+	}
+
+	a.total++
+	a.rooms[hub]++
+	if limited {
+		a.perIP[key]++
+	} else {
+		a.metrics.Add("exempt", 1)
+	}
+	a.metrics.Add("admitted", 1)
+
+	return &admissionTicket{release: func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		a.total--
+		if a.rooms[hub]--; a.rooms[hub] <= 0 {
+			delete(a.rooms, hub)
+		}
+		if limited {
+			if a.perIP[key]--; a.perIP[key] <= 0 {
+				delete(a.perIP, key)
+			}
+		}
+	}}, http.StatusOK, 0
+}
+
+func (a *Admission) reject(reason, ip string, status int, retry time.Duration) (*admissionTicket, int, time.Duration) {
+	a.metrics.Add(reason, 1)
+	log.Printf("Turned away connection from %s: %s", ip, reason)
+	return nil, status, retry
+}
+
+func (a *Admission) exempt(addr netip.Addr) bool {
+	for _, prefix := range a.limits.Exempt {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitKey is what the per-address limits count addr under: the address
+// itself, or for IPv6 the /64 it belongs to.
+func limitKey(addr netip.Addr) netip.Addr {
+	if !addr.Is6() {
+		return addr
+	}
+	prefix, err := addr.Prefix(ipv6SubnetBits)
+	if err != nil {
+		return addr
+	}
+	return prefix.Addr()
+}
+
+// takeToken spends one of the connect tokens for key, or returns how long
+// until it has one. It must be called with mu held.
+func (a *Admission) takeToken(key netip.Addr) time.Duration {
+	now := a.now()
+	perSecond := float64(a.limits.PerIPRate) / 60
+	capacity := float64(a.limits.PerIPRate)
+
+	if len(a.buckets) >= maxRateBuckets {
+		for key, b := range a.buckets {
+			if b.tokens+now.Sub(b.at).Seconds()*perSecond >= capacity {
+				delete(a.buckets, key)
+			}
+		}
+	}
+
+	b, ok := a.buckets[key]
+	if !ok {
+		b = &rateBucket{tokens: capacity, at: now}
+		a.buckets[key] = b
+	}
+	b.tokens = min(capacity, b.tokens+now.Sub(b.at).Seconds()*perSecond)
+	b.at = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
+
+// ParsePrefixes parses comma-separated CIDR ranges or single addresses.
+func ParsePrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for field := range strings.SplitSeq(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if prefix, err := netip.ParsePrefix(field); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(field)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an address or CIDR range", field)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+func ticketFromContext(ctx context.Context) *admissionTicket {
+	ticket, _ := ctx.Value(admissionKey{}).(*admissionTicket)
+	return ticket
+}
+
+// claim marks the ticket as held by a registered client, which gives it back
+// when the hub removes it.
+func (t *admissionTicket) claim() {
+	if t != nil {
+		t.claimed.Store(true)
+	}
+}
+
+// done gives the ticket's share of the limits back. It is safe to call more
+// than once.
+func (t *admissionTicket) done() {
+	if t != nil {
+		t.once.Do(t.release)
+	}
+}
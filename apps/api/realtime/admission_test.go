@@ -0,0 +1,187 @@
+package realtime
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func admitRequest(a *Admission, hub *Hub, remoteAddr string, next http.HandlerFunc) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/connect", nil)
+	req.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	a.Handler(hub, next)(rec, req)
+	return rec
+}
+
+// registerClient stands in for a transport: it registers a client for the
+// admitted request.
+func registerClient(hub *Hub, clients chan<- *Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := newClient(hub)
+		c.peer = PeerFromRequest(r)
+		hub.Register(c)
+		clients <- c
+	}
+}
+
+func TestAdmission_LimitsConcurrentConnectionsPerAddress(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	a := NewAdmission(AdmissionLimits{PerIP: 2})
+
+	clients := make(chan *Client, 3)
+	for range 2 {
+		if rec := admitRequest(a, h, "203.0.113.7:1000", registerClient(h, clients)); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+	}
+
+	rec := admitRequest(a, h, "203.0.113.7:1001", registerClient(h, clients))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "5" {
+		t.Fatalf("got %d with Retry-After %q, want 429 after 5s", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := admitRequest(a, h, "198.51.100.1:1000", registerClient(h, clients)); rec.Code != http.StatusOK {
+		t.Fatalf("other address status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	// Removing a client gives its ticket back.
+	h.Kick((<-clients).userID)
+	if rec := admitRequest(a, h, "203.0.113.7:1002", registerClient(h, clients)); rec.Code != http.StatusOK {
+		t.Fatalf("status after a disconnect = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	if got := a.metrics.Get("rejected_ip_concurrent").String(); got != "1" {
+		t.Fatalf("rejected_ip_concurrent = %s, want 1", got)
+	}
+}
+
+func TestAdmission_GivesBackTicketsForFailedUpgrades(t *testing.T) {
+	a := NewAdmission(AdmissionLimits{PerIP: 1})
+	h := NewHub()
+	failed := func(w http.ResponseWriter, r *http.Request) {}
+
+	for range 3 {
+		if rec := admitRequest(a, h, "203.0.113.7:1000", failed); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestAdmission_LimitsConnectRatePerAddress(t *testing.T) {
+	a := NewAdmission(AdmissionLimits{PerIPRate: 2})
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	h := NewHub()
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+
+	for range 2 {
+		if rec := admitRequest(a, h, "203.0.113.7:1000", noop); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+	}
+
+	rec := admitRequest(a, h, "203.0.113.7:1000", noop)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "30" {
+		t.Fatalf("got %d with Retry-After %q, want 429 after 30s", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	now = now.Add(30 * time.Second)
+	if rec := admitRequest(a, h, "203.0.113.7:1000", noop); rec.Code != http.StatusOK {
+		t.Fatalf("status after refill = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestAdmission_CountsAnIPv6SubnetAsOneAddress(t *testing.T) {
+	a := NewAdmission(AdmissionLimits{PerIP: 1, PerIPRate: 1})
+	h := NewHub()
+	go h.Run()
+	clients := make(chan *Client, 3)
+
+	if rec := admitRequest(a, h, "[2001:db8:1:2::1]:1000", registerClient(h, clients)); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	// Another address in the same /64 shares the first one's limits.
+	if rec := admitRequest(a, h, "[2001:db8:1:2:ffff::9]:1000", registerClient(h, clients)); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("same /64 status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if rec := admitRequest(a, h, "[2001:db8:1:3::1]:1000", registerClient(h, clients)); rec.Code != http.StatusOK {
+		t.Fatalf("next /64 status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	h.Kick((<-clients).userID)
+	if rec := admitRequest(a, h, "[2001:db8:1:2::2]:1000", registerClient(h, clients)); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Fatalf("got %d with Retry-After %q, want the /64's connect rate spent", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestAdmission_CapsRoomsAndTheServer(t *testing.T) {
+	a := NewAdmission(AdmissionLimits{MaxClients: 3, MaxRoomClients: 2})
+	first, second := NewHub(), NewHub()
+	clients := make(chan *Client, 4)
+	go first.Run()
+	go second.Run()
+
+	for range 2 {
+		admitRequest(a, first, "203.0.113.7:1000", registerClient(first, clients))
+	}
+	rec := admitRequest(a, first, "203.0.113.8:1000", registerClient(first, clients))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "10" {
+		t.Fatalf("full room: got %d with Retry-After %q, want 503 after 10s", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	admitRequest(a, second, "203.0.113.9:1000", registerClient(second, clients))
+	if rec := admitRequest(a, second, "203.0.113.9:1000", registerClient(second, clients)); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("full server: status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestAdmission_ExemptRangesSkipPerAddressLimits(t *testing.T) {
+	exempt, err := ParsePrefixes("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatalf("parse prefixes: %v", err)
+	}
+	a := NewAdmission(AdmissionLimits{PerIP: 1, PerIPRate: 1, Exempt: exempt})
+	h := NewHub()
+	go h.Run()
+	clients := make(chan *Client, 8)
+
+	for _, addr := range []string{"10.1.2.3:1", "10.1.2.3:2", "10.1.2.3:3", "192.0.2.1:1", "192.0.2.1:2"} {
+		if rec := admitRequest(a, h, addr, registerClient(h, clients)); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d", addr, rec.Code, http.StatusOK)
+		}
+	}
+	if rec := admitRequest(a, h, "192.0.2.2:1", registerClient(h, clients)); rec.Code != http.StatusOK {
+		t.Fatalf("first connection status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := admitRequest(a, h, "192.0.2.2:2", registerClient(h, clients)); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("unlisted address status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestParsePrefixes(t *testing.T) {
+	prefixes, err := ParsePrefixes(" 10.0.0.0/8 ,, 2001:db8::1 , ::ffff:192.0.2.1")
+	if err != nil {
+		t.Fatalf("parse prefixes: %v", err)
+	}
+
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::1/128"),
+		netip.MustParsePrefix("192.0.2.1/32"),
+	}
+	if len(prefixes) != len(want) {
+		t.Fatalf("got %v, want %v", prefixes, want)
+	}
+	for i := range want {
+		if prefixes[i] != want[i] {
+			t.Fatalf("got %v, want %v", prefixes, want)
+		}
+	}
+
+	if _, err := ParsePrefixes("office"); err == nil {
+		t.Fatal("expected an error for a bad range")
+	}
+}
@@ -0,0 +1,88 @@
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+)
+
+// maxBlocks bounds how many users one client may block.
+const maxBlocks = 256
+
+var (
+	errBlock      = errors.New("block needs another user's id")
+	errBlockLimit = errors.New("too many blocked users")
+)
+
+// blocks reports whether c has blocked the user with userID. It is called for
+// every delivery, so it reads the block set without locking.
+func (c *Client) blocks(userID string) bool {
+	blocked := c.blocked.Load()
+	if blocked == nil {
+		return false
+	}
+	_, ok := (*blocked)[userID]
+	return ok
+}
+
+// setBlocked adds or removes userID from c's block set, copying the set so
+// readers never see it change. It reports whether the set changed.
+func (c *Client) setBlocked(userID string, block bool) (bool, error) {
+	c.blockMu.Lock()
+	defer c.blockMu.Unlock()
+
+	var current map[string]struct{}
+	if p := c.blocked.Load(); p != nil {
+		current = *p
+	}
+	if _, ok := current[userID]; ok == block {
+		return false, nil
+	}
+	if block && len(current) >= maxBlocks {
+		return false, errBlockLimit
+	}
+
+	next := make(map[string]struct{}, len(current)+1)
+	for id := range current {
+		next[id] = struct{}{}
+	}
+	if block {
+		next[userID] = struct{}{}
+	} else {
+		delete(next, userID)
+	}
+	c.blocked.Store(&next)
+	return true, nil
+}
+
+func (c *Client) handleBlock(data []byte) {
+	c.updateBlock(data, true)
+}
+
+func (c *Client) handleUnblock(data []byte) {
+	c.updateBlock(data, false)
+}
+
+// updateBlock applies a block or unblock message and, if it changed anything,
+// has the hub resend presence so the blocked user leaves or rejoins the list.
+func (c *Client) updateBlock(data []byte, block bool) {
+	var msg BlockMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		log.Printf("Error unmarshaling block from %s: %v", c.userID, err)
+		return
+	}
+
+	if msg.UserID == "" || msg.UserID == c.userID {
+		c.sendError("invalid_block", errBlock)
+		return
+	}
+
+	changed, err := c.setBlocked(msg.UserID, block)
+	if err != nil {
+		c.sendError("invalid_block", err)
+		return
+	}
+	if changed {
+		c.hub.presenceUpdates <- c
+	}
+}
@@ -0,0 +1,111 @@
+package realtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClient_BlockHidesTypingFromTheBlockerOnly(t *testing.T) {
+	h, sender, receiver := setupHubWithClients(t)
+	bystander := newTestClient(h, "bystander-1", 10)
+	h.Register(bystander)
+	t.Cleanup(func() { h.unregister <- bystander })
+	drainChannel(receiver.send)
+	drainChannel(bystander.send)
+
+	receiver.handleMessage([]byte(`{"type":"block","userId":"sender-1"}`))
+	drainChannel(receiver.send)
+	drainChannel(bystander.send)
+
+	sender.handleMessage([]byte(`{"type":"typing_update","char":"a"}`))
+	if raw := readWithTimeout(receiver.send, 100*time.Millisecond); raw != nil {
+		t.Fatalf("expected the blocker to get nothing, got %s", raw)
+	}
+	if raw := readWithTimeout(bystander.send, 200*time.Millisecond); raw == nil {
+		t.Fatal("expected other clients to still get the typing")
+	}
+
+	receiver.handleMessage([]byte(`{"type":"unblock","userId":"sender-1"}`))
+	drainChannel(receiver.send)
+
+	sender.handleMessage([]byte(`{"type":"typing_update","char":"b"}`))
+	var msg RelayMessage
+	if err := json.Unmarshal(readWithTimeout(receiver.send, 200*time.Millisecond), &msg); err != nil {
+		t.Fatalf("failed to decode relay: %v", err)
+	}
+	if msg.Type != "typing_update" || msg.Char != "b" {
+		t.Fatalf("expected typing after unblocking, got %+v", msg)
+	}
+}
+
+func TestClient_BlockRemovesTheUserFromPresence(t *testing.T) {
+	_, sender, receiver := setupHubWithClients(t)
+	drainChannel(sender.send)
+	drainChannel(receiver.send)
+
+	receiver.handleMessage([]byte(`{"type":"block","userId":"sender-1"}`))
+
+	if got := lastPresence(t, receiver); len(got.Users) != 0 {
+		t.Fatalf("expected the blocked user to leave the blocker's presence, got %+v", got.Users)
+	}
+	if got := lastPresence(t, sender); len(got.Users) != 1 || got.Users[0].ID != "receiver-1" {
+		t.Fatalf("expected the blocked user to still see the blocker, got %+v", got.Users)
+	}
+}
+
+func TestClient_BlockedCompositionsStayOutOfSnapshots(t *testing.T) {
+	_, sender, receiver := setupHubWithClients(t)
+
+	sender.handleMessage([]byte(`{"type":"typing_update","char":"a"}`))
+	receiver.handleMessage([]byte(`{"type":"block","userId":"sender-1"}`))
+	receiver.handleMessage([]byte(`{"type":"visibility","state":"hidden"}`))
+	receiver.handleMessage([]byte(`{"type":"visibility","state":"visible"}`))
+
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case raw := <-receiver.send:
+			var snapshot TypingSnapshotMessage
+			if json.Unmarshal(raw, &snapshot) != nil || snapshot.Type != "typing_snapshot" {
+				continue
+			}
+			if len(snapshot.Compositions) != 0 {
+				t.Fatalf("expected no compositions from blocked users, got %+v", snapshot.Compositions)
+			}
+			return
+		case <-deadline:
+			t.Fatal("expected a typing snapshot")
+		}
+	}
+}
+
+func TestClient_RejectsInvalidBlocks(t *testing.T) {
+	_, sender, _ := setupHubWithClients(t)
+	drainChannel(sender.send)
+
+	for _, msg := range []string{
+		`{"type":"block","userId":""}`,
+		`{"type":"block","userId":"sender-1"}`,
+	} {
+		sender.handleMessage([]byte(msg))
+
+		var reply ErrorMessage
+		if err := json.Unmarshal(readWithTimeout(sender.send, 200*time.Millisecond), &reply); err != nil {
+			t.Fatalf("%s: failed to decode reply: %v", msg, err)
+		}
+		if reply.Code != "invalid_block" {
+			t.Fatalf("%s: expected invalid_block, got %+v", msg, reply)
+		}
+	}
+
+	for i := range maxBlocks {
+		if _, err := sender.setBlocked(fmt.Sprintf("user-%d", i), true); err != nil {
+			t.Fatalf("block %d: %v", i, err)
+		}
+	}
+	if _, err := sender.setBlocked("one-too-many", true); err != errBlockLimit {
+		t.Fatalf("expected the block limit, got %v", err)
+	}
+}
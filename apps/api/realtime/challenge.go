@@ -0,0 +1,327 @@
+package realtime
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// challengeWindow is how often the connect rate is measured and the
+	// difficulty moved a step towards where it should be.
+	challengeWindow = 10 * time.Second
+
+	// maxChallengeDifficulty keeps a misconfigured ceiling from asking for
+	// more work than any browser will finish.
+	maxChallengeDifficulty = 32
+)
+
+var (
+	errChallengeMissing  = errors.New("proof of work required")
+	errChallengeInvalid  = errors.New("invalid challenge")
+	errChallengeExpired  = errors.New("challenge expired")
+	errChallengeReplayed = errors.New("challenge already used")
+	errChallengeUnsolved = errors.New("nonce does not solve the challenge")
+)
+
+// ChallengeSettings configures the proof-of-work connection challenge.
+// Difficulty is in leading zero bits of the solution's SHA-256.
+type ChallengeSettings struct {
+	// Difficulty is asked of everyone. While more than SpikeRate clients a
+	// minute connect it rises a bit every ten seconds, up to MaxDifficulty,
+	// and falls back the same way once the spike passes.
+	Difficulty    int
+	MaxDifficulty int
+	SpikeRate     int
+	// TTL is how long a challenge may take to solve and use.
+	TTL time.Duration
+	// Secret signs challenges so they can be checked without being stored.
+	// Servers sharing connections need the same one; empty picks a random
+	// one.
+	Secret []byte
+}
+
+// DefaultChallengeSettings asks for 16 bits, a fraction of a second in a
+// browser, and up to 22 during a spike.
+func DefaultChallengeSettings() ChallengeSettings {
+	return ChallengeSettings{
+		Difficulty:    16,
+		MaxDifficulty: 22,
+		SpikeRate:     120,
+		TTL:           2 * time.Minute,
+	}
+}
+
+// Challenge is a hashcash-style puzzle. The client must find a nonce for
+// which SHA-256("<challenge>:<nonce>") starts with Difficulty zero bits, then
+// connect with ?challenge=<challenge>&nonce=<nonce>.
+type Challenge struct {
+	Challenge  string    `json:"challenge"`
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// Challenger issues and checks proof-of-work challenges, costing scripts
+// opening connections in bulk far more than a person opening one. Challenges
+// are signed rather than stored, and each may be used once.
+type Challenger struct {
+	settings ChallengeSettings
+	secret   []byte
+	now      func() time.Time
+
+	mu          sync.Mutex
+	difficulty  int
+	windowStart time.Time
+	connects    int
+	spent       map[string]time.Time
+
+	metrics *expvar.Map
+}
+
+// NewChallenger issues challenges as settings describe.
+func NewChallenger(settings ChallengeSettings) *Challenger {
+	settings.Difficulty = min(max(settings.Difficulty, 0), maxChallengeDifficulty)
+	settings.MaxDifficulty = min(max(settings.MaxDifficulty, settings.Difficulty), maxChallengeDifficulty)
+
+	secret := settings.Secret
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		rand.Read(secret)
+	}
+
+	c := &Challenger{
+		settings:   settings,
+		secret:     secret,
+		now:        time.Now,
+		difficulty: settings.Difficulty,
+		spent:      make(map[string]time.Time),
+		metrics:    new(expvar.Map).Init(),
+	}
+	c.windowStart = c.now()
+	for _, name := range []string{"issued", "solved", "rejected_missing", "rejected_invalid", "rejected_expired", "rejected_replayed", "rejected_unsolved"} {
+		c.metrics.Add(name, 0)
+	}
+	c.metrics.Set("difficulty", expvar.Func(func() any { return c.Difficulty() }))
+	return c
+}
+
+// Metrics counts issued, solved and rejected challenges along with the
+// current difficulty, for expvar.
+func (c *Challenger) Metrics() expvar.Var {
+	return c.metrics
+}
+
+// Difficulty is what newly issued challenges ask for.
+func (c *Challenger) Difficulty() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.adjustLocked(c.now())
+	return c.difficulty
+}
+
+// Issue returns a fresh challenge at the current difficulty.
+func (c *Challenger) Issue() Challenge {
+	now := c.now()
+
+	c.mu.Lock()
+	c.adjustLocked(now)
+	difficulty := c.difficulty
+	c.mu.Unlock()
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	expiresAt := now.Add(c.settings.TTL)
+	payload := fmt.Sprintf("%d.%d.%s", expiresAt.Unix(), difficulty, hex.EncodeToString(nonce))
+
+	c.metrics.Add("issued", 1)
+	return Challenge{
+		Challenge:  payload + "." + c.sign(payload),
+		Difficulty: difficulty,
+		ExpiresAt:  time.Unix(expiresAt.Unix(), 0),
+	}
+}
+
+// Verify checks that nonce solves challenge, and spends the challenge.
+func (c *Challenger) Verify(challenge, nonce string) error {
+	expiresAt, err := c.check(challenge, nonce)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.spent[challenge]; ok {
+		return errChallengeReplayed
+	}
+	c.spent[challenge] = expiresAt
+	c.adjustLocked(c.now())
+	c.connects++
+	return nil
+}
+
+// check is Verify without spending the challenge, returning when it expires.
+func (c *Challenger) check(challenge, nonce string) (time.Time, error) {
+	if challenge == "" || nonce == "" {
+		return time.Time{}, errChallengeMissing
+	}
+
+	payload, mac, ok := cutLast(challenge, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(c.sign(payload))) {
+		return time.Time{}, errChallengeInvalid
+	}
+	fields := strings.Split(payload, ".")
+	if len(fields) != 3 {
+		return time.Time{}, errChallengeInvalid
+	}
+	expires, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}, errChallengeInvalid
+	}
+	difficulty, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return time.Time{}, errChallengeInvalid
+	}
+
+	expiresAt := time.Unix(expires, 0)
+	if !c.now().Before(expiresAt) {
+		return time.Time{}, errChallengeExpired
+	}
+	if leadingZeroBits(sha256.Sum256([]byte(challenge+":"+nonce))) < difficulty {
+		return time.Time{}, errChallengeUnsolved
+	}
+
+	c.mu.Lock()
+	_, spent := c.spent[challenge]
+	c.mu.Unlock()
+	if spent {
+		return time.Time{}, errChallengeReplayed
+	}
+	return expiresAt, nil
+}
+
+// ServeChallenge hands out a challenge to solve before connecting.
+func (c *Challenger) ServeChallenge(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, c.Issue())
+}
+
+// Handler refuses requests without a solved challenge, answering 428 when
+// there is none and 403 when it doesn't check out. It doesn't spend the
+// challenge, so cheaper refusals such as bans and admission limits can come
+// between it and Spend without costing a client its solution.
+func (c *Challenger) Handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if _, err := c.check(query.Get("challenge"), query.Get("nonce")); err != nil {
+			c.reject(w, r, err)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// Spend spends the request's challenge before passing it to next, refusing
+// it like Handler if the challenge was spent in the meantime.
+func (c *Challenger) Spend(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if err := c.Verify(query.Get("challenge"), query.Get("nonce")); err != nil {
+			c.reject(w, r, err)
+			return
+		}
+
+		c.metrics.Add("solved", 1)
+		next(w, r)
+	}
+}
+
+func (c *Challenger) reject(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusForbidden
+	switch {
+	case errors.Is(err, errChallengeMissing):
+		c.metrics.Add("rejected_missing", 1)
+		status = http.StatusPreconditionRequired
+	case errors.Is(err, errChallengeExpired):
+		c.metrics.Add("rejected_expired", 1)
+	case errors.Is(err, errChallengeReplayed):
+		c.metrics.Add("rejected_replayed", 1)
+	case errors.Is(err, errChallengeUnsolved):
+		c.metrics.Add("rejected_unsolved", 1)
+	default:
+		c.metrics.Add("rejected_invalid", 1)
+	}
+
+	if status == http.StatusForbidden {
+		log.Printf("Refused connection from %s: %v", PeerFromRequest(r).IP(), err)
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// adjustLocked closes every connect-rate window that has ended by now,
+// moving the difficulty one bit up for a window over the spike rate and one
+// bit back down for any other. It also forgets challenges that have expired,
+// since they can no longer be replayed.
+func (c *Challenger) adjustLocked(now time.Time) {
+	elapsed := now.Sub(c.windowStart)
+	if elapsed < challengeWindow {
+		return
+	}
+
+	perMinute := c.connects * int(time.Minute/challengeWindow)
+	if c.settings.SpikeRate > 0 && perMinute > c.settings.SpikeRate {
+		c.difficulty = min(c.difficulty+1, c.settings.MaxDifficulty)
+	} else {
+		c.difficulty = max(c.difficulty-1, c.settings.Difficulty)
+	}
+	// Windows that passed without anyone connecting were all quiet.
+	quiet := int(elapsed/challengeWindow) - 1
+	c.difficulty = max(c.difficulty-quiet, c.settings.Difficulty)
+
+	c.windowStart = now
+	c.connects = 0
+	for challenge, expiresAt := range c.spent {
+		if !now.Before(expiresAt) {
+			delete(c.spent, challenge)
+		}
+	}
+}
+
+func (c *Challenger) sign(payload string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,176 @@
+package realtime
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// solveChallenge finds a nonce the way a client would.
+func solveChallenge(t *testing.T, ch Challenge) string {
+	t.Helper()
+
+	for n := 0; n < 1<<24; n++ {
+		nonce := strconv.Itoa(n)
+		if leadingZeroBits(sha256.Sum256([]byte(ch.Challenge+":"+nonce))) >= ch.Difficulty {
+			return nonce
+		}
+	}
+	t.Fatalf("no nonce solves %+v", ch)
+	return ""
+}
+
+func TestChallenger_AcceptsEachSolutionOnce(t *testing.T) {
+	c := NewChallenger(ChallengeSettings{Difficulty: 8, TTL: time.Minute})
+
+	ch := c.Issue()
+	if ch.Difficulty != 8 {
+		t.Fatalf("difficulty = %d, want 8", ch.Difficulty)
+	}
+	nonce := solveChallenge(t, ch)
+
+	if err := c.Verify(ch.Challenge, nonce); err != nil {
+		t.Fatalf("verify solution: %v", err)
+	}
+	if err := c.Verify(ch.Challenge, nonce); !errors.Is(err, errChallengeReplayed) {
+		t.Fatalf("verify replay = %v, want %v", err, errChallengeReplayed)
+	}
+}
+
+func TestChallenger_RejectsBadSolutions(t *testing.T) {
+	now := time.Now()
+	c := NewChallenger(ChallengeSettings{Difficulty: 8, TTL: time.Minute})
+	c.now = func() time.Time { return now }
+
+	ch := c.Issue()
+	nonce := solveChallenge(t, ch)
+
+	// Lowering the difficulty in the challenge breaks its signature.
+	fields := strings.Split(ch.Challenge, ".")
+	fields[1] = "0"
+	if err := c.Verify(strings.Join(fields, "."), nonce); !errors.Is(err, errChallengeInvalid) {
+		t.Fatalf("verify tampered challenge = %v, want %v", err, errChallengeInvalid)
+	}
+
+	other := NewChallenger(ChallengeSettings{Difficulty: 8, TTL: time.Minute})
+	if err := other.Verify(ch.Challenge, nonce); !errors.Is(err, errChallengeInvalid) {
+		t.Fatalf("verify on another server = %v, want %v", err, errChallengeInvalid)
+	}
+
+	for n := 0; ; n++ {
+		wrong := "x" + strconv.Itoa(n)
+		if leadingZeroBits(sha256.Sum256([]byte(ch.Challenge+":"+wrong))) < 8 {
+			if err := c.Verify(ch.Challenge, wrong); !errors.Is(err, errChallengeUnsolved) {
+				t.Fatalf("verify wrong nonce = %v, want %v", err, errChallengeUnsolved)
+			}
+			break
+		}
+	}
+
+	now = now.Add(time.Minute)
+	if err := c.Verify(ch.Challenge, nonce); !errors.Is(err, errChallengeExpired) {
+		t.Fatalf("verify expired challenge = %v, want %v", err, errChallengeExpired)
+	}
+}
+
+func TestChallenger_RaisesDifficultyDuringSpikes(t *testing.T) {
+	now := time.Now()
+	c := NewChallenger(ChallengeSettings{Difficulty: 1, MaxDifficulty: 3, SpikeRate: 60, TTL: time.Hour})
+	c.now = func() time.Time { return now }
+	c.windowStart = now
+
+	// Eleven connects in ten seconds is 66 a minute.
+	spike := func() {
+		for range 11 {
+			ch := c.Issue()
+			if err := c.Verify(ch.Challenge, solveChallenge(t, ch)); err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+		}
+		now = now.Add(challengeWindow)
+	}
+
+	for _, want := range []int{2, 3, 3} {
+		spike()
+		if got := c.Difficulty(); got != want {
+			t.Fatalf("difficulty after a spike = %d, want %d", got, want)
+		}
+	}
+
+	now = now.Add(challengeWindow)
+	if got := c.Difficulty(); got != 2 {
+		t.Fatalf("difficulty after a quiet window = %d, want 2", got)
+	}
+	now = now.Add(5 * challengeWindow)
+	if got := c.Difficulty(); got != 1 {
+		t.Fatalf("difficulty after a quiet minute = %d, want the base 1", got)
+	}
+}
+
+func TestChallenger_HandlerRequiresSolution(t *testing.T) {
+	c := NewChallenger(ChallengeSettings{Difficulty: 8, TTL: time.Minute})
+
+	var reached int
+	handler := c.Handler(c.Spend(func(w http.ResponseWriter, r *http.Request) { reached++ }))
+	connect := func(query url.Values) int {
+		req := httptest.NewRequest(http.MethodGet, "/connect?"+query.Encode(), nil)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	if code := connect(nil); code != http.StatusPreconditionRequired {
+		t.Fatalf("status without a solution = %d, want %d", code, http.StatusPreconditionRequired)
+	}
+
+	rec := httptest.NewRecorder()
+	c.ServeChallenge(rec, httptest.NewRequest(http.MethodGet, "/challenge", nil))
+	var ch Challenge
+	if err := json.Unmarshal(rec.Body.Bytes(), &ch); err != nil {
+		t.Fatalf("failed to decode challenge: %v", err)
+	}
+
+	query := url.Values{"challenge": {ch.Challenge}, "nonce": {solveChallenge(t, ch)}}
+	if code := connect(query); code != http.StatusOK || reached != 1 {
+		t.Fatalf("status with a solution = %d, reached %d times", code, reached)
+	}
+	if code := connect(query); code != http.StatusForbidden || reached != 1 {
+		t.Fatalf("status on replay = %d, want %d", code, http.StatusForbidden)
+	}
+}
+
+func TestChallenger_RefusalsBeforeSpendKeepTheChallenge(t *testing.T) {
+	c := NewChallenger(ChallengeSettings{Difficulty: 8, TTL: time.Minute})
+
+	// Stands in for admission control turning the first attempt away.
+	full := true
+	var reached int
+	handler := c.Handler(func(w http.ResponseWriter, r *http.Request) {
+		if full {
+			full = false
+			http.Error(w, "full", http.StatusTooManyRequests)
+			return
+		}
+		c.Spend(func(w http.ResponseWriter, r *http.Request) { reached++ })(w, r)
+	})
+
+	ch := c.Issue()
+	target := "/connect?" + url.Values{"challenge": {ch.Challenge}, "nonce": {solveChallenge(t, ch)}}.Encode()
+	for _, want := range []int{http.StatusTooManyRequests, http.StatusOK, http.StatusForbidden} {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != want {
+			t.Fatalf("status = %d, want %d", rec.Code, want)
+		}
+	}
+	if reached != 1 {
+		t.Fatalf("reached %d times, want once after the retry", reached)
+	}
+}
@@ -2,11 +2,15 @@ package realtime
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
 )
 
 const (
@@ -14,23 +18,132 @@ const (
 	pongWait       = 90 * time.Second
 	pingPeriod     = 30 * time.Second
 	maxMessageSize = 8192
+
+	// maxCompositionRunes bounds the composition kept per client for
+	// snapshots. Bubbles only show the tail of long compositions anyway.
+	maxCompositionRunes = 1024
 )
 
 type Client struct {
 	userID string
 	hub    *Hub
-	conn   *websocket.Conn
+	conn   Conn
 	send   chan []byte
+
+	// peer and connectedAt describe the connection for operators and
+	// moderation.
+	peer        Peer
+	connectedAt time.Time
+
+	// messagesIn and messagesOut count messages read from and queued for the
+	// client. messagesCoalesced counts queued messages replaced by a newer one
+	// before they were written, which messagesOut leaves out.
+	messagesIn        atomic.Uint64
+	messagesOut       atomic.Uint64
+	messagesCoalesced atomic.Uint64
+
+	// wake, when set, is called after the hub queues a message or closes send.
+	// Transports without a dedicated write goroutine use it to schedule a flush.
+	wake func()
+
+	// shard is the hub shard that delivers broadcasts to this client.
+	shard *shard
+
+	// registered is closed by the hub once the client has its position and
+	// identity. Transports start reading before that is guaranteed.
+	registered chan struct{}
+
+	// sendMu guards closed so the hub loop and the shards can deliver to the
+	// client without racing the close of its send buffer.
+	sendMu sync.Mutex
+	closed bool
+
+	// stateMu guards canvas state read by the shards while the hub loop or
+	// the client's own read loop updates it.
+	stateMu  sync.RWMutex
+	position Point
+	identity Identity
+
+	// profile is what the client chose to show and shownProfile what others
+	// see, which lags behind while the client is shadow-banned.
+	profile           Profile
+	shownProfile      Profile
+	lastProfileChange time.Time
+
+	// lastActive and hidden feed status, which the hub loop recomputes and
+	// publishes in presence.
+	lastActive time.Time
+	hidden     bool
+	status     string
+
+	// composition mirrors what the client is typing, so clients returning
+	// from a hidden tab can be resynced.
+	composition []rune
+
+	// coalesced holds the newest undelivered message per key, in the order the
+	// keys were first queued. It sits beside send rather than in it, so a flood
+	// of superseded updates like cursor moves never crowds out typing.
+	// coalescedReady is signalled when a message is queued. coalesced and
+	// coalescedKeys are guarded by sendMu.
+	coalesced      map[string][]byte
+	coalescedKeys  []string
+	coalescedReady chan struct{}
+
+	// interestSlot is one more than the client's slot in the hub's interest
+	// grid, or zero while it has no viewport there.
+	interestSlot atomic.Int32
+
+	// blocked holds the user IDs this client doesn't want to see, replaced
+	// wholesale under blockMu so deliveries can read it without locking.
+	blockMu sync.Mutex
+	blocked atomic.Pointer[map[string]struct{}]
+
+	// abuse is the abuse detector's score for this client.
+	abuse abuseState
+
+	// cursorMu guards the inbound cursor throttle.
+	cursorMu      sync.Mutex
+	lastCursor    time.Time
+	pendingCursor *CursorMessage
+	cursorTimer   *time.Timer
 }
 
-func NewClient(hub *Hub, conn *websocket.Conn) *Client {
-	userID := uuid.New().String()
+// controlHandlers handle client messages that change server-side state
+// instead of being relayed.
+var controlHandlers = map[string]func(*Client, []byte){
+	"viewport":    (*Client).handleViewport,
+	"cursor":      (*Client).handleCursor,
+	"follow":      (*Client).handleFollow,
+	"unfollow":    (*Client).handleUnfollow,
+	"move":        (*Client).handleMove,
+	"hello":       (*Client).handleHello,
+	"set_profile": (*Client).handleSetProfile,
+	"visibility":  (*Client).handleVisibility,
+	"report":      (*Client).handleReport,
+	"block":       (*Client).handleBlock,
+	"unblock":     (*Client).handleUnblock,
+}
 
+// NewClient binds a new client, connecting from peer, to conn.
+func NewClient(hub *Hub, conn Conn, peer Peer) *Client {
+	client := newClient(hub)
+	client.conn = conn
+	client.peer = peer
+	return client
+}
+
+// newClient builds a client that is not yet bound to a transport. The hub only
+// ever talks to a client through its send buffer, so every transport shares the
+// same presence and relay behaviour.
+func newClient(hub *Hub) *Client {
 	return &Client{
-		userID: userID,
-		hub:    hub,
-		conn:   conn,
-		send:   make(chan []byte, 32),
+		userID:      uuid.New().String(),
+		hub:         hub,
+		send:        make(chan []byte, 32),
+		connectedAt: time.Now(),
+
+		registered:     make(chan struct{}),
+		coalescedReady: make(chan struct{}, 1),
 	}
 }
 
@@ -41,17 +154,16 @@ func (c *Client) ReadPump() {
 	}()
 
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.conn.SetPongHandler(func(string) error {
+	c.conn.SetPongHandler(func() {
 		c.conn.SetReadDeadline(time.Now().Add(pongWait))
-		return nil
 	})
 	c.conn.SetReadLimit(maxMessageSize)
 
 	for {
-		_, message, err := c.conn.ReadMessage()
+		message, err := c.conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error for client %s: %v", c.userID, err)
+			if !errors.Is(err, io.EOF) {
+				log.Printf("Connection error for client %s: %v", c.userID, err)
 			}
 			break
 		}
@@ -72,30 +184,125 @@ func (c *Client) WritePump() {
 		case message, ok := <-c.send:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.conn.WriteClose()
 				return
 			}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
+			if err := c.conn.WriteMessage(message); err != nil {
 				return
 			}
-			w.Write(message)
 
-			if err := w.Close(); err != nil {
-				return
+		case <-c.coalescedReady:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			for _, message := range c.takeCoalesced() {
+				if err := c.conn.WriteMessage(message); err != nil {
+					return
+				}
 			}
 
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := c.conn.WritePing(); err != nil {
 				return
 			}
 		}
 	}
 }
 
+// trySend delivers data to the client's send buffer, dropping the message if the
+// buffer is full so a slow client can't block the hub.
+func (c *Client) trySend(data []byte) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	select {
+	case c.send <- data:
+		c.messagesOut.Add(1)
+		if c.wake != nil {
+			c.wake()
+		}
+		return true
+	default:
+		log.Printf("Client %s send buffer full, skipping message", c.userID)
+		return false
+	}
+}
+
+// trySendCoalesced queues data under key, replacing any undelivered message
+// with the same key. Only the latest value matters for these messages, so the
+// queue holds at most one per key no matter how fast they arrive.
+func (c *Client) trySendCoalesced(key string, data []byte) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	if c.coalesced == nil {
+		c.coalesced = make(map[string][]byte)
+	}
+	if _, queued := c.coalesced[key]; queued {
+		c.messagesCoalesced.Add(1)
+	} else {
+		c.coalescedKeys = append(c.coalescedKeys, key)
+		c.messagesOut.Add(1)
+	}
+	c.coalesced[key] = data
+
+	select {
+	case c.coalescedReady <- struct{}{}:
+	default:
+	}
+	if c.wake != nil {
+		c.wake()
+	}
+	return true
+}
+
+// takeCoalesced removes and returns every queued coalesced message.
+func (c *Client) takeCoalesced() [][]byte {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if len(c.coalescedKeys) == 0 {
+		return nil
+	}
+
+	messages := make([][]byte, 0, len(c.coalescedKeys))
+	for _, key := range c.coalescedKeys {
+		messages = append(messages, c.coalesced[key])
+		delete(c.coalesced, key)
+	}
+	c.coalescedKeys = c.coalescedKeys[:0]
+
+	return messages
+}
+
+// closeSend closes the send buffer, which tells the write side to shut the
+// connection down. Later deliveries are dropped.
+func (c *Client) closeSend() {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	c.closed = true
+	close(c.send)
+	if c.wake != nil {
+		c.wake()
+	}
+}
+
 func (c *Client) handleMessage(data []byte) {
+	c.messagesIn.Add(1)
+
 	var envelope map[string]json.RawMessage
 	if err := json.Unmarshal(data, &envelope); err != nil {
 		log.Printf("Error unmarshaling message from %s: %v", c.userID, err)
@@ -103,14 +310,75 @@ func (c *Client) handleMessage(data []byte) {
 	}
 
 	msgType := envelopeType(envelope)
+	if msgType != "visibility" {
+		c.touch()
+	}
+
+	if handle, ok := controlHandlers[msgType]; ok {
+		handle(c, data)
+		return
+	}
+
 	if !relayableTypes[msgType] {
 		log.Printf("Unknown or non-relayable message type from %s: %q", c.userID, msgType)
 		return
 	}
 
+	if _, ok := c.sanction(SanctionShadowBan); ok {
+		c.echo(envelope)
+		return
+	}
+	if _, ok := c.sanction(SanctionMute); ok {
+		c.sendError("muted", errMuted)
+		return
+	}
+
+	if c.hub.abuse != nil && c.hub.abuse.observe(c, msgType, envelopeChar(envelope), c.Composition()) {
+		return
+	}
+
 	c.relay(envelope)
 }
 
+// echo sends a shadow-banned client's typing back to it alone, so it looks
+// relayed from where the client sits. Its composition isn't tracked, which
+// keeps it out of everyone else's typing snapshots too.
+func (c *Client) echo(envelope map[string]json.RawMessage) {
+	userID, err := json.Marshal(c.userID)
+	if err != nil {
+		log.Printf("Error marshaling userId for %s: %v", c.userID, err)
+		return
+	}
+	envelope["userId"] = userID
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		log.Printf("Error marshaling echo for %s: %v", c.userID, err)
+		return
+	}
+	c.trySend(data)
+}
+
+// silenced reports whether the client's typing and cursor are kept from
+// everyone else.
+func (c *Client) silenced() bool {
+	if _, ok := c.sanction(SanctionShadowBan); ok {
+		return true
+	}
+	_, ok := c.sanction(SanctionMute)
+	return ok
+}
+
+// sanction reports the active sanction of kind on this user, its address or
+// its identity key.
+func (c *Client) sanction(kind SanctionKind) (Sanction, bool) {
+	return c.hub.moderation.Check(kind, c.subjects()...)
+}
+
+func (c *Client) subjects() []string {
+	return append(c.peer.subjects(), subjectUser+c.userID)
+}
+
 func (c *Client) relay(envelope map[string]json.RawMessage) {
 	userID, err := json.Marshal(c.userID)
 	if err != nil {
@@ -119,7 +387,450 @@ func (c *Client) relay(envelope map[string]json.RawMessage) {
 	}
 
 	envelope["userId"] = userID
-	c.hub.BroadcastMessageExcept(c, envelope)
+
+	c.hub.typingMu.RLock()
+	defer c.hub.typingMu.RUnlock()
+
+	if added := c.compose(envelopeType(envelope), envelope["char"]); added > 0 {
+		if msg, ok := c.filterComposition(added); ok {
+			c.hub.relay(c, msg)
+			return
+		}
+	}
+	c.hub.relay(c, envelope)
+}
+
+// filterComposition runs the hub's word filter over the runes just added to
+// the composition. On a match it masks or clears the composition and returns
+// the message that brings everyone else's copy in line, instead of relaying
+// the keystroke that completed the word.
+func (c *Client) filterComposition(added int) (any, bool) {
+	f := c.hub.wordFilter
+	if f == nil {
+		return nil, false
+	}
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	start, end, ok := f.match(c.composition, added)
+	if !ok {
+		return nil, false
+	}
+
+	if f.action == FilterClear {
+		c.composition = c.composition[:0]
+		return RelayMessage{Type: "typing_clear", UserID: c.userID}, true
+	}
+
+	for i := start; i < end; i++ {
+		c.composition[i] = maskRune
+	}
+	return TypingReplaceMessage{Type: "typing_replace", UserID: c.userID, Text: string(c.composition)}, true
+}
+
+// compose applies a typing message to the client's composition the way the
+// web client does: characters append, back deletes the last one and clear
+// empties it. Only the newest maxCompositionRunes are kept. It returns how
+// many runes were appended.
+func (c *Client) compose(msgType string, rawChar json.RawMessage) int {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	switch msgType {
+	case "typing_update":
+		var char string
+		if rawChar == nil || json.Unmarshal(rawChar, &char) != nil {
+			return 0
+		}
+		runes := []rune(char)
+		c.composition = append(c.composition, runes...)
+		if over := len(c.composition) - maxCompositionRunes; over > 0 {
+			c.composition = append(c.composition[:0], c.composition[over:]...)
+		}
+		return min(len(runes), len(c.composition))
+
+	case "typing_back":
+		if n := len(c.composition); n > 0 {
+			c.composition = c.composition[:n-1]
+		}
+
+	case "typing_clear":
+		c.composition = c.composition[:0]
+	}
+	return 0
+}
+
+// Composition returns what the client is currently typing.
+func (c *Client) Composition() string {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return string(c.composition)
+}
+
+func (c *Client) handleViewport(data []byte) {
+	var msg ViewportMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		log.Printf("Error unmarshaling viewport from %s: %v", c.userID, err)
+		return
+	}
+
+	viewport := Rect{X: msg.X, Y: msg.Y, Width: msg.Width, Height: msg.Height}
+	if !validViewport(viewport) {
+		log.Printf("Invalid viewport from %s: %+v", c.userID, viewport)
+		return
+	}
+
+	// The hub indexes a client when it registers, so a viewport sent straight
+	// after connecting waits for that rather than being indexed first.
+	if c.registered != nil {
+		<-c.registered
+	}
+	c.hub.interest.update(c, viewport)
+	if c.hub.regions != nil {
+		c.hub.regions.subscribe(c, viewport)
+	}
+
+	if msg.Publish {
+		c.hub.publishViewport(c, viewport)
+	} else {
+		c.hub.follows.stopPublishing(c.userID)
+	}
+}
+
+func (c *Client) handleMove(data []byte) {
+	if c.hub.proximity == 0 {
+		log.Printf("Ignoring move from %s: proximity mode is off", c.userID)
+		return
+	}
+
+	var msg MoveMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		log.Printf("Error unmarshaling move from %s: %v", c.userID, err)
+		return
+	}
+
+	to := Point{X: msg.X, Y: msg.Y}
+	if !validCanvasPoint(to) {
+		log.Printf("Invalid move from %s: %+v", c.userID, to)
+		return
+	}
+
+	c.hub.moves <- moveRequest{client: c, to: to}
+}
+
+// handleHello tells the client who it is, once the hub has assigned it an
+// identity.
+func (c *Client) handleHello([]byte) {
+	if c.registered != nil {
+		<-c.registered
+	}
+	identity := c.Identity()
+
+	data, err := json.Marshal(HelloAckMessage{
+		Type:  "hello_ack",
+		ID:    c.userID,
+		Name:  identity.Name,
+		Color: identity.Color,
+	})
+	if err != nil {
+		log.Printf("Error marshaling hello_ack for %s: %v", c.userID, err)
+		return
+	}
+
+	c.trySend(data)
+}
+
+func (c *Client) handleSetProfile(data []byte) {
+	var msg SetProfileMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		log.Printf("Error unmarshaling set_profile from %s: %v", c.userID, err)
+		return
+	}
+
+	profile, err := validateProfile(msg)
+	if err != nil {
+		c.sendError("invalid_profile", err)
+		return
+	}
+
+	// A name reaches the whole room, so it is gated like typing: muted
+	// clients are told no, and shadow-banned ones only see it themselves.
+	_, shadowBanned := c.sanction(SanctionShadowBan)
+	if _, muted := c.sanction(SanctionMute); muted && !shadowBanned {
+		c.sendError("muted", errMuted)
+		return
+	}
+
+	c.stateMu.Lock()
+	if since := time.Since(c.lastProfileChange); since < profileChangeInterval {
+		c.stateMu.Unlock()
+		c.sendError("rate_limited", errProfileRateLimit)
+		return
+	}
+	c.profile = profile
+	if !shadowBanned {
+		c.shownProfile = profile
+	}
+	c.lastProfileChange = time.Now()
+	c.stateMu.Unlock()
+
+	c.hub.presenceUpdates <- c
+}
+
+func (c *Client) handleVisibility(data []byte) {
+	var msg VisibilityMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		log.Printf("Error unmarshaling visibility from %s: %v", c.userID, err)
+		return
+	}
+
+	var hidden bool
+	switch msg.State {
+	case "visible":
+	case "hidden":
+		hidden = true
+	default:
+		log.Printf("Invalid visibility from %s: %q", c.userID, msg.State)
+		return
+	}
+
+	c.stateMu.Lock()
+	changed := c.hidden != hidden
+	c.hidden = hidden
+	if !hidden {
+		c.lastActive = time.Now()
+	}
+	c.stateMu.Unlock()
+
+	if changed {
+		c.hub.presenceUpdates <- c
+		if !hidden {
+			c.hub.snapshots <- c
+		}
+	}
+}
+
+func (c *Client) isHidden() bool {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.hidden
+}
+
+// touch records activity. A client coming back from idle or away asks the hub
+// to republish its status rather than waiting for the next status check.
+func (c *Client) touch() {
+	c.stateMu.Lock()
+	c.lastActive = time.Now()
+	stale := c.status != "" && c.status != StatusActive && !c.hidden
+	c.stateMu.Unlock()
+
+	if stale {
+		c.hub.presenceUpdates <- c
+	}
+}
+
+// refreshStatus recomputes the client's status and reports whether it changed.
+func (c *Client) refreshStatus(now time.Time, idleAfter, awayAfter time.Duration) bool {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	if c.lastActive.IsZero() {
+		c.lastActive = now
+	}
+
+	status := statusAt(now, c.lastActive, c.hidden, idleAfter, awayAfter)
+	if status == c.status {
+		return false
+	}
+
+	c.status = status
+	return true
+}
+
+// sendError reports a rejected message back to the client.
+func (c *Client) sendError(code string, err error) {
+	data, merr := json.Marshal(ErrorMessage{Type: "error", Code: code, Message: err.Error()})
+	if merr != nil {
+		log.Printf("Error marshaling error for %s: %v", c.userID, merr)
+		return
+	}
+
+	c.trySend(data)
+}
+
+// handleReport files a report against another user. Reports go unanswered
+// unless they are rejected.
+func (c *Client) handleReport(data []byte) {
+	var msg ReportMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		log.Printf("Error unmarshaling report from %s: %v", c.userID, err)
+		return
+	}
+
+	reason := strings.TrimSpace(msg.Reason)
+	if msg.UserID == "" || msg.UserID == c.userID || !validReportReason(reason) {
+		c.sendError("invalid_report", errReport)
+		return
+	}
+
+	switch err := c.hub.report(c, msg.UserID, reason); {
+	case errors.Is(err, errReportRateLimit):
+		c.sendError("rate_limited", err)
+	case err != nil:
+		c.sendError("invalid_report", err)
+	}
+}
+
+func (c *Client) handleFollow(data []byte) {
+	var msg FollowMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		log.Printf("Error unmarshaling follow from %s: %v", c.userID, err)
+		return
+	}
+
+	if msg.UserID == "" || msg.UserID == c.userID {
+		log.Printf("Invalid follow target from %s: %q", c.userID, msg.UserID)
+		return
+	}
+
+	if latest := c.hub.follows.follow(c, msg.UserID); latest != nil {
+		c.trySendCoalesced(viewportKey(msg.UserID), latest)
+	}
+}
+
+func (c *Client) handleUnfollow([]byte) {
+	c.hub.follows.unfollow(c)
+}
+
+// handleCursor relays the client's pointer at most once per the hub's cursor
+// interval. Moves that arrive sooner are held back and only the latest is sent
+// when the interval is up.
+func (c *Client) handleCursor(data []byte) {
+	var msg CursorMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		log.Printf("Error unmarshaling cursor from %s: %v", c.userID, err)
+		return
+	}
+
+	if c.silenced() {
+		return
+	}
+
+	if !validCanvasPoint(Point{X: msg.X, Y: msg.Y}) {
+		log.Printf("Invalid cursor from %s: (%v, %v)", c.userID, msg.X, msg.Y)
+		return
+	}
+	msg.UserID = c.userID
+
+	c.cursorMu.Lock()
+	defer c.cursorMu.Unlock()
+
+	wait := c.hub.cursorInterval - time.Since(c.lastCursor)
+	if wait <= 0 {
+		c.lastCursor = time.Now()
+		c.hub.relayCursor(c, msg)
+		return
+	}
+
+	c.pendingCursor = &msg
+	if c.cursorTimer == nil {
+		c.cursorTimer = time.AfterFunc(wait, c.flushCursor)
+	}
+}
+
+func (c *Client) flushCursor() {
+	c.cursorMu.Lock()
+	defer c.cursorMu.Unlock()
+
+	msg := c.pendingCursor
+	c.pendingCursor = nil
+	c.cursorTimer = nil
+
+	if msg == nil || c.isClosed() {
+		return
+	}
+
+	c.lastCursor = time.Now()
+	c.hub.relayCursor(c, *msg)
+}
+
+// Position returns the client's server-assigned canvas position.
+func (c *Client) Position() Point {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.position
+}
+
+// Identity returns the client's server-assigned name and color.
+func (c *Client) Identity() Identity {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.identity
+}
+
+func (c *Client) setIdentity(id Identity) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.identity = id
+}
+
+// presence describes the client in other clients' presence updates.
+func (c *Client) presence() PresenceUser {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.presenceLocked(c.shownProfile)
+}
+
+// selfPresence describes the client in its own presence updates, with the
+// profile it chose even if others don't see it.
+func (c *Client) selfPresence() PresenceUser {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.presenceLocked(c.profile)
+}
+
+func (c *Client) presenceLocked(profile Profile) PresenceUser {
+	position := c.position
+	user := PresenceUser{
+		ID:       c.userID,
+		Name:     c.identity.Name,
+		Color:    c.identity.Color,
+		Position: &position,
+		Status:   c.status,
+	}
+	if !profile.isZero() {
+		user.Profile = &profile
+	}
+	return user
+}
+
+// Profile returns what the client chose to show about itself.
+func (c *Client) Profile() Profile {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.profile
+}
+
+func (c *Client) setPosition(p Point) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.position = p
+}
+
+func (c *Client) isClosed() bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	return c.closed
+}
+
+// envelopeChar returns the char field of a typing message, if any.
+func envelopeChar(envelope map[string]json.RawMessage) string {
+	var char string
+	if raw, ok := envelope["char"]; ok {
+		_ = json.Unmarshal(raw, &char)
+	}
+	return char
 }
 
 func envelopeType(envelope map[string]json.RawMessage) string {
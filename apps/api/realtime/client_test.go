@@ -3,16 +3,13 @@ package realtime
 import (
 	"encoding/json"
 	"errors"
-	"net/http"
-	"net/http/httptest"
-	"strings"
+	"fmt"
+	"io"
 	"testing"
 	"time"
-
-	"github.com/gorilla/websocket"
 )
 
-var errWebsocketTimeout = errors.New("timeout waiting for websocket message")
+var errReadTimeout = errors.New("timeout waiting for message")
 
 // readWithTimeout returns the next message from ch or nil if the timeout elapses.
 func readWithTimeout(ch <-chan []byte, d time.Duration) []byte {
@@ -24,70 +21,25 @@ func readWithTimeout(ch <-chan []byte, d time.Duration) []byte {
 	}
 }
 
-type wsPair struct {
-	client *websocket.Conn
-	server *websocket.Conn
-	close  func()
-}
-
-func newWebsocketPair(t *testing.T) wsPair {
-	t.Helper()
-
-	serverConnCh := make(chan *websocket.Conn, 1)
-
-	upgrader := websocket.Upgrader{
-		CheckOrigin: func(*http.Request) bool { return true },
-	}
-
-	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		conn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			t.Fatalf("upgrade websocket: %v", err)
-		}
-		serverConnCh <- conn
-	}))
-
-	dialURL := "ws" + strings.TrimPrefix(srv.URL, "http")
-
-	clientConn, _, err := websocket.DefaultDialer.Dial(dialURL, nil)
-	if err != nil {
-		srv.Close()
-		t.Fatalf("dial websocket: %v", err)
-	}
-
-	serverConn := <-serverConnCh
-
-	return wsPair{
-		client: clientConn,
-		server: serverConn,
-		close: func() {
-			clientConn.Close()
-			serverConn.Close()
-			srv.Close()
-		},
-	}
-}
-
-func readWSMessage(t *testing.T, conn *websocket.Conn, timeout time.Duration) (int, []byte, error) {
+func readConnMessage(t *testing.T, conn Conn, timeout time.Duration) ([]byte, error) {
 	t.Helper()
 
 	type result struct {
-		messageType int
-		payload     []byte
-		err         error
+		payload []byte
+		err     error
 	}
 
 	ch := make(chan result, 1)
 	go func() {
-		mt, payload, err := conn.ReadMessage()
-		ch <- result{messageType: mt, payload: payload, err: err}
+		payload, err := conn.ReadMessage()
+		ch <- result{payload: payload, err: err}
 	}()
 
 	select {
 	case res := <-ch:
-		return res.messageType, res.payload, res.err
+		return res.payload, res.err
 	case <-time.After(timeout):
-		return -1, nil, errWebsocketTimeout
+		return nil, errReadTimeout
 	}
 }
 
@@ -257,8 +209,8 @@ func TestClient_handleMessage_invalidJSONDoesNotPanicOrBroadcast(t *testing.T) {
 }
 
 func TestClient_ReadPumpDispatchesAndUnregistersOnClose(t *testing.T) {
-	pair := newWebsocketPair(t)
-	defer pair.close()
+	local, remote := NewPipe()
+	defer remote.Close()
 
 	h := NewHub()
 	go h.Run()
@@ -268,7 +220,7 @@ func TestClient_ReadPumpDispatchesAndUnregistersOnClose(t *testing.T) {
 	// Drain any presence broadcasts targeting receiver.
 	readWithTimeout(receiver.send, 50*time.Millisecond)
 
-	client := NewClient(h, pair.server)
+	client := NewClient(h, local, Peer{RemoteAddr: "pipe"})
 	h.Register(client)
 
 	// Drain presence message that receiver observes after registering client
@@ -286,8 +238,12 @@ func TestClient_ReadPumpDispatchesAndUnregistersOnClose(t *testing.T) {
 		"type": "typing_update",
 		"char": "z",
 	}
-	if err := pair.client.WriteJSON(payload); err != nil {
-		t.Fatalf("write json: %v", err)
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if err := remote.WriteMessage(data); err != nil {
+		t.Fatalf("write message: %v", err)
 	}
 
 	raw := readWithTimeout(receiver.send, 200*time.Millisecond)
@@ -307,13 +263,13 @@ func TestClient_ReadPumpDispatchesAndUnregistersOnClose(t *testing.T) {
 		t.Fatalf("expected broadcast from %q, got %q", client.userID, msg.UserID)
 	}
 
-	// Closing the client-side websocket should cause ReadPump to exit and trigger unregister.
-	pair.client.Close()
+	// Closing the remote end should cause ReadPump to exit and trigger unregister.
+	remote.Close()
 
 	select {
 	case <-done:
 	case <-time.After(500 * time.Millisecond):
-		t.Fatalf("ReadPump did not exit after websocket close")
+		t.Fatalf("ReadPump did not exit after connection close")
 	}
 
 	select {
@@ -327,13 +283,13 @@ func TestClient_ReadPumpDispatchesAndUnregistersOnClose(t *testing.T) {
 }
 
 func TestClient_WritePumpFlushesAndClosesConnection(t *testing.T) {
-	pair := newWebsocketPair(t)
-	defer pair.close()
+	local, remote := NewPipe()
+	defer remote.Close()
 
 	client := &Client{
 		userID: "writer",
 		hub:    nil,
-		conn:   pair.server,
+		conn:   local,
 		send:   make(chan []byte, 2),
 	}
 
@@ -346,12 +302,9 @@ func TestClient_WritePumpFlushesAndClosesConnection(t *testing.T) {
 	payload := []byte(`{"type":"buffered"}`)
 	client.send <- payload
 
-	msgType, raw, err := readWSMessage(t, pair.client, 200*time.Millisecond)
+	raw, err := readConnMessage(t, remote, 200*time.Millisecond)
 	if err != nil {
-		t.Fatalf("read websocket message: %v", err)
-	}
-	if msgType != websocket.TextMessage {
-		t.Fatalf("expected text message, got %d", msgType)
+		t.Fatalf("read message: %v", err)
 	}
 	if string(raw) != string(payload) {
 		t.Fatalf("unexpected payload: %s", string(raw))
@@ -365,9 +318,192 @@ func TestClient_WritePumpFlushesAndClosesConnection(t *testing.T) {
 		t.Fatalf("WritePump did not exit after send channel closed")
 	}
 
-	if _, _, err := readWSMessage(t, pair.client, 200*time.Millisecond); err == nil {
-		t.Fatalf("expected websocket close, got none")
-	} else if errors.Is(err, errWebsocketTimeout) {
-		t.Fatalf("expected websocket close, timed out instead")
+	if _, err := readConnMessage(t, remote, 200*time.Millisecond); err == nil {
+		t.Fatalf("expected connection close, got none")
+	} else if !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF on close, got %v", err)
+	}
+}
+
+func TestClient_handleMessage_typingOnlyReachesViewportsCoveringAuthor(t *testing.T) {
+	h, sender, receiver := setupHubWithClients(t)
+
+	onlooker := newTestClient(h, "onlooker", 10)
+	h.Register(onlooker)
+	drainChannel(sender.send)
+	drainChannel(receiver.send)
+	drainChannel(onlooker.send)
+
+	sender.setPosition(Point{X: 100, Y: 100})
+
+	// receiver looks far away from the sender; onlooker covers the sender.
+	receiver.handleMessage([]byte(`{"type":"viewport","x":4000,"y":4000,"width":800,"height":600}`))
+	onlooker.handleMessage([]byte(`{"type":"viewport","x":0,"y":0,"width":800,"height":600}`))
+
+	sender.handleMessage([]byte(`{"type":"typing_update","char":"v"}`))
+
+	if raw := readWithTimeout(onlooker.send, 200*time.Millisecond); raw == nil {
+		t.Fatalf("expected onlooker to receive typing update, got none")
+	}
+	if raw := readWithTimeout(receiver.send, 100*time.Millisecond); raw != nil {
+		t.Fatalf("expected receiver looking elsewhere not to receive typing update, got %s", string(raw))
+	}
+
+	// Panning onto the sender brings typing back.
+	receiver.handleMessage([]byte(`{"type":"viewport","x":0,"y":0,"width":800,"height":600}`))
+	sender.handleMessage([]byte(`{"type":"typing_update","char":"w"}`))
+
+	if raw := readWithTimeout(receiver.send, 200*time.Millisecond); raw == nil {
+		t.Fatalf("expected receiver to receive typing update after panning, got none")
+	}
+}
+
+func TestHub_PresenceIncludesServerAssignedPositions(t *testing.T) {
+	_, sender, receiver := setupHubWithClients(t)
+
+	raw := readWithTimeout(sender.send, 200*time.Millisecond)
+	for raw != nil {
+		var presence PresenceMessage
+		if err := json.Unmarshal(raw, &presence); err != nil {
+			t.Fatalf("unmarshal presence: %v", err)
+		}
+
+		if len(presence.Users) == 1 {
+			user := presence.Users[0]
+			if user.ID != receiver.userID || user.Position == nil {
+				t.Fatalf("expected receiver with position, got %+v", user)
+			}
+			if *user.Position != receiver.Position() {
+				t.Fatalf("expected position %+v, got %+v", receiver.Position(), *user.Position)
+			}
+			return
+		}
+
+		raw = readWithTimeout(sender.send, 200*time.Millisecond)
+	}
+
+	t.Fatalf("expected presence listing the receiver")
+}
+
+func TestClient_handleMessage_cursorThrottledToLatest(t *testing.T) {
+	const interval = 100 * time.Millisecond
+
+	h := NewHub(WithCursorInterval(interval))
+	go h.Run()
+
+	sender := newClient(h)
+	receiver := newClient(h)
+	h.Register(sender)
+	h.Register(receiver)
+	drainChannel(receiver.send)
+
+	for i := range 5 {
+		sender.handleMessage([]byte(fmt.Sprintf(`{"type":"cursor","x":%d,"y":10}`, i)))
+	}
+
+	var xs []float64
+	deadline := time.After(3 * interval)
+	for len(xs) < 2 {
+		select {
+		case <-receiver.coalescedReady:
+			for _, raw := range receiver.takeCoalesced() {
+				var msg CursorMessage
+				if err := json.Unmarshal(raw, &msg); err != nil {
+					t.Fatalf("unmarshal cursor: %v", err)
+				}
+				if msg.Type != "cursor" || msg.UserID != sender.userID {
+					t.Fatalf("unexpected cursor: %+v", msg)
+				}
+				xs = append(xs, msg.X)
+			}
+		case <-deadline:
+			t.Fatalf("expected two cursor updates, got %v", xs)
+		}
+	}
+
+	if xs[0] != 0 || xs[1] != 4 {
+		t.Fatalf("expected the first and the latest cursor, got %v", xs)
+	}
+	if raw := readWithTimeout(receiver.send, 2*interval); raw != nil {
+		t.Fatalf("expected cursors to bypass the send buffer, got %s", raw)
+	}
+}
+
+func TestClient_coalescedCursorsDoNotCrowdOutTyping(t *testing.T) {
+	local, remote := NewPipe()
+	defer remote.Close()
+
+	client := newClient(nil)
+	client.conn = local
+
+	// Queue the flood before the write pump starts so it can't deliver an
+	// intermediate cursor.
+	for i := range 100 {
+		client.trySendCoalesced("cursor:author", []byte(fmt.Sprintf(`{"type":"cursor","x":%d}`, i)))
+	}
+	if !client.trySend([]byte(`{"type":"typing_update","char":"a"}`)) {
+		t.Fatalf("expected typing update to be queued behind the cursor flood")
+	}
+	go client.WritePump()
+
+	var got []string
+	for len(got) < 2 {
+		raw, err := readConnMessage(t, remote, 200*time.Millisecond)
+		if err != nil {
+			t.Fatalf("read message: %v (got %v)", err, got)
+		}
+		got = append(got, string(raw))
+	}
+
+	cursors := 0
+	for _, msg := range got {
+		if msg == `{"type":"cursor","x":99}` {
+			cursors++
+		} else if msg != `{"type":"typing_update","char":"a"}` {
+			t.Fatalf("unexpected message %s", msg)
+		}
+	}
+	if cursors != 1 {
+		t.Fatalf("expected exactly the latest cursor, got %v", got)
+	}
+	if out, coalesced := client.messagesOut.Load(), client.messagesCoalesced.Load(); out != 2 || coalesced != 99 {
+		t.Fatalf("messagesOut = %d, coalesced = %d, want 2 and 99", out, coalesced)
+	}
+
+	client.closeSend()
+}
+
+func TestClient_composeTracksTyping(t *testing.T) {
+	c := &Client{}
+
+	for _, msg := range []struct {
+		kind string
+		char string
+	}{
+		{"typing_update", `"h"`},
+		{"typing_update", `"i"`},
+		{"typing_update", `"x"`},
+		{"typing_back", ""},
+	} {
+		var raw json.RawMessage
+		if msg.char != "" {
+			raw = json.RawMessage(msg.char)
+		}
+		c.compose(msg.kind, raw)
+	}
+	if got := c.Composition(); got != "hi" {
+		t.Fatalf("expected %q, got %q", "hi", got)
+	}
+
+	c.compose("typing_clear", nil)
+	if got := c.Composition(); got != "" {
+		t.Fatalf("expected empty composition after clear, got %q", got)
+	}
+
+	for range maxCompositionRunes + 10 {
+		c.compose("typing_update", json.RawMessage(`"a"`))
+	}
+	if got := len(c.Composition()); got != maxCompositionRunes {
+		t.Fatalf("expected composition capped at %d, got %d", maxCompositionRunes, got)
 	}
 }
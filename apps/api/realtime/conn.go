@@ -0,0 +1,88 @@
+package realtime
+
+import (
+	"io"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Conn is the message-oriented connection a Client pumps. It hides the
+// WebSocket library so alternate transports and in-memory tests can drive the
+// same read and write loops.
+//
+// ReadMessage returns io.EOF when the peer closed the connection normally.
+type Conn interface {
+	ReadMessage() ([]byte, error)
+	WriteMessage(data []byte) error
+	WritePing() error
+	WriteClose() error
+	Close() error
+
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetReadLimit(limit int64)
+	SetPongHandler(h func())
+}
+
+// wsConn adapts a gorilla/websocket connection to Conn.
+type wsConn struct {
+	conn *websocket.Conn
+}
+
+func NewWebsocketConn(conn *websocket.Conn) Conn {
+	return &wsConn{conn: conn}
+}
+
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	_, message, err := c.conn.ReadMessage()
+	if err != nil {
+		if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+
+	return message, nil
+}
+
+func (c *wsConn) WriteMessage(data []byte) error {
+	w, err := c.conn.NextWriter(websocket.TextMessage)
+	if err != nil {
+		return err
+	}
+	w.Write(data)
+
+	return w.Close()
+}
+
+func (c *wsConn) WritePing() error {
+	return c.conn.WriteMessage(websocket.PingMessage, nil)
+}
+
+func (c *wsConn) WriteClose() error {
+	return c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *wsConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *wsConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *wsConn) SetReadLimit(limit int64) {
+	c.conn.SetReadLimit(limit)
+}
+
+func (c *wsConn) SetPongHandler(h func()) {
+	c.conn.SetPongHandler(func(string) error {
+		h()
+		return nil
+	})
+}
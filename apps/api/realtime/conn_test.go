@@ -0,0 +1,199 @@
+package realtime
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type wsPair struct {
+	client *websocket.Conn
+	server Conn
+	close  func()
+}
+
+func newWebsocketPair(t *testing.T) wsPair {
+	t.Helper()
+
+	serverConnCh := make(chan *websocket.Conn, 1)
+
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade websocket: %v", err)
+			return
+		}
+		serverConnCh <- conn
+	}))
+
+	dialURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	clientConn, _, err := websocket.DefaultDialer.Dial(dialURL, nil)
+	if err != nil {
+		srv.Close()
+		t.Fatalf("dial websocket: %v", err)
+	}
+
+	serverConn := <-serverConnCh
+
+	return wsPair{
+		client: clientConn,
+		server: NewWebsocketConn(serverConn),
+		close: func() {
+			clientConn.Close()
+			serverConn.Close()
+			srv.Close()
+		},
+	}
+}
+
+func TestWebsocketConn_WritesTextMessages(t *testing.T) {
+	pair := newWebsocketPair(t)
+	defer pair.close()
+
+	if err := pair.server.WriteMessage([]byte(`{"type":"hello"}`)); err != nil {
+		t.Fatalf("write message: %v", err)
+	}
+
+	pair.client.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	mt, raw, err := pair.client.ReadMessage()
+	if err != nil {
+		t.Fatalf("read message: %v", err)
+	}
+	if mt != websocket.TextMessage {
+		t.Fatalf("expected text message, got %d", mt)
+	}
+	if string(raw) != `{"type":"hello"}` {
+		t.Fatalf("unexpected payload: %s", string(raw))
+	}
+}
+
+func TestWebsocketConn_ReadReturnsEOFOnNormalClose(t *testing.T) {
+	pair := newWebsocketPair(t)
+	defer pair.close()
+
+	if err := pair.client.WriteMessage(websocket.TextMessage, []byte("hi")); err != nil {
+		t.Fatalf("write message: %v", err)
+	}
+
+	raw, err := pair.server.ReadMessage()
+	if err != nil {
+		t.Fatalf("read message: %v", err)
+	}
+	if string(raw) != "hi" {
+		t.Fatalf("unexpected payload: %s", string(raw))
+	}
+
+	pair.client.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+
+	if _, err := pair.server.ReadMessage(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF after going-away close, got %v", err)
+	}
+}
+
+func TestWebsocketConn_PongHandlerFiresOnPing(t *testing.T) {
+	pair := newWebsocketPair(t)
+	defer pair.close()
+
+	// The client must be reading for gorilla to answer pings.
+	go func() {
+		for {
+			if _, _, err := pair.client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	pong := make(chan struct{}, 1)
+	pair.server.SetPongHandler(func() { pong <- struct{}{} })
+	go pair.server.ReadMessage()
+
+	if err := pair.server.WritePing(); err != nil {
+		t.Fatalf("write ping: %v", err)
+	}
+
+	select {
+	case <-pong:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("expected pong handler to fire")
+	}
+}
+
+func TestPipe_DeliversMessagesAndPongs(t *testing.T) {
+	a, b := NewPipe()
+	defer a.Close()
+	defer b.Close()
+
+	if err := a.WriteMessage([]byte("one")); err != nil {
+		t.Fatalf("write message: %v", err)
+	}
+
+	raw, err := b.ReadMessage()
+	if err != nil {
+		t.Fatalf("read message: %v", err)
+	}
+	if string(raw) != "one" {
+		t.Fatalf("unexpected payload: %s", string(raw))
+	}
+
+	pong := make(chan struct{}, 1)
+	a.SetPongHandler(func() { pong <- struct{}{} })
+	go b.ReadMessage()
+	go a.ReadMessage()
+
+	if err := a.WritePing(); err != nil {
+		t.Fatalf("write ping: %v", err)
+	}
+
+	select {
+	case <-pong:
+	case <-time.After(200 * time.Millisecond):
+		t.Fatalf("expected pong handler to fire")
+	}
+}
+
+func TestPipe_ReadHonoursDeadlineAndLimit(t *testing.T) {
+	a, b := NewPipe()
+	defer a.Close()
+	defer b.Close()
+
+	b.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := b.ReadMessage(); err == nil {
+		t.Fatalf("expected deadline error, got none")
+	}
+
+	b.SetReadDeadline(time.Time{})
+	b.SetReadLimit(4)
+	a.WriteMessage([]byte("too long"))
+	if _, err := b.ReadMessage(); !errors.Is(err, ErrReadLimit) {
+		t.Fatalf("expected ErrReadLimit, got %v", err)
+	}
+}
+
+func TestPipe_CloseEndsPeerReads(t *testing.T) {
+	a, b := NewPipe()
+	defer b.Close()
+
+	a.WriteMessage([]byte("queued"))
+	a.Close()
+
+	if raw, err := b.ReadMessage(); err != nil || string(raw) != "queued" {
+		t.Fatalf("expected queued message before close, got %q, %v", raw, err)
+	}
+	if _, err := b.ReadMessage(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF after peer close, got %v", err)
+	}
+	if err := b.WriteMessage([]byte("late")); !errors.Is(err, ErrPipeClosed) {
+		t.Fatalf("expected ErrPipeClosed writing to closed peer, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package realtime
+
+import (
+	"sync"
+	"time"
+)
+
+// EventAutoMute is emitted when reports mute a user.
+const EventAutoMute = "auto_mute"
+
+// eventBacklog is how many past events the hub keeps for operators who
+// connect later.
+const eventBacklog = 256
+
+// AdminEvent is something operators should know about. Seq increases by one
+// per event, so a client can tell whether it missed any.
+type AdminEvent struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	UserID    string    `json:"userId,omitempty"`
+	Reporters int       `json:"reporters,omitempty"`
+	Reasons   []string  `json:"reasons,omitempty"`
+}
+
+// eventLog keeps recent admin events and fans new ones out to subscribers.
+type eventLog struct {
+	mu          sync.Mutex
+	events      []AdminEvent
+	seq         uint64
+	subscribers map[chan AdminEvent]struct{}
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{subscribers: make(map[chan AdminEvent]struct{})}
+}
+
+func (l *eventLog) emit(e AdminEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	e.Seq = l.seq
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	l.events = append(l.events, e)
+	if over := len(l.events) - eventBacklog; over > 0 {
+		l.events = l.events[over:]
+	}
+
+	for ch := range l.subscribers {
+		// Subscribers that can't keep up miss events, and can tell from Seq.
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// subscribe returns the kept events and a channel of the ones that follow.
+// cancel must be called once the subscriber is done.
+func (l *eventLog) subscribe() (backlog []AdminEvent, events <-chan AdminEvent, cancel func()) {
+	ch := make(chan AdminEvent, 16)
+
+	l.mu.Lock()
+	backlog = append([]AdminEvent(nil), l.events...)
+	l.subscribers[ch] = struct{}{}
+	l.mu.Unlock()
+
+	return backlog, ch, func() {
+		l.mu.Lock()
+		delete(l.subscribers, ch)
+		l.mu.Unlock()
+	}
+}
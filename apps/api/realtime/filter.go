@@ -0,0 +1,203 @@
+package realtime
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"unicode"
+)
+
+// FilterAction is what the word filter does to a composition once a listed
+// word has been typed.
+type FilterAction string
+
+const (
+	// FilterMask replaces the word with asterisks for everyone else.
+	FilterMask FilterAction = "mask"
+	// FilterClear empties the author's bubble for everyone else.
+	FilterClear FilterAction = "clear"
+)
+
+// maskRune replaces each rune of a masked word.
+const maskRune = '*'
+
+// defaultFilterWords is the word list used when none is configured.
+var defaultFilterWords = []string{"fuck", "shit", "cunt", "bitch", "whore", "nazi"}
+
+// DefaultFilterWords returns a copy of the built-in word list.
+func DefaultFilterWords() []string {
+	return slices.Clone(defaultFilterWords)
+}
+
+// leetRunes maps the digits and symbols people substitute for letters back to
+// the letters, so "sh1t" and "$hit" match "shit".
+var leetRunes = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'8': 'b',
+	'@': 'a',
+	'$': 's',
+	'!': 'i',
+	'|': 'l',
+}
+
+type filterRule struct {
+	word    string
+	pattern []rune
+	hits    atomic.Int64
+}
+
+// WordFilter watches compositions for listed words as they are typed. Words
+// match from the start of a typed word, ignoring case, leetspeak and
+// punctuation, so "Sh1t", "$hit" and "s.h.i.t" all match "shit".
+type WordFilter struct {
+	action FilterAction
+	rules  []*filterRule
+
+	// window is how many runes before the newest ones a match may start in,
+	// leaving room for punctuation between the letters of the longest word.
+	window int
+}
+
+// NewWordFilter builds a filter for words that applies action on a match.
+func NewWordFilter(words []string, action FilterAction) (*WordFilter, error) {
+	if action != FilterMask && action != FilterClear {
+		return nil, fmt.Errorf("filter action must be %q or %q, got %q", FilterMask, FilterClear, action)
+	}
+
+	f := &WordFilter{action: action}
+	seen := make(map[string]bool)
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		pattern := normalizeWord(word)
+		if len(pattern) == 0 || seen[string(pattern)] {
+			continue
+		}
+		seen[string(pattern)] = true
+		f.rules = append(f.rules, &filterRule{word: word, pattern: pattern})
+		f.window = max(f.window, 4*len(pattern))
+	}
+
+	return f, nil
+}
+
+// LoadWordFilter reads one word per line from path, skipping blank lines and
+// lines starting with #.
+func LoadWordFilter(path string, action FilterAction) (*WordFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+
+	return NewWordFilter(words, action)
+}
+
+// Hits reports how many times each word has matched, keyed by the word as
+// listed.
+func (f *WordFilter) Hits() map[string]int64 {
+	hits := make(map[string]int64, len(f.rules))
+	for _, rule := range f.rules {
+		hits[rule.word] = rule.hits.Load()
+	}
+	return hits
+}
+
+// normalizedRune is one letter of a composition as the filter sees it, with
+// the index of the rune it came from. A zero r marks a word boundary.
+type normalizedRune struct {
+	r   rune
+	src int
+}
+
+func normalizeRune(r rune) (rune, bool) {
+	if leet, ok := leetRunes[r]; ok {
+		return leet, true
+	}
+	if unicode.IsLetter(r) {
+		return unicode.ToLower(r), true
+	}
+	return 0, false
+}
+
+func normalizeWord(word string) []rune {
+	var pattern []rune
+	for _, r := range word {
+		if n, ok := normalizeRune(r); ok {
+			pattern = append(pattern, n)
+		}
+	}
+	return pattern
+}
+
+// normalizeComposition normalizes text from offset on. The preceding rune, if
+// any, decides whether text[offset] starts a word.
+func normalizeComposition(text []rune, offset int) []normalizedRune {
+	normalized := make([]normalizedRune, 0, len(text)-offset+1)
+	if offset > 0 && unicode.IsSpace(text[offset-1]) {
+		normalized = append(normalized, normalizedRune{src: offset - 1})
+	}
+
+	for i := offset; i < len(text); i++ {
+		switch n, ok := normalizeRune(text[i]); {
+		case ok:
+			normalized = append(normalized, normalizedRune{r: n, src: i})
+		case unicode.IsSpace(text[i]):
+			normalized = append(normalized, normalizedRune{src: i})
+		}
+	}
+	return normalized
+}
+
+// match finds a listed word completed by the last added runes of text and
+// returns the span of text it covers.
+func (f *WordFilter) match(text []rune, added int) (start, end int, ok bool) {
+	first := max(len(text)-added, 0)
+	offset := max(first-f.window, 0)
+	normalized := normalizeComposition(text, offset)
+
+	for last := len(normalized) - 1; last >= 0 && normalized[last].src >= first; last-- {
+		for _, rule := range f.rules {
+			from := last - len(rule.pattern) + 1
+			if from < 0 || (from == 0 && offset > 0) || (from > 0 && normalized[from-1].r != 0) {
+				continue
+			}
+			if !matchesAt(normalized[from:last+1], rule.pattern) {
+				continue
+			}
+
+			rule.hits.Add(1)
+			return normalized[from].src, normalized[last].src + 1, true
+		}
+	}
+	return 0, 0, false
+}
+
+func matchesAt(normalized []normalizedRune, pattern []rune) bool {
+	for i, r := range pattern {
+		if normalized[i].r != r {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,165 @@
+package realtime
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWordFilter_MatchesNormalizedWords(t *testing.T) {
+	f, err := NewWordFilter([]string{"shit", "Nazi"}, FilterMask)
+	if err != nil {
+		t.Fatalf("new filter: %v", err)
+	}
+
+	tests := []struct {
+		text  string
+		match string
+	}{
+		{"oh shit", "shit"},
+		{"oh SHIT", "SHIT"},
+		{"oh $h1t", "$h1t"},
+		{"oh s.h.i.t", "s.h.i.t"},
+		{"n4z!", "n4z!"},
+		{"shitty", ""},      // the word completed earlier, not on this keystroke
+		{"oh shi", ""},      // not complete yet
+		{"unshit", ""},      // only from the start of a word
+		{"nazis are", ""},   // completed before the last keystroke
+		{"hello world", ""}, // nothing listed
+	}
+
+	for _, tt := range tests {
+		text := []rune(tt.text)
+		start, end, ok := f.match(text, 1)
+		got := ""
+		if ok {
+			got = string(text[start:end])
+		}
+		if got != tt.match {
+			t.Errorf("match(%q) = %q, want %q", tt.text, got, tt.match)
+		}
+	}
+
+	if hits := f.Hits(); hits["shit"] != 4 || hits["nazi"] != 1 {
+		t.Fatalf("hits = %v, want shit:4 nazi:1", hits)
+	}
+}
+
+func TestWordFilter_MatchesPastedText(t *testing.T) {
+	f, err := NewWordFilter([]string{"shit"}, FilterMask)
+	if err != nil {
+		t.Fatalf("new filter: %v", err)
+	}
+
+	text := []rune("well shit happens")
+	start, end, ok := f.match(text, len(text))
+	if !ok || string(text[start:end]) != "shit" {
+		t.Fatalf("expected the pasted word to match, got ok=%v span=%d..%d", ok, start, end)
+	}
+}
+
+func TestWordFilter_RejectsUnknownAction(t *testing.T) {
+	if _, err := NewWordFilter(defaultFilterWords, "shout"); err == nil {
+		t.Fatal("expected an error for an unknown action")
+	}
+}
+
+func TestLoadWordFilter_SkipsCommentsAndBlankLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte("# rude words\n\nheck\n  darn  \n"), 0o600); err != nil {
+		t.Fatalf("write word list: %v", err)
+	}
+
+	f, err := LoadWordFilter(path, FilterClear)
+	if err != nil {
+		t.Fatalf("load filter: %v", err)
+	}
+
+	hits := f.Hits()
+	if len(hits) != 2 {
+		t.Fatalf("expected 2 rules, got %v", hits)
+	}
+	if _, ok := hits["darn"]; !ok {
+		t.Fatalf("expected a darn rule, got %v", hits)
+	}
+}
+
+func typeText(c *Client, text string) {
+	for _, r := range text {
+		char, _ := json.Marshal(string(r))
+		c.handleMessage([]byte(`{"type":"typing_update","char":` + string(char) + `}`))
+	}
+}
+
+func TestHub_WordFilterMasksCompletedWords(t *testing.T) {
+	h, sender, receiver := setupHubWithClients(t)
+	f, err := NewWordFilter([]string{"shit"}, FilterMask)
+	if err != nil {
+		t.Fatalf("new filter: %v", err)
+	}
+	h.wordFilter = f
+
+	typeText(sender, "oh sh1t")
+
+	var last []byte
+	for {
+		raw := readWithTimeout(receiver.send, 100*time.Millisecond)
+		if raw == nil {
+			break
+		}
+		last = raw
+	}
+
+	var msg TypingReplaceMessage
+	if err := json.Unmarshal(last, &msg); err != nil {
+		t.Fatalf("failed to decode last message: %v", err)
+	}
+	if msg.Type != "typing_replace" || msg.UserID != "sender-1" || msg.Text != "oh ****" {
+		t.Fatalf("expected a masked typing_replace, got %+v", msg)
+	}
+	if got := sender.Composition(); got != "oh ****" {
+		t.Fatalf("composition = %q, want the masked text", got)
+	}
+
+	typeText(sender, "!")
+	var relay RelayMessage
+	if err := json.Unmarshal(readWithTimeout(receiver.send, 200*time.Millisecond), &relay); err != nil {
+		t.Fatalf("failed to decode relay: %v", err)
+	}
+	if relay.Type != "typing_update" || relay.Char != "!" {
+		t.Fatalf("expected typing to carry on after the mask, got %+v", relay)
+	}
+}
+
+func TestHub_WordFilterCanClearTheBubble(t *testing.T) {
+	h, sender, receiver := setupHubWithClients(t)
+	f, err := NewWordFilter([]string{"shit"}, FilterClear)
+	if err != nil {
+		t.Fatalf("new filter: %v", err)
+	}
+	h.wordFilter = f
+
+	typeText(sender, "shit")
+
+	var last []byte
+	for {
+		raw := readWithTimeout(receiver.send, 100*time.Millisecond)
+		if raw == nil {
+			break
+		}
+		last = raw
+	}
+
+	var msg RelayMessage
+	if err := json.Unmarshal(last, &msg); err != nil {
+		t.Fatalf("failed to decode last message: %v", err)
+	}
+	if msg.Type != "typing_clear" || msg.UserID != "sender-1" {
+		t.Fatalf("expected typing_clear, got %+v", msg)
+	}
+	if got := sender.Composition(); got != "" {
+		t.Fatalf("composition = %q, want it cleared", got)
+	}
+}
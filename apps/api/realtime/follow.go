@@ -0,0 +1,114 @@
+package realtime
+
+import "sync"
+
+// followIndex routes published viewports to the clients following their
+// author, so a presenter can pan and zoom for a whole group. A client follows
+// at most one user at a time, and only users that opted in to publishing have
+// their viewport sent anywhere.
+type followIndex struct {
+	mu        sync.RWMutex
+	followers map[string]map[*Client]struct{}
+	following map[*Client]string
+
+	// published holds the latest viewport message of every publishing user,
+	// so a new follower can jump straight to it.
+	published map[string][]byte
+}
+
+func newFollowIndex() *followIndex {
+	return &followIndex{
+		followers: make(map[string]map[*Client]struct{}),
+		following: make(map[*Client]string),
+		published: make(map[string][]byte),
+	}
+}
+
+// follow subscribes c to leaderID's viewport, replacing any previous leader.
+// It returns the leader's latest published viewport, if there is one.
+func (f *followIndex) follow(c *Client, leaderID string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// The hub closes a client before dropping it from the index, so a late
+	// follow from a departing client can't re-add it.
+	if c.isClosed() {
+		return nil
+	}
+
+	f.unfollowLocked(c)
+	f.following[c] = leaderID
+
+	members, ok := f.followers[leaderID]
+	if !ok {
+		members = make(map[*Client]struct{})
+		f.followers[leaderID] = members
+	}
+	members[c] = struct{}{}
+
+	return f.published[leaderID]
+}
+
+func (f *followIndex) unfollow(c *Client) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unfollowLocked(c)
+}
+
+func (f *followIndex) unfollowLocked(c *Client) {
+	leaderID, ok := f.following[c]
+	if !ok {
+		return
+	}
+	delete(f.following, c)
+
+	delete(f.followers[leaderID], c)
+	if len(f.followers[leaderID]) == 0 {
+		delete(f.followers, leaderID)
+	}
+}
+
+// publish records leaderID's latest viewport and returns a delivery filter
+// for its current followers. ok is false when nobody is following.
+func (f *followIndex) publish(leaderID string, data []byte) (filter func(*Client) bool, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.published[leaderID] = data
+
+	if len(f.followers[leaderID]) == 0 {
+		return nil, false
+	}
+
+	followers := make(map[*Client]struct{}, len(f.followers[leaderID]))
+	for c := range f.followers[leaderID] {
+		followers[c] = struct{}{}
+	}
+
+	return func(c *Client) bool {
+		_, ok := followers[c]
+		return ok
+	}, true
+}
+
+// stopPublishing forgets leaderID's viewport. Followers stay subscribed and
+// pick up again if the user resumes publishing.
+func (f *followIndex) stopPublishing(leaderID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.published, leaderID)
+}
+
+// remove drops c both as a follower and as a leader.
+func (f *followIndex) remove(c *Client) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.unfollowLocked(c)
+	delete(f.published, c.userID)
+
+	for follower := range f.followers[c.userID] {
+		delete(f.following, follower)
+	}
+	delete(f.followers, c.userID)
+}
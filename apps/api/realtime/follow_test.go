@@ -0,0 +1,119 @@
+package realtime
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestFollowIndex_PublishFiltersToFollowers(t *testing.T) {
+	f := newFollowIndex()
+	follower := &Client{userID: "follower"}
+	bystander := &Client{userID: "bystander"}
+
+	if _, ok := f.publish("leader", []byte("v1")); ok {
+		t.Fatalf("expected no followers yet")
+	}
+
+	if latest := f.follow(follower, "leader"); string(latest) != "v1" {
+		t.Fatalf("expected latest published viewport on follow, got %q", latest)
+	}
+
+	filter, ok := f.publish("leader", []byte("v2"))
+	if !ok {
+		t.Fatalf("expected followers after follow")
+	}
+	if !filter(follower) || filter(bystander) {
+		t.Fatalf("expected only the follower to pass the filter")
+	}
+
+	f.unfollow(follower)
+	if _, ok := f.publish("leader", []byte("v3")); ok {
+		t.Fatalf("expected no followers after unfollow")
+	}
+}
+
+func TestFollowIndex_FollowReplacesPreviousLeader(t *testing.T) {
+	f := newFollowIndex()
+	follower := &Client{userID: "follower"}
+
+	f.follow(follower, "a")
+	f.follow(follower, "b")
+
+	if _, ok := f.publish("a", []byte("v")); ok {
+		t.Fatalf("expected follower to have left a")
+	}
+	if _, ok := f.publish("b", []byte("v")); !ok {
+		t.Fatalf("expected follower to follow b")
+	}
+}
+
+func TestFollowIndex_RemoveDropsLeaderAndFollower(t *testing.T) {
+	f := newFollowIndex()
+	leader := &Client{userID: "leader"}
+	follower := &Client{userID: "follower"}
+
+	f.publish("leader", []byte("v"))
+	f.follow(follower, "leader")
+	f.follow(leader, "someone-else")
+
+	f.remove(leader)
+
+	if len(f.followers) != 0 || len(f.following) != 0 || len(f.published) != 0 {
+		t.Fatalf("expected empty index, got followers=%v following=%v published=%v", f.followers, f.following, f.published)
+	}
+}
+
+func TestClient_handleMessage_publishedViewportOnlyReachesFollowers(t *testing.T) {
+	h, leader, follower := setupHubWithClients(t)
+
+	bystander := newTestClient(h, "bystander", 10)
+	h.Register(bystander)
+	drainChannel(follower.send)
+	t.Cleanup(func() { h.unregister <- bystander })
+
+	follower.coalescedReady = make(chan struct{}, 1)
+	bystander.coalescedReady = make(chan struct{}, 1)
+
+	follower.handleMessage([]byte(`{"type":"follow","userId":"` + leader.userID + `"}`))
+	leader.handleMessage([]byte(`{"type":"viewport","x":10,"y":20,"width":800,"height":600,"publish":true}`))
+
+	select {
+	case <-follower.coalescedReady:
+	case <-time.After(200 * time.Millisecond):
+		t.Fatalf("expected follower to receive the published viewport")
+	}
+
+	messages := follower.takeCoalesced()
+	if len(messages) != 1 {
+		t.Fatalf("expected one viewport, got %d", len(messages))
+	}
+
+	var msg ViewportMessage
+	if err := json.Unmarshal(messages[0], &msg); err != nil {
+		t.Fatalf("unmarshal viewport: %v", err)
+	}
+	if msg.Type != "viewport" || msg.UserID != leader.userID || msg.X != 10 || msg.Width != 800 {
+		t.Fatalf("unexpected viewport: %+v", msg)
+	}
+
+	select {
+	case <-bystander.coalescedReady:
+		t.Fatalf("expected bystander not to receive the viewport, got %s", bystander.takeCoalesced())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestClient_handleMessage_unpublishedViewportStaysPrivate(t *testing.T) {
+	_, leader, follower := setupHubWithClients(t)
+	follower.coalescedReady = make(chan struct{}, 1)
+
+	follower.handleMessage([]byte(`{"type":"follow","userId":"` + leader.userID + `"}`))
+	leader.handleMessage([]byte(`{"type":"viewport","x":10,"y":20,"width":800,"height":600}`))
+
+	select {
+	case <-follower.coalescedReady:
+		t.Fatalf("expected no viewport without publish, got %s", follower.takeCoalesced())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -3,91 +3,546 @@ package realtime
 import (
 	"encoding/json"
 	"log"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// defaultCursorInterval caps cursor relays at 20 updates per second per client.
+const defaultCursorInterval = 50 * time.Millisecond
+
 type broadcastRequest struct {
-	data    []byte
+	data []byte
+
+	// exclude is the client whose message this is. It doesn't get it back,
+	// and neither do clients that have blocked it.
 	exclude *Client
+
+	// filter, when set, limits delivery to the clients it accepts.
+	filter func(*Client) bool
+
+	// coalesceKey, when set, replaces any undelivered message with the same
+	// key instead of queueing behind it.
+	coalesceKey string
+
+	// lossy messages, like keystrokes and cursors, are skipped for clients
+	// whose tab is hidden; they catch up from a snapshot when they return.
+	lossy bool
 }
 
+// deliverTo hands req to c if c is one of its recipients.
+func (req broadcastRequest) deliverTo(c *Client) {
+	if c == req.exclude || (req.filter != nil && !req.filter(c)) {
+		return
+	}
+	if req.exclude != nil && c.blocks(req.exclude.userID) {
+		return
+	}
+	if req.lossy && c.isHidden() {
+		return
+	}
+
+	if req.coalesceKey != "" {
+		c.trySendCoalesced(req.coalesceKey, req.data)
+	} else {
+		c.trySend(req.data)
+	}
+}
+
+type moveRequest struct {
+	client *Client
+	to     Point
+}
+
+// Hub tracks connected clients and fans messages out to them. Membership and
+// presence are handled by the Run loop; delivery is partitioned across shards,
+// each with its own loop, so a broadcast to a large room is spread over
+// several cores instead of being serialized behind one goroutine.
 type Hub struct {
 	clients    map[*Client]bool
-	broadcast  chan broadcastRequest
+	shards     []*shard
+	next       int
 	register   chan *Client
 	unregister chan *Client
+	moves      chan moveRequest
+
+	// presenceUpdates carries clients whose presence details changed, and
+	// snapshots clients that came back to a visible tab.
+	presenceUpdates chan *Client
+	snapshots       chan *Client
+
+	// calls runs operator requests on the Run loop.
+	calls chan func()
+
+	// typingMu orders composition snapshots against typing relays: relays
+	// update the author's composition and queue the message under a read
+	// lock, and a snapshot is built and queued under the write lock, so every
+	// keystroke is either in the snapshot or delivered after it.
+	typingMu sync.RWMutex
+
+	// presenceWindow coalesces membership changes into one presence update per
+	// client. presenceDue is armed while an update is pending.
+	presenceWindow time.Duration
+	presenceDue    <-chan time.Time
+
+	// idleAfter and awayAfter are how long a client may go quiet before its
+	// status drops to idle or away.
+	idleAfter time.Duration
+	awayAfter time.Duration
+
+	// cursorInterval is the minimum time between relayed cursor updates from
+	// one client.
+	cursorInterval time.Duration
+
+	// layout gives every client a shared, collision-free canvas position and
+	// identities a unique pseudonym and color, both from the room seed;
+	// interest indexes viewports so typing is only relayed to clients that
+	// can see the author.
+	layout     *layout
+	identities *identities
+	interest   *interestGrid
+
+	// follows routes published viewports to the clients following them.
+	follows *followIndex
+
+	// regions, when set, partitions an unbounded canvas so relays and
+	// presence stay local to the regions a client can see. population is
+	// the number of registered clients, readable outside the Run loop.
+	regions    *regionRegistry
+	population atomic.Int64
+
+	// proximity, when positive, limits relays and presence to clients within
+	// that distance of each other, and lets clients move themselves.
+	proximity float64
+
+	// moderation decides whose typing reaches the room.
+	moderation *Moderation
+
+	// wordFilter, when set, masks or clears listed words as they are typed.
+	wordFilter *WordFilter
+
+	// abuse, when set, cools down clients that look like bots. It is built
+	// from abuseLimits once every option has applied.
+	abuse       *abuseDetector
+	abuseLimits *AbuseThresholds
+
+	// reports counts user reports, and events tells operators what came of
+	// them.
+	reports *reportTracker
+	events  *eventLog
+}
+
+// Option configures a Hub.
+type Option func(*Hub)
+
+// WithShards sets the number of delivery shards. Values below one are ignored.
+func WithShards(n int) Option {
+	return func(h *Hub) {
+		if n >= 1 {
+			h.shards = newShards(n)
+		}
+	}
+}
+
+// WithPresenceDebounce coalesces presence changes that happen within window
+// into a single update, so a burst of N joins costs N messages instead of N².
+// Zero sends presence on every change.
+func WithPresenceDebounce(window time.Duration) Option {
+	return func(h *Hub) {
+		if window > 0 {
+			h.presenceWindow = window
+		}
+	}
+}
+
+// WithCursorInterval sets the minimum time between relayed cursor updates from
+// one client. Faster updates are coalesced so only the latest is relayed. Zero
+// relays every update.
+func WithCursorInterval(interval time.Duration) Option {
+	return func(h *Hub) {
+		if interval >= 0 {
+			h.cursorInterval = interval
+		}
+	}
+}
+
+// WithRegionSize turns on region mode: the canvas grows without bound and is
+// split into size×size regions, each run by its own actor. Clients subscribe
+// to the regions their viewport overlaps and get presence for those regions
+// only, plus a room-wide population count. Zero keeps the single shared
+// canvas.
+func WithRegionSize(size float64) Option {
+	return func(h *Hub) {
+		if size > 0 {
+			h.regions = newRegionRegistry(h, size)
+			h.layout.growable = true
+		}
+	}
+}
+
+// WithIdleThresholds sets how long a client may go without sending anything
+// before presence shows it as idle, and then as away. Non-positive values keep
+// the defaults.
+func WithIdleThresholds(idle, away time.Duration) Option {
+	return func(h *Hub) {
+		if idle > 0 {
+			h.idleAfter = idle
+		}
+		if away > 0 {
+			h.awayAfter = away
+		}
+	}
+}
+
+// WithProximity turns on proximity mode: clients only receive typing, cursors
+// and presence from users whose bubble is within radius of their own, and can
+// walk around the canvas with move messages. Zero keeps everyone in range.
+func WithProximity(radius float64) Option {
+	return func(h *Hub) {
+		if radius > 0 {
+			h.proximity = radius
+		}
+	}
 }
 
-func NewHub() *Hub {
-	return &Hub{
+// WithModeration shares a sanction list with the hub. Without it the hub keeps
+// its own in memory.
+func WithModeration(m *Moderation) Option {
+	return func(h *Hub) {
+		if m != nil {
+			h.moderation = m
+		}
+	}
+}
+
+// WithWordFilter screens every composition with f. Nil turns filtering off.
+func WithWordFilter(f *WordFilter) Option {
+	return func(h *Hub) {
+		h.wordFilter = f
+	}
+}
+
+// WithAbuseHeuristics scores clients on repeated text, machine-regular typing
+// and reconnect churn, muting or disconnecting them past limits.
+func WithAbuseHeuristics(limits AbuseThresholds) Option {
+	return func(h *Hub) {
+		h.abuseLimits = &limits
+	}
+}
+
+// WithReportLimits sets how many distinct reporters, within what window, mute
+// a user. Limits with a non-positive field are ignored.
+func WithReportLimits(limits ReportLimits) Option {
+	return func(h *Hub) {
+		if limits.Threshold > 0 && limits.Window > 0 && limits.PerReporter > 0 && limits.MuteFor > 0 {
+			h.reports = newReportTracker(limits)
+		}
+	}
+}
+
+// NewHub creates a hub with one delivery shard per GOMAXPROCS unless
+// configured otherwise.
+func NewHub(opts ...Option) *Hub {
+	seed := randomSeed()
+	h := &Hub{
 		clients:    make(map[*Client]bool),
-		broadcast:  make(chan broadcastRequest, 256),
+		shards:     newShards(runtime.GOMAXPROCS(0)),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		moves:      make(chan moveRequest),
+		layout:     newLayout(seed),
+		identities: newIdentities(seed),
+		interest:   newInterestGrid(),
+		follows:    newFollowIndex(),
+		moderation: NewModeration(),
+		reports:    newReportTracker(DefaultReportLimits()),
+		events:     newEventLog(),
+
+		presenceUpdates: make(chan *Client),
+		snapshots:       make(chan *Client),
+		calls:           make(chan func()),
+		cursorInterval:  defaultCursorInterval,
+		idleAfter:       defaultIdleAfter,
+		awayAfter:       defaultAwayAfter,
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	if h.abuseLimits != nil {
+		h.abuse = newAbuseDetector(*h.abuseLimits, h.moderation)
 	}
+
+	return h
 }
 
 func (h *Hub) Register(client *Client) {
+	client.peer.ticket.claim()
 	h.register <- client
 }
 
 func (h *Hub) Run() {
+	for _, s := range h.shards {
+		go s.run()
+	}
+
+	statusTicker := time.NewTicker(statusTick(h.idleAfter, h.awayAfter))
+	defer statusTicker.Stop()
+
 	for {
 		select {
 		case client := <-h.register:
+			if h.abuse != nil && h.abuse.connected(client) {
+				client.peer.ticket.done()
+				client.closeSend()
+				if client.registered != nil {
+					close(client.registered)
+				}
+				continue
+			}
+
 			h.clients[client] = true
-			log.Printf("Client registered: %s (total: %d)", client.userID, len(h.clients))
-			h.broadcastPresence()
+			client.setPosition(h.layout.place(client.userID))
+			client.setIdentity(h.identities.assign(client.userID))
+			client.refreshStatus(time.Now(), h.idleAfter, h.awayAfter)
+			if client.registered != nil {
+				close(client.registered)
+			}
+			client.shard = h.shards[h.next%len(h.shards)]
+			h.next++
+			client.shard.membership <- membershipChange{client: client, join: true}
+			h.population.Add(1)
+			if h.regions != nil {
+				h.regions.join(client, client.Position())
+				h.regions.subscribeDefault(client)
+			}
+			log.Printf("Client registered: %s from %s (total: %d)", client.userID, client.peer.IP(), len(h.clients))
+			h.presenceChanged()
 
 		case client := <-h.unregister:
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-				log.Printf("Client unregistered: %s (total: %d)", client.userID, len(h.clients))
-				h.broadcastPresence()
+			h.remove(client)
+
+		case fn := <-h.calls:
+			fn()
+
+		case req := <-h.moves:
+			if _, ok := h.clients[req.client]; ok {
+				h.move(req.client, req.to)
 			}
 
-		case req := <-h.broadcast:
-			for client := range h.clients {
-				if client != req.exclude {
-					h.trySend(client, req.data)
+		case client := <-h.presenceUpdates:
+			if _, ok := h.clients[client]; ok {
+				client.refreshStatus(time.Now(), h.idleAfter, h.awayAfter)
+				if h.regions != nil {
+					h.regions.join(client, client.Position())
 				}
+				h.presenceChanged()
+			}
+
+		case client := <-h.snapshots:
+			if _, ok := h.clients[client]; ok {
+				h.sendSnapshot(client)
+			}
+
+		case now := <-statusTicker.C:
+			h.refreshStatuses(now)
+
+		case <-h.presenceDue:
+			h.presenceDue = nil
+			h.broadcastPresence()
+		}
+	}
+}
+
+// remove drops client from the room and closes its send buffer, which tells
+// its transport to hang up. Removing a client twice is a no-op.
+func (h *Hub) remove(client *Client) {
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+
+	delete(h.clients, client)
+	client.peer.ticket.done()
+	client.closeSend()
+	h.interest.remove(client)
+	h.follows.remove(client)
+	if h.regions != nil {
+		h.regions.remove(client)
+	}
+	h.population.Add(-1)
+	h.layout.release(client.userID)
+	h.identities.release(client.Identity().Name)
+	h.moderation.forget(subjectUser + client.userID)
+	client.shard.membership <- membershipChange{client: client}
+	log.Printf("Client unregistered: %s (total: %d)", client.userID, len(h.clients))
+	h.presenceChanged()
+}
+
+// do runs fn on the Run loop, where it may touch hub state, and waits for it.
+func (h *Hub) do(fn func()) {
+	done := make(chan struct{})
+	h.calls <- func() {
+		defer close(done)
+		fn()
+	}
+	<-done
+}
+
+// move places client at to. On the bounded canvas the bubble is kept on it.
+func (h *Hub) move(client *Client, to Point) {
+	if h.regions == nil {
+		to = clampToCanvas(to)
+	}
+
+	h.layout.set(client.userID, to)
+	client.setPosition(to)
+	if h.regions != nil {
+		h.regions.join(client, to)
+	}
+	h.presenceChanged()
+}
+
+// refreshStatuses recomputes every client's status and announces presence if
+// any of them changed.
+func (h *Hub) refreshStatuses(now time.Time) {
+	changed := false
+	for client := range h.clients {
+		if client.refreshStatus(now, h.idleAfter, h.awayAfter) {
+			changed = true
+			if h.regions != nil {
+				h.regions.join(client, client.Position())
 			}
 		}
 	}
+
+	if changed {
+		h.presenceChanged()
+	}
+}
+
+// presenceChanged broadcasts presence now, or once the debounce window closes
+// if one is configured.
+func (h *Hub) presenceChanged() {
+	if h.presenceWindow == 0 {
+		h.broadcastPresence()
+		return
+	}
+
+	if h.presenceDue == nil {
+		h.presenceDue = time.After(h.presenceWindow)
+	}
 }
 
 // broadcastPresence sends every connected client the presence list of all other
 // connected clients. Each client is excluded from its own list because clients
-// don't know their own server-assigned ID and rely on the server to filter it out.
+// don't know their own server-assigned ID and rely on the server to filter it out;
+// their own entry is sent separately as self so they can find their spot on the
+// shared canvas.
+//
+// In region mode presence comes from the regions, and this only announces the
+// room-wide population.
 func (h *Hub) broadcastPresence() {
-	for target := range h.clients {
-		users := make([]PresenceUser, 0, len(h.clients))
-		for c := range h.clients {
-			if c != target {
-				users = append(users, PresenceUser{ID: c.userID})
+	if h.regions != nil {
+		h.broadcastPopulation()
+		return
+	}
+
+	targets := make([]*Client, 0, len(h.clients))
+	snapshot := make([]PresenceUser, 0, len(h.clients))
+	for c := range h.clients {
+		targets = append(targets, c)
+		snapshot = append(snapshot, c.presence())
+	}
+
+	// Every target sees the same list minus itself, so one buffer is reused
+	// rather than rebuilding the list per client.
+	users := make([]PresenceUser, 0, len(snapshot))
+	for i, target := range targets {
+		if h.proximity > 0 {
+			users = users[:0]
+			for j, u := range snapshot {
+				if j != i && within(*u.Position, *snapshot[i].Position, h.proximity) {
+					users = append(users, u)
+				}
 			}
+		} else {
+			users = append(append(users[:0], snapshot[:i]...), snapshot[i+1:]...)
+		}
+		if target.blocked.Load() != nil {
+			users = slices.DeleteFunc(users, func(u PresenceUser) bool { return target.blocks(u.ID) })
 		}
 
-		data, err := json.Marshal(PresenceMessage{Type: "presence", Users: users})
+		self := target.selfPresence()
+		data, err := json.Marshal(PresenceMessage{Type: "presence", Users: users, Self: &self})
 		if err != nil {
 			log.Printf("Error marshaling presence for client %s: %v", target.userID, err)
 			continue
 		}
 
-		h.trySend(target, data)
+		target.trySend(data)
 	}
 }
 
-// trySend delivers data to the client's send buffer, dropping the message if the
-// buffer is full so a slow client can't block the hub.
-func (h *Hub) trySend(c *Client, data []byte) {
-	select {
-	case c.send <- data:
-	default:
-		log.Printf("Client %s send buffer full, skipping message", c.userID)
+func (h *Hub) broadcastPopulation() {
+	data, err := json.Marshal(PopulationMessage{Type: "population", Total: len(h.clients)})
+	if err != nil {
+		log.Printf("Error marshaling population: %v", err)
+		return
+	}
+
+	h.fanOut(broadcastRequest{data: data})
+}
+
+// sendSnapshot sends target the current composition of every author whose
+// typing it would receive. It travels through target's shard, behind any
+// relays queued before it. In region mode typing is delivered by the regions
+// instead, so each region target watches sends its own part of the snapshot.
+func (h *Hub) sendSnapshot(target *Client) {
+	h.typingMu.Lock()
+	defer h.typingMu.Unlock()
+
+	compositions := make(map[*Client]Composition)
+	for author := range h.clients {
+		if author == target {
+			continue
+		}
+
+		text := author.Composition()
+		if text == "" || target.blocks(author.userID) || !h.typingFilter(author)(target) {
+			continue
+		}
+		compositions[author] = Composition{UserID: author.userID, Text: text}
+	}
+
+	if h.regions != nil {
+		h.regions.sendSnapshot(target, compositions)
+		return
+	}
+
+	msg := TypingSnapshotMessage{Type: "typing_snapshot", Compositions: make([]Composition, 0, len(compositions))}
+	for _, composition := range compositions {
+		msg.Compositions = append(msg.Compositions, composition)
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshaling typing snapshot for client %s: %v", target.userID, err)
+		return
+	}
+
+	target.shard.broadcast <- broadcastRequest{
+		data:   data,
+		filter: func(c *Client) bool { return c == target },
 	}
 }
 
+// BroadcastMessageExcept delivers msg to every client but sender. The request
+// is queued on every shard directly from the caller's goroutine; since each
+// client's messages are relayed from its own read loop and shard queues are
+// FIFO, recipients always observe a given sender's messages in order.
 func (h *Hub) BroadcastMessageExcept(sender *Client, msg any) {
 	data, err := json.Marshal(msg)
 	if err != nil {
@@ -95,5 +550,114 @@ func (h *Hub) BroadcastMessageExcept(sender *Client, msg any) {
 		return
 	}
 
-	h.broadcast <- broadcastRequest{data: data, exclude: sender}
+	h.fanOut(broadcastRequest{data: data, exclude: sender})
+}
+
+// relay delivers a sender's typing message to the other clients whose viewport
+// covers the sender's bubble.
+func (h *Hub) relay(sender *Client, msg any) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshaling message: %v", err)
+		return
+	}
+
+	req := broadcastRequest{
+		data:    data,
+		exclude: sender,
+		filter:  h.typingFilter(sender),
+		lossy:   true,
+	}
+
+	if h.regions != nil {
+		h.regions.deliverFrom(sender, req)
+		return
+	}
+	h.fanOut(req)
+}
+
+// relayCursor delivers a sender's cursor to the other clients whose viewport
+// covers it. Recipients keep only the newest cursor per author queued.
+func (h *Hub) relayCursor(sender *Client, msg CursorMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshaling cursor: %v", err)
+		return
+	}
+
+	point := Point{X: msg.X, Y: msg.Y}
+	req := broadcastRequest{
+		data:        data,
+		exclude:     sender,
+		filter:      h.nearby(sender, h.interest.visibleTo(pointRect(point))),
+		coalesceKey: cursorKey(sender.userID),
+		lossy:       true,
+	}
+
+	if h.regions != nil {
+		h.regions.deliverAt(point, req)
+		return
+	}
+	h.fanOut(req)
+}
+
+// publishViewport forwards a publishing client's viewport to its followers.
+// Followers only need the newest one, so it is coalesced like cursors.
+func (h *Hub) publishViewport(sender *Client, viewport Rect) {
+	data, err := json.Marshal(ViewportMessage{
+		Type:   "viewport",
+		UserID: sender.userID,
+		X:      viewport.X,
+		Y:      viewport.Y,
+		Width:  viewport.Width,
+		Height: viewport.Height,
+	})
+	if err != nil {
+		log.Printf("Error marshaling viewport: %v", err)
+		return
+	}
+
+	filter, ok := h.follows.publish(sender.userID, data)
+	if !ok {
+		return
+	}
+
+	h.fanOut(broadcastRequest{
+		data:        data,
+		exclude:     sender,
+		filter:      filter,
+		coalesceKey: viewportKey(sender.userID),
+	})
+}
+
+// typingFilter accepts the clients that should see sender's typing.
+func (h *Hub) typingFilter(sender *Client) func(*Client) bool {
+	return h.nearby(sender, h.interest.visibleTo(bubbleRect(sender.Position())))
+}
+
+// nearby narrows filter to clients within the proximity radius of sender, when
+// proximity mode is on.
+func (h *Hub) nearby(sender *Client, filter func(*Client) bool) func(*Client) bool {
+	if h.proximity == 0 {
+		return filter
+	}
+
+	origin := sender.Position()
+	return func(c *Client) bool {
+		return within(c.Position(), origin, h.proximity) && filter(c)
+	}
+}
+
+func cursorKey(userID string) string {
+	return "cursor:" + userID
+}
+
+func viewportKey(userID string) string {
+	return "viewport:" + userID
+}
+
+func (h *Hub) fanOut(req broadcastRequest) {
+	for _, s := range h.shards {
+		s.broadcast <- req
+	}
 }
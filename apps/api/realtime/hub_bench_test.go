@@ -0,0 +1,108 @@
+package realtime
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+// BenchmarkHubBroadcast measures typing fan-out to a busy room. Run it across
+// CPU counts to see shard scaling, e.g.
+//
+//	go test -run '^$' -bench HubBroadcast -cpu 1,2,4,8 ./realtime
+func BenchmarkHubBroadcast(b *testing.B) {
+	for _, clients := range []int{100, 1000} {
+		b.Run(fmt.Sprintf("clients=%d", clients), func(b *testing.B) {
+			benchmarkHubBroadcast(b, clients)
+		})
+	}
+}
+
+// BenchmarkHubRelayInterest measures typing relayed to a room where most
+// clients are looking elsewhere, so each keystroke is filtered by viewport.
+func BenchmarkHubRelayInterest(b *testing.B) {
+	for _, clients := range []int{100, 1000} {
+		b.Run(fmt.Sprintf("clients=%d", clients), func(b *testing.B) {
+			benchmarkHubRelay(b, clients, func(h *Hub, i int, c *Client) {
+				viewport := Rect{X: float64(i) * 1000, Y: 5000, Width: 800, Height: 600}
+				if i%10 == 0 {
+					viewport = Rect{X: -400, Y: -300, Width: 800, Height: 600}
+				}
+				h.interest.update(c, viewport)
+			})
+		})
+	}
+}
+
+func benchmarkHubBroadcast(b *testing.B, clients int) {
+	benchmarkHubRelay(b, clients, nil)
+}
+
+// benchmarkHubRelay sends typing from parallel senders to clients set up by
+// setup, or broadcasts it to all of them when setup is nil.
+func benchmarkHubRelay(b *testing.B, clients int, setup func(h *Hub, i int, c *Client)) {
+	previous := log.Writer()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(previous) })
+
+	// Default sharding follows GOMAXPROCS, which -cpu sets per run.
+	h := NewHub()
+
+	// Seed membership directly so setup doesn't pay for a presence storm.
+	sentinel := []byte(`{"type":"done"}`)
+	received := make(chan struct{}, clients)
+	for i := range clients {
+		c := newTestClient(h, fmt.Sprintf("client-%d", i), 1024)
+		c.shard = h.shards[i%len(h.shards)]
+		c.shard.clients[c] = true
+		h.clients[c] = true
+		if setup != nil {
+			setup(h, i, c)
+		}
+
+		go func() {
+			for msg := range c.send {
+				if bytes.Equal(msg, sentinel) {
+					received <- struct{}{}
+				}
+			}
+		}()
+	}
+	go h.Run()
+
+	msg := map[string]string{"type": "typing_update", "userId": "sender", "char": "a"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		sender := newTestClient(h, "sender", 1)
+		for pb.Next() {
+			if setup != nil {
+				h.relay(sender, msg)
+			} else {
+				h.BroadcastMessageExcept(sender, msg)
+			}
+		}
+	})
+
+	// Every shard has drained once the sentinel reaches each client.
+	for _, s := range h.shards {
+		s.broadcast <- broadcastRequest{data: sentinel}
+	}
+	for range clients {
+		select {
+		case <-received:
+		case <-time.After(10 * time.Second):
+			b.Fatalf("timed out waiting for fan-out to drain")
+		}
+	}
+	b.StopTimer()
+
+	for c := range h.clients {
+		c.closeSend()
+	}
+}
@@ -2,6 +2,8 @@ package realtime
 
 import (
 	"encoding/json"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 )
@@ -328,3 +330,261 @@ func TestHub_BroadcastMessageExceptSkipsFullBuffers(t *testing.T) {
 		t.Fatalf("expected sender not to receive broadcast, got %s", string(raw))
 	}
 }
+
+func TestHub_ShardedBroadcastPreservesSenderOrder(t *testing.T) {
+	h := NewHub(WithShards(4))
+	go h.Run()
+
+	sender := newTestClient(h, "sender", 10)
+	h.Register(sender)
+
+	receivers := make([]*Client, 8)
+	for i := range receivers {
+		receivers[i] = newTestClient(h, "receiver-"+string(rune('a'+i)), 200)
+		h.Register(receivers[i])
+	}
+
+	for _, c := range append(receivers, sender) {
+		drainChannel(c.send)
+	}
+
+	const messages = 100
+	for i := range messages {
+		h.BroadcastMessageExcept(sender, map[string]int{"seq": i})
+	}
+
+	for _, receiver := range receivers {
+		for want := range messages {
+			raw := readWithTimeout(receiver.send, 200*time.Millisecond)
+			if raw == nil {
+				t.Fatalf("%s: expected message %d, got none", receiver.userID, want)
+			}
+
+			var msg struct {
+				Seq int `json:"seq"`
+			}
+			if err := json.Unmarshal(raw, &msg); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if msg.Seq != want {
+				t.Fatalf("%s: expected seq %d, got %d", receiver.userID, want, msg.Seq)
+			}
+		}
+	}
+}
+
+func TestHub_RegisterSpreadsClientsAcrossShards(t *testing.T) {
+	h := NewHub(WithShards(3))
+	go h.Run()
+
+	clients := make([]*Client, 6)
+	for i := range clients {
+		clients[i] = newTestClient(h, "client-"+string(rune('a'+i)), 20)
+		h.Register(clients[i])
+	}
+
+	// Unregistering a client is processed after every earlier registration.
+	h.unregister <- clients[0]
+
+	perShard := make(map[*shard]int)
+	for _, c := range clients {
+		perShard[c.shard]++
+	}
+
+	if len(perShard) != 3 {
+		t.Fatalf("expected clients on 3 shards, got %d", len(perShard))
+	}
+	for s, n := range perShard {
+		if n != 2 {
+			t.Fatalf("expected 2 clients on shard %p, got %d", s, n)
+		}
+	}
+}
+
+func TestHub_PresenceDebounceCoalescesRegistrationStorm(t *testing.T) {
+	const (
+		clients = 1000
+		window  = 100 * time.Millisecond
+	)
+
+	h := NewHub(WithPresenceDebounce(window))
+	go h.Run()
+
+	all := make([]*Client, clients)
+	for i := range all {
+		all[i] = newTestClient(h, fmt.Sprintf("user-%d", i), 8)
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range all {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.Register(c)
+		}()
+	}
+	wg.Wait()
+
+	// Building 1,000 presence lists takes a while under the race detector, so
+	// wait for every client to hear back and then for any trailing window.
+	deadline := time.Now().Add(10 * time.Second)
+	for _, c := range all {
+		for len(c.send) == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	time.Sleep(3 * window)
+
+	total := 0
+	for _, c := range all {
+		received := len(c.send)
+		if received == 0 || received > 2 {
+			t.Fatalf("%s: expected 1 or 2 presence updates, got %d", c.userID, received)
+		}
+		total += received
+
+		var last []byte
+		for range received {
+			last = <-c.send
+		}
+
+		var presence PresenceMessage
+		if err := json.Unmarshal(last, &presence); err != nil {
+			t.Fatalf("unmarshal presence: %v", err)
+		}
+		if len(presence.Users) != clients-1 {
+			t.Fatalf("%s: expected final presence with %d users, got %d", c.userID, clients-1, len(presence.Users))
+		}
+	}
+
+	if total > 2*clients {
+		t.Fatalf("expected at most %d presence messages, got %d", 2*clients, total)
+	}
+}
+
+func TestHub_PresenceDebounceCoversUnregister(t *testing.T) {
+	h := NewHub(WithPresenceDebounce(30 * time.Millisecond))
+	go h.Run()
+
+	stay := newTestClient(h, "stay", 10)
+	leave := newTestClient(h, "leave", 10)
+
+	h.Register(stay)
+	h.Register(leave)
+	h.unregister <- leave
+
+	if raw := readWithTimeout(stay.send, 10*time.Millisecond); raw != nil {
+		t.Fatalf("expected presence to wait for the debounce window, got %s", string(raw))
+	}
+
+	raw := readWithTimeout(stay.send, 200*time.Millisecond)
+	if raw == nil {
+		t.Fatalf("expected a single coalesced presence update, got none")
+	}
+
+	var presence PresenceMessage
+	if err := json.Unmarshal(raw, &presence); err != nil {
+		t.Fatalf("unmarshal presence: %v", err)
+	}
+	if len(presence.Users) != 0 {
+		t.Fatalf("expected empty presence after join and leave, got %+v", presence.Users)
+	}
+
+	if raw := readWithTimeout(stay.send, 100*time.Millisecond); raw != nil {
+		t.Fatalf("expected no further presence updates, got %s", string(raw))
+	}
+}
+
+// lastPresence drains c's buffer and returns the newest presence message.
+func lastPresence(t *testing.T, c *Client) PresenceMessage {
+	t.Helper()
+
+	var last PresenceMessage
+	found := false
+	for {
+		raw := readWithTimeout(c.send, 50*time.Millisecond)
+		if raw == nil {
+			break
+		}
+
+		var msg PresenceMessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if msg.Type == "presence" {
+			last, found = msg, true
+		}
+	}
+
+	if !found {
+		t.Fatalf("%s: expected a presence update", c.userID)
+	}
+	return last
+}
+
+func TestHub_ProximityLimitsPresenceAndTyping(t *testing.T) {
+	h := NewHub(WithProximity(300))
+	go h.Run()
+
+	author := newTestClient(h, "author", 64)
+	neighbour := newTestClient(h, "neighbour", 64)
+	stranger := newTestClient(h, "stranger", 64)
+	for _, c := range []*Client{author, neighbour, stranger} {
+		h.Register(c)
+	}
+
+	author.handleMessage([]byte(`{"type":"move","x":0,"y":0}`))
+	neighbour.handleMessage([]byte(`{"type":"move","x":200,"y":100}`))
+	stranger.handleMessage([]byte(`{"type":"move","x":900,"y":500}`))
+
+	if p := lastPresence(t, author); len(p.Users) != 1 || p.Users[0].ID != neighbour.userID {
+		t.Fatalf("expected author to see only the neighbour, got %+v", p.Users)
+	}
+	if p := lastPresence(t, stranger); len(p.Users) != 0 || p.Self == nil || *p.Self.Position != (Point{X: 900, Y: 500}) {
+		t.Fatalf("expected stranger alone at its new spot, got %+v self %+v", p.Users, p.Self)
+	}
+	drainChannel(neighbour.send)
+
+	author.handleMessage([]byte(`{"type":"typing_update","char":"h"}`))
+
+	if raw := readWithTimeout(neighbour.send, 200*time.Millisecond); raw == nil {
+		t.Fatalf("expected neighbour to receive typing")
+	}
+	if raw := readWithTimeout(stranger.send, 50*time.Millisecond); raw != nil {
+		t.Fatalf("expected stranger not to receive typing, got %s", raw)
+	}
+}
+
+func TestHub_MoveIsClampedToCanvas(t *testing.T) {
+	h := NewHub(WithProximity(300))
+	go h.Run()
+
+	c := newTestClient(h, "wanderer", 64)
+	h.Register(c)
+
+	c.handleMessage([]byte(`{"type":"move","x":-50,"y":100000}`))
+
+	want := Point{X: 0, Y: canvasHeight - bubbleHeight}
+	if p := lastPresence(t, c); p.Self == nil || *p.Self.Position != want {
+		t.Fatalf("expected clamped position %+v, got %+v", want, p.Self)
+	}
+}
+
+func TestHub_MoveIgnoredWithoutProximity(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+
+	c := newTestClient(h, "wanderer", 64)
+	h.Register(c)
+	drainChannel(c.send)
+	before := c.Position()
+
+	c.handleMessage([]byte(`{"type":"move","x":1,"y":1}`))
+
+	if raw := readWithTimeout(c.send, 50*time.Millisecond); raw != nil {
+		t.Fatalf("expected no presence after ignored move, got %s", raw)
+	}
+	if c.Position() != before {
+		t.Fatalf("expected position to stay %+v, got %+v", before, c.Position())
+	}
+}
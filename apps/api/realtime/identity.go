@@ -0,0 +1,96 @@
+package realtime
+
+import (
+	"math"
+	"strconv"
+)
+
+// Word lists and palette for anonymous identities. Entries are short so
+// "Adjective Animal" fits on a bubble label, and colors stay legible on both
+// the light and dark canvas.
+var (
+	identityAdjectives = []string{
+		"Brave", "Calm", "Clever", "Cosy", "Curious", "Daring", "Dizzy", "Eager",
+		"Fancy", "Fuzzy", "Gentle", "Giddy", "Happy", "Humble", "Jolly", "Keen",
+		"Lucky", "Mellow", "Merry", "Nimble", "Peppy", "Plucky", "Quiet", "Quirky",
+		"Rosy", "Sleepy", "Snappy", "Sunny", "Swift", "Tidy", "Witty", "Zesty",
+	}
+
+	identityAnimals = []string{
+		"Badger", "Beaver", "Bison", "Crane", "Dingo", "Dolphin", "Falcon", "Ferret",
+		"Gecko", "Heron", "Ibis", "Koala", "Lemur", "Lynx", "Magpie", "Marmot",
+		"Moose", "Newt", "Ocelot", "Otter", "Panda", "Puffin", "Quokka", "Raven",
+		"Robin", "Seal", "Sloth", "Stoat", "Tapir", "Toucan", "Walrus", "Yak",
+	}
+
+	identityColors = []string{
+		"#e11d48", "#db2777", "#c026d3", "#9333ea", "#7c3aed", "#4f46e5",
+		"#2563eb", "#0284c7", "#0891b2", "#0d9488", "#059669", "#16a34a",
+		"#65a30d", "#ca8a04", "#d97706", "#ea580c",
+	}
+)
+
+// identityDraws is how many seeded names are tried before falling back to a
+// numbered variant of the first one.
+const identityDraws = 16
+
+// Identity is the friendly face of an anonymous user.
+type Identity struct {
+	Name  string
+	Color string
+}
+
+// identities hands out pseudonyms and colors derived from the room seed and
+// the user's ID, so the same user in the same room always gets the same
+// identity, and keeps names unique among the users currently present.
+//
+// identities is owned by the hub loop and is not safe for concurrent use.
+type identities struct {
+	seed  uint32
+	names map[string]struct{}
+}
+
+func newIdentities(seed uint32) *identities {
+	return &identities{
+		seed:  seed,
+		names: make(map[string]struct{}),
+	}
+}
+
+// assign returns a free identity for userID and reserves its name.
+func (ids *identities) assign(userID string) Identity {
+	rand := mulberry32(hashString(formatSeed(ids.seed) + ":" + userID + ":identity"))
+	color := identityColors[pick(rand(), len(identityColors))]
+
+	var first string
+	for i := range identityDraws {
+		name := identityAdjectives[pick(rand(), len(identityAdjectives))] + " " +
+			identityAnimals[pick(rand(), len(identityAnimals))]
+		if i == 0 {
+			first = name
+		}
+
+		if _, taken := ids.names[name]; !taken {
+			ids.names[name] = struct{}{}
+			return Identity{Name: name, Color: color}
+		}
+	}
+
+	for n := 2; ; n++ {
+		name := first + " " + strconv.Itoa(n)
+		if _, taken := ids.names[name]; !taken {
+			ids.names[name] = struct{}{}
+			return Identity{Name: name, Color: color}
+		}
+	}
+}
+
+// release frees name for the next user who draws it.
+func (ids *identities) release(name string) {
+	delete(ids.names, name)
+}
+
+// pick maps a mulberry32 draw in [0, 1) to an index below n.
+func pick(r float64, n int) int {
+	return min(int(math.Floor(r*float64(n))), n-1)
+}
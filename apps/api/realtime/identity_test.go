@@ -0,0 +1,89 @@
+package realtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestIdentities_DeterministicForSeedAndUser(t *testing.T) {
+	a := newIdentities(42).assign("user-1")
+	b := newIdentities(42).assign("user-1")
+
+	if a != b {
+		t.Fatalf("expected the same identity, got %+v and %+v", a, b)
+	}
+	if a.Name == "" || !slices.Contains(identityColors, a.Color) {
+		t.Fatalf("unexpected identity %+v", a)
+	}
+}
+
+func TestIdentities_NamesAreUniqueInRoom(t *testing.T) {
+	ids := newIdentities(7)
+
+	// More users than there are adjective/animal pairs forces numbered names.
+	seen := make(map[string]bool)
+	for i := range len(identityAdjectives)*len(identityAnimals) + 100 {
+		identity := ids.assign(fmt.Sprintf("user-%d", i))
+		if seen[identity.Name] {
+			t.Fatalf("duplicate name %q", identity.Name)
+		}
+		seen[identity.Name] = true
+	}
+}
+
+func TestIdentities_ReleaseFreesName(t *testing.T) {
+	ids := newIdentities(7)
+
+	first := ids.assign("user-1")
+	ids.release(first.Name)
+
+	if again := ids.assign("user-1"); again != first {
+		t.Fatalf("expected released identity %+v to be reassigned, got %+v", first, again)
+	}
+}
+
+func TestClient_handleMessage_helloAcksIdentity(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+
+	c := newClient(h)
+	h.Register(c)
+	drainChannel(c.send)
+
+	c.handleMessage([]byte(`{"type":"hello"}`))
+
+	raw := readWithTimeout(c.send, 200*time.Millisecond)
+	if raw == nil {
+		t.Fatalf("expected hello_ack")
+	}
+
+	var ack HelloAckMessage
+	if err := json.Unmarshal(raw, &ack); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	identity := c.Identity()
+	if ack.Type != "hello_ack" || ack.ID != c.userID || ack.Name != identity.Name || ack.Color != identity.Color || ack.Name == "" {
+		t.Fatalf("unexpected hello_ack %+v for identity %+v", ack, identity)
+	}
+}
+
+func TestHub_PresenceIncludesIdentities(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+
+	a := newTestClient(h, "a", 10)
+	b := newTestClient(h, "b", 10)
+	h.Register(a)
+	h.Register(b)
+
+	p := lastPresence(t, a)
+	if len(p.Users) != 1 || p.Users[0].Name == "" || p.Users[0].Color == "" {
+		t.Fatalf("expected b with a name and color, got %+v", p.Users)
+	}
+	if p.Self == nil || p.Self.Name == "" || p.Self.Name == p.Users[0].Name {
+		t.Fatalf("expected a distinct self identity, got %+v", p.Self)
+	}
+}
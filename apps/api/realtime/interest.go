@@ -0,0 +1,184 @@
+package realtime
+
+import (
+	"math"
+	"sync"
+)
+
+const (
+	// interestCellSize is the edge of a grid cell in canvas pixels, roughly one
+	// small screen, so a typical viewport touches a handful of cells.
+	interestCellSize = 512
+
+	// maxInterestCells caps how many cells one viewport is indexed under. Wider
+	// viewports (zoomed far out) are treated as seeing the whole canvas.
+	maxInterestCells = 256
+)
+
+type gridCell struct {
+	x, y int
+}
+
+// interestGrid is a uniform-grid spatial index of client viewports. The hub
+// queries it with an author's bubble to find the clients that can see it.
+//
+// Each indexed client holds a small slot number, kept in the client's
+// interestSlot, so a query can hand the shards a bitset of slots rather than
+// a set of clients.
+type interestGrid struct {
+	mu        sync.RWMutex
+	cells     map[gridCell]map[*Client]struct{}
+	wide      map[*Client]struct{}
+	viewports map[*Client]Rect
+	slots     int
+	freeSlots []int32
+}
+
+func newInterestGrid() *interestGrid {
+	return &interestGrid{
+		cells:     make(map[gridCell]map[*Client]struct{}),
+		wide:      make(map[*Client]struct{}),
+		viewports: make(map[*Client]Rect),
+	}
+}
+
+// update records c's viewport, replacing any previous one.
+func (g *interestGrid) update(c *Client, viewport Rect) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// The hub closes a client before dropping it from the grid, so a late
+	// update from a departing client can't re-add it.
+	if c.isClosed() {
+		return
+	}
+
+	if _, ok := g.viewports[c]; ok {
+		g.unindexLocked(c)
+	} else {
+		c.interestSlot.Store(g.takeSlotLocked() + 1)
+	}
+	g.viewports[c] = viewport
+
+	minCell, maxCell := cellRange(viewport)
+	if (maxCell.x-minCell.x+1)*(maxCell.y-minCell.y+1) > maxInterestCells {
+		g.wide[c] = struct{}{}
+		return
+	}
+
+	for y := minCell.y; y <= maxCell.y; y++ {
+		for x := minCell.x; x <= maxCell.x; x++ {
+			cell := gridCell{x: x, y: y}
+			members, ok := g.cells[cell]
+			if !ok {
+				members = make(map[*Client]struct{})
+				g.cells[cell] = members
+			}
+			members[c] = struct{}{}
+		}
+	}
+}
+
+func (g *interestGrid) remove(c *Client) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removeLocked(c)
+}
+
+func (g *interestGrid) removeLocked(c *Client) {
+	if _, ok := g.viewports[c]; !ok {
+		return
+	}
+	g.unindexLocked(c)
+	delete(g.viewports, c)
+
+	g.freeSlots = append(g.freeSlots, c.interestSlot.Swap(0)-1)
+}
+
+// unindexLocked drops c from the cells its viewport covers, keeping its slot.
+func (g *interestGrid) unindexLocked(c *Client) {
+	viewport := g.viewports[c]
+	if _, ok := g.wide[c]; ok {
+		delete(g.wide, c)
+		return
+	}
+
+	minCell, maxCell := cellRange(viewport)
+	for y := minCell.y; y <= maxCell.y; y++ {
+		for x := minCell.x; x <= maxCell.x; x++ {
+			cell := gridCell{x: x, y: y}
+			delete(g.cells[cell], c)
+			if len(g.cells[cell]) == 0 {
+				delete(g.cells, cell)
+			}
+		}
+	}
+}
+
+func (g *interestGrid) takeSlotLocked() int32 {
+	if n := len(g.freeSlots); n > 0 {
+		slot := g.freeSlots[n-1]
+		g.freeSlots = g.freeSlots[:n-1]
+		return slot
+	}
+	g.slots++
+	return int32(g.slots - 1)
+}
+
+// visibleTo returns a delivery filter for a message shown in area. Clients
+// that never reported a viewport keep receiving everything. The visible
+// clients are worked out once, under one lock, so the shards can run the
+// filter without touching the grid.
+func (g *interestGrid) visibleTo(area Rect) func(*Client) bool {
+	g.mu.RLock()
+	visible := make(slotSet, (g.slots+63)/64)
+	for c := range g.wide {
+		visible.add(c.interestSlot.Load() - 1)
+	}
+
+	minCell, maxCell := cellRange(area)
+	for y := minCell.y; y <= maxCell.y; y++ {
+		for x := minCell.x; x <= maxCell.x; x++ {
+			for c := range g.cells[gridCell{x: x, y: y}] {
+				if g.viewports[c].Intersects(area) {
+					visible.add(c.interestSlot.Load() - 1)
+				}
+			}
+		}
+	}
+	g.mu.RUnlock()
+
+	return func(c *Client) bool {
+		slot := c.interestSlot.Load()
+		return slot == 0 || visible.has(slot-1)
+	}
+}
+
+// slotSet is a bitset of interest grid slots.
+type slotSet []uint64
+
+func (s slotSet) add(slot int32) {
+	s[slot/64] |= 1 << (slot % 64)
+}
+
+// has reports whether slot is in s. Slots handed out after s was built are
+// not.
+func (s slotSet) has(slot int32) bool {
+	i := int(slot / 64)
+	return i < len(s) && s[i]&(1<<(slot%64)) != 0
+}
+
+func cellRange(r Rect) (gridCell, gridCell) {
+	return cellRangeSized(r, interestCellSize)
+}
+
+// cellRangeSized returns the first and last grid cells r touches.
+func cellRangeSized(r Rect, size float64) (gridCell, gridCell) {
+	return gridCell{
+		x: int(math.Floor(r.X / size)),
+		y: int(math.Floor(r.Y / size)),
+	}, gridCell{
+		x: int(math.Floor((r.X + r.Width) / size)),
+		y: int(math.Floor((r.Y + r.Height) / size)),
+	}
+}
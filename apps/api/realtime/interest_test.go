@@ -0,0 +1,84 @@
+package realtime
+
+import "testing"
+
+func TestInterestGrid_FiltersByViewport(t *testing.T) {
+	g := newInterestGrid()
+
+	near := &Client{userID: "near"}
+	far := &Client{userID: "far"}
+	unknown := &Client{userID: "unknown"}
+
+	g.update(near, Rect{X: 0, Y: 0, Width: 800, Height: 600})
+	g.update(far, Rect{X: 5000, Y: 5000, Width: 800, Height: 600})
+
+	visible := g.visibleTo(Rect{X: 100, Y: 100, Width: bubbleWidth, Height: bubbleHeight})
+
+	if !visible(near) {
+		t.Fatal("expected client whose viewport covers the bubble to see it")
+	}
+	if visible(far) {
+		t.Fatal("expected client looking elsewhere not to see the bubble")
+	}
+	if !visible(unknown) {
+		t.Fatal("expected client without a viewport to see everything")
+	}
+}
+
+func TestInterestGrid_UpdateMovesViewport(t *testing.T) {
+	g := newInterestGrid()
+	c := &Client{userID: "panner"}
+	bubble := Rect{X: 100, Y: 100, Width: bubbleWidth, Height: bubbleHeight}
+
+	g.update(c, Rect{X: 0, Y: 0, Width: 800, Height: 600})
+	g.update(c, Rect{X: 3000, Y: 0, Width: 800, Height: 600})
+
+	if g.visibleTo(bubble)(c) {
+		t.Fatal("expected old viewport to be forgotten after panning away")
+	}
+	if len(g.cells) != 6 {
+		t.Fatalf("expected only the new viewport's cells to be indexed, got %d", len(g.cells))
+	}
+}
+
+func TestInterestGrid_WideViewportSeesEverything(t *testing.T) {
+	g := newInterestGrid()
+	c := &Client{userID: "zoomed-out"}
+
+	g.update(c, Rect{X: -100000, Y: -100000, Width: 200000, Height: 200000})
+
+	if !g.visibleTo(Rect{X: 90000, Y: -90000, Width: bubbleWidth, Height: bubbleHeight})(c) {
+		t.Fatal("expected very wide viewport to see any bubble")
+	}
+	if len(g.cells) != 0 {
+		t.Fatalf("expected wide viewport not to be indexed cell by cell, got %d cells", len(g.cells))
+	}
+}
+
+func TestInterestGrid_RemoveAndClosedClients(t *testing.T) {
+	g := newInterestGrid()
+	c := &Client{userID: "leaving", send: make(chan []byte)}
+
+	g.update(c, Rect{X: 0, Y: 0, Width: 800, Height: 600})
+	g.remove(c)
+
+	if len(g.cells) != 0 || len(g.viewports) != 0 {
+		t.Fatalf("expected grid to be empty after remove")
+	}
+
+	other := &Client{userID: "arriving"}
+	g.update(other, Rect{X: 5000, Y: 5000, Width: 800, Height: 600})
+	if c.interestSlot.Load() != 0 || other.interestSlot.Load() != 1 {
+		t.Fatalf("expected the removed client's slot to be handed on, got %d and %d", c.interestSlot.Load(), other.interestSlot.Load())
+	}
+	if g.visibleTo(Rect{X: 100, Y: 100, Width: bubbleWidth, Height: bubbleHeight})(other) {
+		t.Fatal("expected the new holder of a slot to be filtered by its own viewport")
+	}
+	g.remove(other)
+
+	c.closeSend()
+	g.update(c, Rect{X: 0, Y: 0, Width: 800, Height: 600})
+	if len(g.viewports) != 0 {
+		t.Fatal("expected closed client not to be indexed")
+	}
+}
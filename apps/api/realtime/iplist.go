@@ -0,0 +1,255 @@
+package realtime
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IPAction is what an IP list rule does with the addresses it matches.
+type IPAction string
+
+const (
+	IPAllow IPAction = "allow"
+	IPDeny  IPAction = "deny"
+)
+
+// IPRule is one line of an IP list as the admin API reports it.
+type IPRule struct {
+	Action IPAction     `json:"action"`
+	Prefix netip.Prefix `json:"prefix"`
+	Hits   uint64       `json:"hits"`
+}
+
+type ipRule struct {
+	action IPAction
+	prefix netip.Prefix
+	hits   atomic.Uint64
+}
+
+// IPListStatus describes the loaded IP list.
+type IPListStatus struct {
+	Path     string    `json:"path,omitempty"`
+	LoadedAt time.Time `json:"loadedAt,omitzero"`
+	Rules    []IPRule  `json:"rules"`
+}
+
+// IPList allows or denies connections by address range. The most specific
+// range containing an address decides, with deny winning a tie, so an allow
+// rule can open a hole in a denied block and "deny 0.0.0.0/0" with
+// "deny ::/0" turns the allow rules into an allowlist. A range covers one
+// family only, so denying just 0.0.0.0/0 still lets every IPv6 address in.
+// Addresses no rule matches are allowed.
+//
+// A list loaded from a file is reloaded when the file changes, keeping each
+// rule's hit count across reloads.
+type IPList struct {
+	path string
+
+	mu       sync.RWMutex
+	rules    []*ipRule
+	modTime  time.Time
+	size     int64
+	loadedAt time.Time
+}
+
+// NewIPList returns an empty list that allows everyone.
+func NewIPList() *IPList {
+	return &IPList{}
+}
+
+// LoadIPList reads the rules in path, one per line as "allow <range>" or
+// "deny <range>", where a range is a CIDR prefix or a single address. Blank
+// lines and lines starting with # are skipped.
+func LoadIPList(path string) (*IPList, error) {
+	l := &IPList{path: path}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload rereads the list's file. On error the current rules stay in force.
+func (l *IPList) Reload() error {
+	if l.path == "" {
+		return nil
+	}
+
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return err
+	}
+	rules, err := parseIPRules(l.path, data)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, rule := range rules {
+		if old := l.findLocked(rule.action, rule.prefix); old != nil {
+			rule.hits.Store(old.hits.Load())
+		}
+	}
+	l.rules = rules
+	l.modTime = info.ModTime()
+	l.size = info.Size()
+	l.loadedAt = time.Now()
+	return nil
+}
+
+// Watch reloads the list whenever its file changes, checking every interval.
+func (l *IPList) Watch(interval time.Duration) {
+	if l.path == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		changed, err := l.reloadIfChanged()
+		if err != nil {
+			log.Printf("Error reloading IP list %s: %v", l.path, err)
+		} else if changed {
+			log.Printf("Reloaded IP list %s", l.path)
+		}
+	}
+}
+
+// reloadIfChanged reloads the list if its file's modification time or size
+// differ from when it was last loaded.
+func (l *IPList) reloadIfChanged() (bool, error) {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return false, err
+	}
+
+	l.mu.RLock()
+	unchanged := info.ModTime().Equal(l.modTime) && info.Size() == l.size
+	l.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	return true, l.Reload()
+}
+
+// Allowed reports whether connections from ip may proceed, counting a hit on
+// the rule that decided. An address that doesn't parse is let through, since
+// no rule can be said to match it, and logged.
+func (l *IPList) Allowed(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		log.Printf("Allowing unparseable address %q past the IP list: %v", ip, err)
+		return true
+	}
+	addr = addr.Unmap()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, rule := range l.rules {
+		if rule.prefix.Contains(addr) {
+			rule.hits.Add(1)
+			return rule.action == IPAllow
+		}
+	}
+	return true
+}
+
+// Status returns the list's source and rules with their hit counts.
+func (l *IPList) Status() IPListStatus {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	status := IPListStatus{
+		Path:     l.path,
+		LoadedAt: l.loadedAt,
+		Rules:    make([]IPRule, len(l.rules)),
+	}
+	for i, rule := range l.rules {
+		status.Rules[i] = IPRule{Action: rule.action, Prefix: rule.prefix, Hits: rule.hits.Load()}
+	}
+	return status
+}
+
+// Handler refuses requests from denied addresses before they reach next.
+func (l *IPList) Handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ip := PeerFromRequest(r).IP(); !l.Allowed(ip) {
+			log.Printf("Refused connection from denied address %s", ip)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func (l *IPList) findLocked(action IPAction, prefix netip.Prefix) *ipRule {
+	for _, rule := range l.rules {
+		if rule.action == action && rule.prefix == prefix {
+			return rule
+		}
+	}
+	return nil
+}
+
+// parseIPRules parses an IP list file, returning its rules most specific
+// first.
+func parseIPRules(path string, data []byte) ([]*ipRule, error) {
+	var rules []*ipRule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want \"allow <range>\" or \"deny <range>\"", path, n)
+		}
+		action := IPAction(strings.ToLower(fields[0]))
+		if action != IPAllow && action != IPDeny {
+			return nil, fmt.Errorf("%s:%d: unknown action %q", path, n, fields[0])
+		}
+		prefixes, err := ParsePrefixes(fields[1])
+		if err == nil && len(prefixes) != 1 {
+			err = fmt.Errorf("%q is not one address or CIDR range", fields[1])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+
+		rules = append(rules, &ipRule{action: action, prefix: prefixes[0]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+
+	slices.SortStableFunc(rules, func(a, b *ipRule) int {
+		if c := cmp.Compare(b.prefix.Bits(), a.prefix.Bits()); c != 0 {
+			return c
+		}
+		// Deny sorts before allow.
+		return strings.Compare(string(b.action), string(a.action))
+	})
+	return rules, nil
+}
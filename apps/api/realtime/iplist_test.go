@@ -0,0 +1,164 @@
+package realtime
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeIPList(t *testing.T, path, rules string, modTime time.Time) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+		t.Fatalf("write IP list: %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("set IP list mtime: %v", err)
+	}
+}
+
+func TestIPList_MostSpecificRuleDecides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iplist")
+	writeIPList(t, path, `
+# Block the whole range but the office.
+deny  203.0.113.0/24
+allow 203.0.113.64/26
+deny  198.51.100.7
+allow 198.51.100.7
+`, time.Now())
+
+	l, err := LoadIPList(path)
+	if err != nil {
+		t.Fatalf("load IP list: %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"203.0.113.7", false},
+		{"203.0.113.70", true},
+		{"198.51.100.7", false}, // deny wins a tie
+		{"10.0.0.1", true},
+		{"not an address", true},
+	}
+	for _, tt := range tests {
+		if got := l.Allowed(tt.ip); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	hits := make(map[string]uint64)
+	for _, rule := range l.Status().Rules {
+		hits[string(rule.Action)+" "+rule.Prefix.String()] = rule.Hits
+	}
+	if hits["deny 203.0.113.0/24"] != 1 || hits["allow 203.0.113.64/26"] != 1 || hits["deny 198.51.100.7/32"] != 1 {
+		t.Fatalf("hits = %v, want one on each rule that decided", hits)
+	}
+}
+
+func TestIPList_ReloadsChangedFileAndKeepsHits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iplist")
+	start := time.Now().Add(-time.Hour)
+	writeIPList(t, path, "deny 203.0.113.0/24\n", start)
+
+	l, err := LoadIPList(path)
+	if err != nil {
+		t.Fatalf("load IP list: %v", err)
+	}
+	l.Allowed("203.0.113.7")
+
+	if changed, err := l.reloadIfChanged(); changed || err != nil {
+		t.Fatalf("reloadIfChanged on an untouched file = %v, %v", changed, err)
+	}
+
+	writeIPList(t, path, "deny 203.0.113.0/24\ndeny 198.51.100.0/24\n", start.Add(time.Minute))
+	if changed, err := l.reloadIfChanged(); !changed || err != nil {
+		t.Fatalf("reloadIfChanged after an edit = %v, %v", changed, err)
+	}
+	if l.Allowed("198.51.100.1") {
+		t.Fatal("expected the new rule to apply after a reload")
+	}
+	if rules := l.Status().Rules; rules[0].Hits != 1 || rules[1].Hits != 1 {
+		t.Fatalf("rules = %+v, want the old rule's hit kept", rules)
+	}
+
+	// A broken file leaves the last good rules in force.
+	writeIPList(t, path, "deny everyone\n", start.Add(2*time.Minute))
+	if _, err := l.reloadIfChanged(); err == nil {
+		t.Fatal("expected an error for a bad rule")
+	}
+	if l.Allowed("198.51.100.1") {
+		t.Fatal("expected the previous rules to stay in force")
+	}
+}
+
+func TestLoadIPList_RejectsBadRules(t *testing.T) {
+	for _, rules := range []string{"block 10.0.0.0/8", "deny", "deny 10.0.0.0/8 now", "allow 10.0.0.0/8,10.1.0.0/16"} {
+		path := filepath.Join(t.TempDir(), "iplist")
+		writeIPList(t, path, rules, time.Now())
+
+		if _, err := LoadIPList(path); err == nil {
+			t.Errorf("LoadIPList(%q) succeeded, want an error", rules)
+		}
+	}
+}
+
+func TestIPList_HandlerRefusesDeniedAddresses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iplist")
+	writeIPList(t, path, "deny 203.0.113.0/24\n", time.Now())
+	l, err := LoadIPList(path)
+	if err != nil {
+		t.Fatalf("load IP list: %v", err)
+	}
+
+	var reached int
+	handler := l.Handler(func(w http.ResponseWriter, r *http.Request) { reached++ })
+
+	for _, addr := range []string{"203.0.113.7:1000", "198.51.100.1:1000"} {
+		req := httptest.NewRequest(http.MethodGet, "/connect", nil)
+		req.RemoteAddr = addr
+		handler(httptest.NewRecorder(), req)
+	}
+	if reached != 1 {
+		t.Fatalf("next reached %d times, want only for the allowed address", reached)
+	}
+}
+
+func TestAdminServer_ShowsAndReloadsIPList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iplist")
+	writeIPList(t, path, "deny 203.0.113.0/24\n", time.Now())
+	l, err := LoadIPList(path)
+	if err != nil {
+		t.Fatalf("load IP list: %v", err)
+	}
+	s := NewAdminServer(testAdminToken, NewModeration(), l, map[string]*Hub{"default": NewHub()})
+	l.Allowed("203.0.113.7")
+
+	writeIPList(t, path, "deny 203.0.113.0/24\nallow 203.0.113.7\n", time.Now())
+	rec := adminRequest(t, s, http.MethodPost, "/admin/iplist/reload", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reload status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	rec = adminRequest(t, s, http.MethodGet, "/admin/iplist", "")
+	var status IPListStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to decode IP list: %v", err)
+	}
+	if status.Path != path || len(status.Rules) != 2 {
+		t.Fatalf("status = %+v, want both rules from %s", status, path)
+	}
+	if status.Rules[1].Action != IPDeny || status.Rules[1].Hits != 1 {
+		t.Fatalf("rules = %+v, want the deny rule's hit", status.Rules)
+	}
+
+	writeIPList(t, path, "deny nowhere\n", time.Now())
+	if rec := adminRequest(t, s, http.MethodPost, "/admin/iplist/reload", ""); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("bad reload status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+}
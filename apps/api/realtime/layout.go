@@ -0,0 +1,304 @@
+package realtime
+
+import (
+	"math"
+	"sort"
+)
+
+// Layout tunables, ported from apps/web/lib/spatial.ts.
+const (
+	layoutGap           = 32
+	maxRandomCandidates = 160
+	layoutGridStep      = 48
+
+	// maxGridCandidates widens the grid step on large bounds so a grown
+	// layout doesn't sweep an ever finer grid. The default canvas stays
+	// well under it and keeps the web client's 48px step.
+	maxGridCandidates = 1024
+
+	// maxLayoutGrowth bounds how many times a growable layout doubles for a
+	// single placement before settling for the least crowded spot.
+	maxLayoutGrowth = 16
+
+	// layoutCellSize buckets placed bubbles so a candidate is only tested
+	// against its neighbours. It is larger than a bubble plus its gap.
+	layoutCellSize = 256
+)
+
+type Size struct {
+	Width, Height float64
+}
+
+// layout assigns every user in a room a collision-free spot on a shared
+// canvas. It ports the placement half of SpatialIndex.layoutUsers from the web
+// client, run once on the server so all clients render the same map. Positions
+// are sticky: a user keeps their spot until they leave, and a newcomer is
+// placed around everyone already there.
+//
+// layout is owned by the hub loop and is not safe for concurrent use.
+type layout struct {
+	seed      uint32
+	bounds    Rect
+	itemSize  Size
+	gap       float64
+	positions map[string]Point
+	cells     map[gridCell]map[string]Rect
+
+	// full is set once no open spot was found and cleared when someone
+	// leaves, so a crowded room skips straight to the fallback.
+	full bool
+
+	// growable layouts double their bounds instead of overlapping bubbles
+	// when they run out of room, for canvases without edges.
+	growable bool
+}
+
+func newLayout(seed uint32) *layout {
+	return &layout{
+		seed: seed,
+		bounds: Rect{
+			X:      canvasPadding,
+			Y:      canvasPadding,
+			Width:  canvasWidth - canvasPadding*2,
+			Height: canvasHeight - canvasPadding*2,
+		},
+		itemSize:  Size{Width: bubbleWidth, Height: bubbleHeight},
+		gap:       layoutGap,
+		positions: make(map[string]Point),
+		cells:     make(map[gridCell]map[string]Rect),
+	}
+}
+
+// place returns userID's position, finding an open spot around everyone
+// already placed if the user is new.
+func (l *layout) place(userID string) Point {
+	if existing, ok := l.positions[userID]; ok {
+		return existing
+	}
+
+	point := l.findOpenPoint(userID)
+	l.set(userID, point)
+	return point
+}
+
+// set puts userID at p, wherever it was before. Unlike place it doesn't look
+// for room, so users who move themselves may overlap others.
+func (l *layout) set(userID string, p Point) {
+	l.release(userID)
+
+	l.positions[userID] = p
+	rect := rectFromPoint(p, l.itemSize)
+	l.forEachCell(rect, func(cell gridCell) {
+		members, ok := l.cells[cell]
+		if !ok {
+			members = make(map[string]Rect)
+			l.cells[cell] = members
+		}
+		members[userID] = rect
+	})
+}
+
+func (l *layout) release(userID string) {
+	point, ok := l.positions[userID]
+	if !ok {
+		return
+	}
+
+	delete(l.positions, userID)
+	l.full = false
+	l.forEachCell(rectFromPoint(point, l.itemSize), func(cell gridCell) {
+		delete(l.cells[cell], userID)
+		if len(l.cells[cell]) == 0 {
+			delete(l.cells, cell)
+		}
+	})
+}
+
+func (l *layout) findOpenPoint(userID string) Point {
+	seed := formatSeed(l.seed)
+
+	for range maxLayoutGrowth {
+		candidates := randomCandidates(seed+":"+userID+":layout", l.bounds, l.itemSize)
+
+		// The grid sweep only helps find a gap; once the room is full the
+		// random candidates are enough to pick a quiet spot.
+		if l.full {
+			return l.leastCrowdedCandidate(candidates)
+		}
+
+		candidates = append(candidates, gridCandidates(seed+":"+userID+":grid", l.bounds, l.itemSize)...)
+		if point, ok := l.mostCentralOpenCandidate(candidates); ok {
+			return point
+		}
+
+		if !l.growable {
+			l.full = true
+			return l.leastCrowdedCandidate(candidates)
+		}
+		l.grow()
+	}
+
+	return l.leastCrowdedCandidate(randomCandidates(seed+":"+userID+":layout", l.bounds, l.itemSize))
+}
+
+// grow doubles the bounds around their center.
+func (l *layout) grow() {
+	l.bounds = Rect{
+		X:      l.bounds.X - l.bounds.Width/2,
+		Y:      l.bounds.Y - l.bounds.Height/2,
+		Width:  l.bounds.Width * 2,
+		Height: l.bounds.Height * 2,
+	}
+}
+
+func (l *layout) mostCentralOpenCandidate(candidates []Point) (Point, bool) {
+	var best Point
+	found := false
+	bestScore := math.Inf(1)
+
+	for _, candidate := range candidates {
+		rect := rectFromPoint(candidate, l.itemSize)
+		if !fits(rect, l.bounds) || l.overlaps(rect) {
+			continue
+		}
+
+		if score := centerDistanceScore(rect, l.bounds); score < bestScore {
+			best, found, bestScore = candidate, true, score
+		}
+	}
+
+	return best, found
+}
+
+// leastCrowdedCandidate is the fallback once the canvas is full. Rather than
+// measuring exact overlap against every bubble, which gets quadratic in a busy
+// room, it counts the bubbles indexed around each candidate and prefers the
+// emptiest neighbourhood, then the most central one.
+func (l *layout) leastCrowdedCandidate(candidates []Point) Point {
+	best := Point{X: l.bounds.X, Y: l.bounds.Y}
+	if len(candidates) > 0 {
+		best = candidates[0]
+	}
+	bestCrowd := math.MaxInt
+	bestCenter := math.Inf(1)
+
+	for _, candidate := range candidates {
+		rect := rectFromPoint(candidate, l.itemSize)
+		if !fits(rect, l.bounds) {
+			continue
+		}
+
+		crowd := 0
+		l.forEachCell(inflate(rect, l.gap), func(cell gridCell) {
+			crowd += len(l.cells[cell])
+		})
+		center := centerDistanceScore(rect, l.bounds)
+
+		if crowd < bestCrowd || (crowd == bestCrowd && center < bestCenter) {
+			best, bestCrowd, bestCenter = candidate, crowd, center
+		}
+	}
+
+	return best
+}
+
+// overlaps reports whether r, inflated by the gap, touches a placed bubble.
+func (l *layout) overlaps(r Rect) bool {
+	inflated := inflate(r, l.gap)
+	found := false
+
+	l.forEachCell(inflated, func(cell gridCell) {
+		if found {
+			return
+		}
+		for _, placed := range l.cells[cell] {
+			if inflated.Intersects(placed) {
+				found = true
+				return
+			}
+		}
+	})
+
+	return found
+}
+
+func (l *layout) forEachCell(r Rect, fn func(gridCell)) {
+	minCell, maxCell := cellRangeSized(r, layoutCellSize)
+	for y := minCell.y; y <= maxCell.y; y++ {
+		for x := minCell.x; x <= maxCell.x; x++ {
+			fn(gridCell{x: x, y: y})
+		}
+	}
+}
+
+func rectFromPoint(p Point, size Size) Rect {
+	return Rect{X: p.X, Y: p.Y, Width: size.Width, Height: size.Height}
+}
+
+func fits(r, bounds Rect) bool {
+	return r.X >= bounds.X &&
+		r.Y >= bounds.Y &&
+		r.X+r.Width <= bounds.X+bounds.Width &&
+		r.Y+r.Height <= bounds.Y+bounds.Height
+}
+
+func inflate(r Rect, amount float64) Rect {
+	return Rect{
+		X:      r.X - amount,
+		Y:      r.Y - amount,
+		Width:  r.Width + amount*2,
+		Height: r.Height + amount*2,
+	}
+}
+
+func randomCandidates(seedInput string, bounds Rect, itemSize Size) []Point {
+	rand := mulberry32(hashString(seedInput))
+	maxX := math.Max(bounds.X, bounds.X+bounds.Width-itemSize.Width)
+	maxY := math.Max(bounds.Y, bounds.Y+bounds.Height-itemSize.Height)
+	usableW := math.Max(0, maxX-bounds.X)
+	usableH := math.Max(0, maxY-bounds.Y)
+
+	points := make([]Point, 0, maxRandomCandidates)
+	for range maxRandomCandidates {
+		points = append(points, Point{
+			X: bounds.X + rand()*usableW,
+			Y: bounds.Y + rand()*usableH,
+		})
+	}
+	return points
+}
+
+// gridCandidates walks the bounds in layoutGridStep increments and shuffles
+// the points with a seeded sort key, as the web client does. Bounds too large
+// for maxGridCandidates points at that step get a coarser grid.
+func gridCandidates(seedInput string, bounds Rect, itemSize Size) []Point {
+	type keyed struct {
+		point Point
+		sort  float64
+	}
+
+	maxX := bounds.X + bounds.Width - itemSize.Width
+	maxY := bounds.Y + bounds.Height - itemSize.Height
+	rand := mulberry32(hashString(seedInput))
+	step := math.Max(layoutGridStep, math.Sqrt((maxX-bounds.X)*(maxY-bounds.Y)/maxGridCandidates))
+
+	var points []keyed
+	for y := bounds.Y; y <= maxY; y += step {
+		for x := bounds.X; x <= maxX; x += step {
+			points = append(points, keyed{point: Point{X: x, Y: y}, sort: rand()})
+		}
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].sort < points[j].sort })
+
+	shuffled := make([]Point, len(points))
+	for i, p := range points {
+		shuffled[i] = p.point
+	}
+	return shuffled
+}
+
+func centerDistanceScore(r, bounds Rect) float64 {
+	dx := (r.X + r.Width/2) - (bounds.X + bounds.Width/2)
+	dy := (r.Y + r.Height/2) - (bounds.Y + bounds.Height/2)
+	return dx*dx + dy*dy
+}
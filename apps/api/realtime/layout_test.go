@@ -0,0 +1,84 @@
+package realtime
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLayout_PlacesUsersWithoutOverlap(t *testing.T) {
+	l := newLayout(42)
+
+	var rects []Rect
+	for i := range 8 {
+		p := l.place(fmt.Sprintf("user-%d", i))
+		r := rectFromPoint(p, l.itemSize)
+
+		if !fits(r, l.bounds) {
+			t.Fatalf("user-%d placed outside the canvas at %+v", i, p)
+		}
+		for j, other := range rects {
+			if inflate(r, layoutGap).Intersects(other) {
+				t.Fatalf("user-%d overlaps user-%d: %+v vs %+v", i, j, r, other)
+			}
+		}
+		rects = append(rects, r)
+	}
+}
+
+func TestLayout_PositionsAreStickyUntilReleased(t *testing.T) {
+	l := newLayout(7)
+
+	first := l.place("alice")
+	l.place("bob")
+	if again := l.place("alice"); again != first {
+		t.Fatalf("expected alice to keep %+v, got %+v", first, again)
+	}
+
+	l.release("alice")
+	if _, ok := l.positions["alice"]; ok {
+		t.Fatalf("expected alice to be released")
+	}
+	for cell, members := range l.cells {
+		if _, ok := members["alice"]; ok {
+			t.Fatalf("expected alice removed from cell %+v", cell)
+		}
+	}
+}
+
+func TestLayout_SameSeedGivesSamePositions(t *testing.T) {
+	a, b := newLayout(99), newLayout(99)
+
+	for i := range 5 {
+		id := fmt.Sprintf("user-%d", i)
+		if pa, pb := a.place(id), b.place(id); pa != pb {
+			t.Fatalf("%s: expected matching positions, got %+v and %+v", id, pa, pb)
+		}
+	}
+}
+
+func TestLayout_CrowdedRoomStaysInBounds(t *testing.T) {
+	l := newLayout(1)
+
+	for i := range 200 {
+		p := l.place(fmt.Sprintf("user-%d", i))
+		if !fits(rectFromPoint(p, l.itemSize), l.bounds) {
+			t.Fatalf("user-%d placed outside the canvas at %+v", i, p)
+		}
+	}
+}
+
+func TestLayout_GrowableLayoutNeverOverlaps(t *testing.T) {
+	l := newLayout(3)
+	l.growable = true
+
+	var rects []Rect
+	for i := range 60 {
+		r := rectFromPoint(l.place(string(rune('a'+i%26))+string(rune('A'+i/26))), l.itemSize)
+		for _, other := range rects {
+			if r.Intersects(other) {
+				t.Fatalf("placement %d overlaps: %+v vs %+v", i, r, other)
+			}
+		}
+		rects = append(rects, r)
+	}
+}
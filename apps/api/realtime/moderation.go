@@ -0,0 +1,365 @@
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SanctionKind is what a moderator did to a subject.
+type SanctionKind string
+
+const (
+	// SanctionMute drops the subject's typing and tells them why.
+	SanctionMute SanctionKind = "mute"
+	// SanctionShadowBan echoes the subject's typing back to them alone, so
+	// they don't notice nobody else sees it.
+	SanctionShadowBan SanctionKind = "shadow_ban"
+	// SanctionBan refuses the subject's connections outright.
+	SanctionBan SanctionKind = "ban"
+)
+
+// Sanction subjects are prefixed with what they identify: a connected user's
+// ID, a remote IP, or the identity key a client presents when connecting.
+const (
+	subjectUser     = "user:"
+	subjectIP       = "ip:"
+	subjectIdentity = "identity:"
+)
+
+var (
+	errMuted           = errors.New("you are muted")
+	errSanctionKind    = errors.New("kind must be mute, shadow_ban or ban")
+	errSanctionSubject = errors.New("subject must start with user:, ip: or identity:")
+)
+
+// Sanction is one moderation action. A zero ExpiresAt never expires.
+type Sanction struct {
+	Kind      SanctionKind `json:"kind"`
+	Subject   string       `json:"subject"`
+	Reason    string       `json:"reason,omitempty"`
+	CreatedAt time.Time    `json:"createdAt"`
+	ExpiresAt time.Time    `json:"expiresAt,omitzero"`
+}
+
+func (s Sanction) activeAt(now time.Time) bool {
+	return s.ExpiresAt.IsZero() || now.Before(s.ExpiresAt)
+}
+
+// persistent reports whether s outlives the process. User IDs are assigned
+// per connection and never come back after a restart, so only address and
+// identity sanctions are saved.
+func (s Sanction) persistent() bool {
+	return !strings.HasPrefix(s.Subject, subjectUser)
+}
+
+func (s Sanction) validate() error {
+	switch s.Kind {
+	case SanctionMute, SanctionShadowBan, SanctionBan:
+	default:
+		return errSanctionKind
+	}
+
+	for _, prefix := range []string{subjectUser, subjectIP, subjectIdentity} {
+		if value, ok := strings.CutPrefix(s.Subject, prefix); ok && value != "" {
+			return nil
+		}
+	}
+	return errSanctionSubject
+}
+
+type sanctionKey struct {
+	kind    SanctionKind
+	subject string
+}
+
+// Moderation holds the active sanctions, persisting them to a JSON file when
+// it has one so bans survive restarts.
+type Moderation struct {
+	path string
+	now  func() time.Time
+
+	mu        sync.RWMutex
+	sanctions map[sanctionKey]Sanction
+
+	// saveMu serializes writes of the file, which happen outside mu so
+	// checks never wait on the disk.
+	saveMu sync.Mutex
+}
+
+// NewModeration returns an empty, in-memory sanction list.
+func NewModeration() *Moderation {
+	return &Moderation{
+		now:       time.Now,
+		sanctions: make(map[sanctionKey]Sanction),
+	}
+}
+
+// LoadModeration reads the sanctions saved at path, which need not exist yet,
+// and saves every later change back to it.
+func LoadModeration(path string) (*Moderation, error) {
+	m := NewModeration()
+	m.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var saved []Sanction
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	now := m.now()
+	for _, s := range saved {
+		if s.validate() == nil && s.persistent() && s.activeAt(now) {
+			m.sanctions[sanctionKey{s.Kind, s.Subject}] = s
+		}
+	}
+	return m, nil
+}
+
+// Add records s, replacing any sanction of the same kind on the same subject.
+func (m *Moderation) Add(s Sanction) error {
+	saved, err := m.add(s)
+	if err != nil || !saved {
+		return err
+	}
+	return m.save()
+}
+
+// addInBackground records s like Add but saves it on another goroutine, for
+// callers such as the hub loop that mustn't wait on the disk. The sanction
+// applies at once either way.
+func (m *Moderation) addInBackground(s Sanction) error {
+	saved, err := m.add(s)
+	if err != nil || !saved {
+		return err
+	}
+	go func() {
+		if err := m.save(); err != nil {
+			log.Printf("Error saving %s on %s: %v", s.Kind, s.Subject, err)
+		}
+	}()
+	return nil
+}
+
+// add records s in memory, reporting whether it belongs in the file.
+func (m *Moderation) add(s Sanction) (bool, error) {
+	if err := s.validate(); err != nil {
+		return false, err
+	}
+	if s.CreatedAt.IsZero() {
+		s.CreatedAt = m.now()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sanctions[sanctionKey{s.Kind, s.Subject}] = s
+	return s.persistent(), nil
+}
+
+// Remove lifts the sanction of kind on subject, reporting whether there was one.
+func (m *Moderation) Remove(kind SanctionKind, subject string) (bool, error) {
+	m.mu.Lock()
+	key := sanctionKey{kind, subject}
+	removed, ok := m.sanctions[key]
+	delete(m.sanctions, key)
+	m.mu.Unlock()
+
+	if !ok || !removed.persistent() {
+		return ok, nil
+	}
+	return true, m.save()
+}
+
+// forget drops every sanction on a user subject. User sanctions end with the
+// connection, since a reconnecting client gets a new ID anyway, and they are
+// never saved, so the hub loop can call this on every disconnect without
+// touching the file.
+func (m *Moderation) forget(subject string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, kind := range []SanctionKind{SanctionMute, SanctionShadowBan, SanctionBan} {
+		delete(m.sanctions, sanctionKey{kind, subject})
+	}
+}
+
+// List returns the active sanctions, newest first.
+func (m *Moderation) List() []Sanction {
+	now := m.now()
+
+	m.mu.RLock()
+	list := make([]Sanction, 0, len(m.sanctions))
+	for _, s := range m.sanctions {
+		if s.activeAt(now) {
+			list = append(list, s)
+		}
+	}
+	m.mu.RUnlock()
+
+	slices.SortFunc(list, func(a, b Sanction) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Subject, b.Subject)
+	})
+	return list
+}
+
+// Check reports the active sanction of kind on any of subjects.
+func (m *Moderation) Check(kind SanctionKind, subjects ...string) (Sanction, bool) {
+	now := m.now()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, subject := range subjects {
+		if s, ok := m.sanctions[sanctionKey{kind, subject}]; ok && s.activeAt(now) {
+			return s, true
+		}
+	}
+	return Sanction{}, false
+}
+
+// Banned reports whether a connection from peer should be refused.
+func (m *Moderation) Banned(peer Peer) (Sanction, bool) {
+	return m.Check(SanctionBan, peer.subjects()...)
+}
+
+// save rewrites the sanction file, dropping expired sanctions. It writes a
+// temporary file and renames it so a crash never leaves a torn list behind.
+// Each save writes the list as it stands when the save starts, so the last
+// of several concurrent saves leaves the latest list on disk.
+func (m *Moderation) save() error {
+	if m.path == "" {
+		return nil
+	}
+
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+
+	now := m.now()
+	m.mu.Lock()
+	saved := make([]Sanction, 0, len(m.sanctions))
+	for key, s := range m.sanctions {
+		if !s.activeAt(now) {
+			delete(m.sanctions, key)
+			continue
+		}
+		if s.persistent() {
+			saved = append(saved, s)
+		}
+	}
+	m.mu.Unlock()
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), filepath.Base(m.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
+
+// Peer describes where a connection comes from.
+type Peer struct {
+	// RemoteAddr is the transport's peer address, usually host:port.
+	RemoteAddr string
+	// ClientIP is the client's address as resolved through trusted proxies.
+	// Empty means RemoteAddr's host.
+	ClientIP string
+	// IdentityKey is an optional stable key the client presents with the
+	// identity query parameter, so moderators can ban a browser rather than
+	// an address.
+	IdentityKey string
+
+	// ticket is the connection's admission, given back when its client is
+	// removed.
+	ticket *admissionTicket
+}
+
+// PeerFromRequest describes the client behind an upgrade or stream request.
+func PeerFromRequest(r *http.Request) Peer {
+	return Peer{
+		RemoteAddr:  r.RemoteAddr,
+		ClientIP:    clientIPFromContext(r.Context()),
+		IdentityKey: strings.TrimSpace(r.URL.Query().Get("identity")),
+		ticket:      ticketFromContext(r.Context()),
+	}
+}
+
+// IP is the client's address: ClientIP when it was resolved, or else the host
+// part of RemoteAddr.
+func (p Peer) IP() string {
+	if p.ClientIP != "" {
+		return p.ClientIP
+	}
+	return hostOf(p.RemoteAddr)
+}
+
+func (p Peer) subjects() []string {
+	subjects := make([]string, 0, 2)
+	if ip := p.IP(); ip != "" {
+		subjects = append(subjects, subjectIP+ip)
+	}
+	if p.IdentityKey != "" {
+		subjects = append(subjects, subjectIdentity+p.IdentityKey)
+	}
+	return subjects
+}
+
+// sanctionSubject picks the one subject to hold against p when it is cut off
+// or muted automatically: its identity key when it has one, so people sharing
+// its address aren't caught too, or else its address unless that is exempt.
+func (p Peer) sanctionSubject(exempt []netip.Prefix) (string, bool) {
+	if p.IdentityKey != "" {
+		return subjectIdentity + p.IdentityKey, true
+	}
+	if ip := p.IP(); ip != "" && !exemptIP(ip, exempt) {
+		return subjectIP + ip, true
+	}
+	return "", false
+}
+
+// exemptIP reports whether ip falls in one of the exempt ranges.
+func exemptIP(ip string, exempt []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range exempt {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
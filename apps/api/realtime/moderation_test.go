@@ -0,0 +1,82 @@
+package realtime
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestModeration_PersistsSanctionsAcrossLoads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "moderation.json")
+
+	m, err := LoadModeration(path)
+	if err != nil {
+		t.Fatalf("load empty moderation: %v", err)
+	}
+	if err := m.Add(Sanction{Kind: SanctionBan, Subject: "ip:203.0.113.7", Reason: "spam"}); err != nil {
+		t.Fatalf("add ban: %v", err)
+	}
+	if err := m.Add(Sanction{Kind: SanctionBan, Subject: "identity:old", ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("add expired ban: %v", err)
+	}
+	// Connection IDs never come back, so their sanctions aren't saved.
+	if err := m.Add(Sanction{Kind: SanctionMute, Subject: "user:gone"}); err != nil {
+		t.Fatalf("add user mute: %v", err)
+	}
+
+	reloaded, err := LoadModeration(path)
+	if err != nil {
+		t.Fatalf("reload moderation: %v", err)
+	}
+
+	got := reloaded.List()
+	if len(got) != 1 || got[0].Subject != "ip:203.0.113.7" || got[0].Reason != "spam" {
+		t.Fatalf("expected only the active address ban to survive, got %+v", got)
+	}
+	if _, ok := reloaded.Banned(Peer{RemoteAddr: "203.0.113.7:443"}); !ok {
+		t.Fatal("expected the reloaded ban to apply")
+	}
+
+	if removed, err := reloaded.Remove(SanctionBan, "ip:203.0.113.7"); err != nil || !removed {
+		t.Fatalf("remove ban: removed=%v err=%v", removed, err)
+	}
+	reloaded, err = LoadModeration(path)
+	if err != nil {
+		t.Fatalf("reload moderation: %v", err)
+	}
+	if got := reloaded.List(); len(got) != 0 {
+		t.Fatalf("expected no sanctions after removal, got %+v", got)
+	}
+}
+
+func TestModeration_SanctionsExpire(t *testing.T) {
+	m := NewModeration()
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	if err := m.Add(Sanction{Kind: SanctionMute, Subject: "user:u1", ExpiresAt: now.Add(time.Minute)}); err != nil {
+		t.Fatalf("add mute: %v", err)
+	}
+	if _, ok := m.Check(SanctionMute, "user:u1"); !ok {
+		t.Fatal("expected the mute to apply")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := m.Check(SanctionMute, "user:u1"); ok {
+		t.Fatal("expected the mute to have expired")
+	}
+}
+
+func TestModeration_RejectsUnknownKindsAndSubjects(t *testing.T) {
+	m := NewModeration()
+
+	for _, s := range []Sanction{
+		{Kind: "kick", Subject: "user:u1"},
+		{Kind: SanctionBan, Subject: "u1"},
+		{Kind: SanctionBan, Subject: "ip:"},
+	} {
+		if err := m.Add(s); err == nil {
+			t.Errorf("expected %+v to be rejected", s)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package realtime
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrPipeClosed = errors.New("realtime: pipe closed")
+	ErrReadLimit  = errors.New("realtime: message exceeds read limit")
+)
+
+const pipeBufferFrames = 64
+
+type pipeFrameKind int
+
+const (
+	pipeData pipeFrameKind = iota
+	pipePing
+	pipePong
+	pipeClose
+)
+
+type pipeFrame struct {
+	kind pipeFrameKind
+	data []byte
+}
+
+// pipeConn is one end of an in-memory Conn pair. Pings are answered by the
+// peer's read loop and surface as pongs on the pinging side, mirroring how a
+// WebSocket peer behaves.
+type pipeConn struct {
+	in        chan pipeFrame
+	peer      *pipeConn
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readLimit     int64
+	pongHandler   func()
+}
+
+// NewPipe returns two connected in-memory Conns, so clients can be pumped in
+// tests without a network listener.
+func NewPipe() (Conn, Conn) {
+	a := newPipeConn()
+	b := newPipeConn()
+	a.peer = b
+	b.peer = a
+
+	return a, b
+}
+
+func newPipeConn() *pipeConn {
+	return &pipeConn{
+		in:   make(chan pipeFrame, pipeBufferFrames),
+		done: make(chan struct{}),
+	}
+}
+
+func (c *pipeConn) ReadMessage() ([]byte, error) {
+	for {
+		c.mu.Lock()
+		deadline, limit := c.readDeadline, c.readLimit
+		c.mu.Unlock()
+
+		timeout, stop := deadlineTimer(deadline)
+
+		var frame pipeFrame
+		select {
+		case frame = <-c.in:
+		case <-c.done:
+			stop()
+			return nil, ErrPipeClosed
+		case <-c.peer.done:
+			// Frames queued before the peer went away are still delivered.
+			select {
+			case frame = <-c.in:
+			default:
+				stop()
+				return nil, io.EOF
+			}
+		case <-timeout:
+			return nil, os.ErrDeadlineExceeded
+		}
+		stop()
+
+		switch frame.kind {
+		case pipeData:
+			if limit > 0 && int64(len(frame.data)) > limit {
+				return nil, ErrReadLimit
+			}
+			return frame.data, nil
+
+		case pipePing:
+			c.write(pipeFrame{kind: pipePong})
+
+		case pipePong:
+			c.mu.Lock()
+			h := c.pongHandler
+			c.mu.Unlock()
+			if h != nil {
+				h()
+			}
+
+		case pipeClose:
+			return nil, io.EOF
+		}
+	}
+}
+
+func (c *pipeConn) WriteMessage(data []byte) error {
+	return c.write(pipeFrame{kind: pipeData, data: append([]byte(nil), data...)})
+}
+
+func (c *pipeConn) WritePing() error {
+	return c.write(pipeFrame{kind: pipePing})
+}
+
+func (c *pipeConn) WriteClose() error {
+	return c.write(pipeFrame{kind: pipeClose})
+}
+
+func (c *pipeConn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return nil
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	return nil
+}
+
+func (c *pipeConn) SetReadLimit(limit int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readLimit = limit
+}
+
+func (c *pipeConn) SetPongHandler(h func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pongHandler = h
+}
+
+func (c *pipeConn) write(frame pipeFrame) error {
+	select {
+	case <-c.done:
+		return ErrPipeClosed
+	case <-c.peer.done:
+		return ErrPipeClosed
+	default:
+	}
+
+	c.mu.Lock()
+	deadline := c.writeDeadline
+	c.mu.Unlock()
+
+	timeout, stop := deadlineTimer(deadline)
+	defer stop()
+
+	select {
+	case c.peer.in <- frame:
+		return nil
+	case <-c.done:
+		return ErrPipeClosed
+	case <-c.peer.done:
+		return ErrPipeClosed
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// deadlineTimer returns a channel that fires at deadline, or nil (never fires)
+// for the zero time.
+func deadlineTimer(deadline time.Time) (<-chan time.Time, func()) {
+	if deadline.IsZero() {
+		return nil, func() {}
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	return timer.C, func() { timer.Stop() }
+}
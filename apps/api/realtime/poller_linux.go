@@ -0,0 +1,116 @@
+//go:build linux
+
+package realtime
+
+import (
+	"errors"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// epollEvents arms a descriptor for a single readiness notification. The
+// reactor re-arms it once the frame has been read, so only one worker ever
+// reads from a connection at a time.
+const epollEvents = unix.EPOLLIN | unix.EPOLLRDHUP | unix.EPOLLONESHOT
+
+// epollWaitMillis bounds each wait so Close is noticed promptly.
+const epollWaitMillis = 100
+
+type epoll struct {
+	fd int
+
+	mu        sync.RWMutex
+	callbacks map[int]func()
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newPoller() (poller, error) {
+	fd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &epoll{
+		fd:        fd,
+		callbacks: make(map[int]func()),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go p.wait()
+
+	return p, nil
+}
+
+func (p *epoll) Add(fd int, onReady func()) error {
+	p.mu.Lock()
+	p.callbacks[fd] = onReady
+	p.mu.Unlock()
+
+	ev := unix.EpollEvent{Events: epollEvents, Fd: int32(fd)}
+	if err := unix.EpollCtl(p.fd, unix.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		p.mu.Lock()
+		delete(p.callbacks, fd)
+		p.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+func (p *epoll) Resume(fd int) error {
+	ev := unix.EpollEvent{Events: epollEvents, Fd: int32(fd)}
+	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_MOD, fd, &ev)
+}
+
+func (p *epoll) Remove(fd int) error {
+	p.mu.Lock()
+	delete(p.callbacks, fd)
+	p.mu.Unlock()
+
+	err := unix.EpollCtl(p.fd, unix.EPOLL_CTL_DEL, fd, nil)
+	if errors.Is(err, unix.ENOENT) || errors.Is(err, unix.EBADF) {
+		return nil
+	}
+
+	return err
+}
+
+func (p *epoll) Close() error {
+	close(p.done)
+	<-p.stopped
+	return unix.Close(p.fd)
+}
+
+func (p *epoll) wait() {
+	defer close(p.stopped)
+
+	events := make([]unix.EpollEvent, 128)
+	for {
+		select {
+		case <-p.done:
+			return
+		default:
+		}
+
+		n, err := unix.EpollWait(p.fd, events, epollWaitMillis)
+		if err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			return
+		}
+
+		for i := range n {
+			p.mu.RLock()
+			onReady := p.callbacks[int(events[i].Fd)]
+			p.mu.RUnlock()
+
+			if onReady != nil {
+				onReady()
+			}
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package realtime
+
+func newPoller() (poller, error) {
+	return nil, ErrNetpollUnsupported
+}
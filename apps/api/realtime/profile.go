@@ -0,0 +1,194 @@
+package realtime
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxProfileNameRunes = 24
+	// maxProfileEmojiRunes bounds the longest emoji sequences, such as
+	// families and subdivision flags, with room to spare.
+	maxProfileEmojiRunes = 16
+
+	// profileChangeInterval is the minimum time between accepted profile
+	// changes from one client.
+	profileChangeInterval = 2 * time.Second
+)
+
+var (
+	errProfileName      = errors.New("name must be 1-24 letters, digits, spaces or - _ ' .")
+	errProfileBlocked   = errors.New("name is not allowed")
+	errProfileEmoji     = errors.New("emoji must be a single emoji")
+	errProfileColor     = errors.New("color must look like #a1b2c3")
+	errProfileRateLimit = errors.New("profile changed too recently")
+)
+
+var profileColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// profileBlocklist holds words a display name may not contain as a word, once
+// lowercased and folded like the word filter folds leetspeak. A word spelled
+// across separators, as in "Ad-min" or "a d m i n", is caught too, while names
+// that only contain one, like "Scunthorpe" or "Supporter", are not.
+var profileBlocklist = []string{
+	"admin", "moderator", "system", "server", "official", "support",
+	"fuck", "shit", "cunt", "bitch", "whore", "nazi",
+}
+
+// Profile is what a client chose to show about itself. Empty fields are unset
+// and fall back to the server-assigned identity.
+type Profile struct {
+	Name  string `json:"name,omitempty"`
+	Emoji string `json:"emoji,omitempty"`
+	Color string `json:"color,omitempty"`
+}
+
+func (p Profile) isZero() bool {
+	return p == Profile{}
+}
+
+// validateProfile normalizes msg into a Profile or explains why it was rejected.
+func validateProfile(msg SetProfileMessage) (Profile, error) {
+	p := Profile{
+		Name:  strings.Join(strings.Fields(msg.Name), " "),
+		Emoji: strings.TrimSpace(msg.Emoji),
+		Color: strings.ToLower(strings.TrimSpace(msg.Color)),
+	}
+
+	if p.Name != "" {
+		if !validProfileName(p.Name) {
+			return Profile{}, errProfileName
+		}
+		if blockedProfileName(p.Name) {
+			return Profile{}, errProfileBlocked
+		}
+	}
+
+	if p.Emoji != "" && !validEmoji(p.Emoji) {
+		return Profile{}, errProfileEmoji
+	}
+
+	if p.Color != "" && !profileColorPattern.MatchString(p.Color) {
+		return Profile{}, errProfileColor
+	}
+
+	return p, nil
+}
+
+func validProfileName(name string) bool {
+	if utf8.RuneCountInString(name) > maxProfileNameRunes {
+		return false
+	}
+
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(" -_'.", r) {
+			return false
+		}
+	}
+	return true
+}
+
+func blockedProfileName(name string) bool {
+	var tokens []string
+	for _, field := range strings.FieldsFunc(name, isProfileNameSeparator) {
+		var token strings.Builder
+		for _, r := range field {
+			if folded, ok := normalizeRune(r); ok {
+				token.WriteRune(folded)
+			}
+		}
+		if token.Len() > 0 {
+			tokens = append(tokens, token.String())
+		}
+	}
+
+	for i := range tokens {
+		var joined string
+		for _, token := range tokens[i:] {
+			joined += token
+			if slices.Contains(profileBlocklist, joined) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isProfileNameSeparator(r rune) bool {
+	return strings.ContainsRune(" -_'.", r)
+}
+
+// validEmoji accepts exactly one emoji: a pictograph, a flag made of two
+// regional indicators or a keycap, followed by the variation selectors, skin
+// tones, tags and marks that extend it, and joined to further pictographs by
+// zero-width joiners. It doesn't check that a sequence is one the Unicode
+// data defines.
+func validEmoji(s string) bool {
+	runes := []rune(s)
+	if len(runes) == 0 || len(runes) > maxProfileEmojiRunes {
+		return false
+	}
+
+	rest := runes[1:]
+	switch first := runes[0]; {
+	case isRegionalIndicator(first):
+		return len(rest) == 1 && isRegionalIndicator(rest[0])
+	case first == '#' || first == '*' || (first >= '0' && first <= '9'):
+		return slices.Equal(rest, []rune{0x20E3}) || slices.Equal(rest, []rune{0xFE0F, 0x20E3})
+	case !isPictographic(first):
+		return false
+	}
+
+	for i := 0; i < len(rest); i++ {
+		switch r := rest[i]; {
+		case isEmojiExtend(r):
+		case r == 0x200D:
+			// A joiner must link two pictographs.
+			if i+1 == len(rest) || !isPictographic(rest[i+1]) {
+				return false
+			}
+			i++
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isPictographic approximates Unicode's Extended_Pictographic property.
+func isPictographic(r rune) bool {
+	switch {
+	case isRegionalIndicator(r), isSkinTone(r):
+		return false
+	case r >= 0x1F000 && r <= 0x1FAFF,
+		r >= 0x2600 && r <= 0x27BF,
+		r >= 0x2300 && r <= 0x23FF,
+		r >= 0x2B00 && r <= 0x2BFF,
+		r >= 0x2194 && r <= 0x21AA,
+		r >= 0x25AA && r <= 0x25FE,
+		r >= 0x2934 && r <= 0x2935:
+		return true
+	}
+	return slices.Contains([]rune{0xA9, 0xAE, 0x203C, 0x2049, 0x2122, 0x2139, 0x24C2, 0x3030, 0x303D, 0x3297, 0x3299}, r)
+}
+
+// isEmojiExtend reports whether r extends the emoji before it rather than
+// starting another.
+func isEmojiExtend(r rune) bool {
+	return r == 0xFE0E || r == 0xFE0F || isSkinTone(r) ||
+		(r >= 0xE0020 && r <= 0xE007F) ||
+		unicode.In(r, unicode.Mn, unicode.Me)
+}
+
+func isSkinTone(r rune) bool {
+	return r >= 0x1F3FB && r <= 0x1F3FF
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
@@ -0,0 +1,156 @@
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidateProfile(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  SetProfileMessage
+		want Profile
+		err  error
+	}{
+		{
+			name: "normalizes fields",
+			msg:  SetProfileMessage{Name: "  Ada   Lovelace ", Emoji: " 🦊 ", Color: "#A1B2C3"},
+			want: Profile{Name: "Ada Lovelace", Emoji: "🦊", Color: "#a1b2c3"},
+		},
+		{name: "empty clears", msg: SetProfileMessage{}, want: Profile{}},
+		{name: "accepts joined emoji", msg: SetProfileMessage{Emoji: "👩‍🚀"}, want: Profile{Emoji: "👩‍🚀"}},
+		{name: "accepts skin tone", msg: SetProfileMessage{Emoji: "👋🏽"}, want: Profile{Emoji: "👋🏽"}},
+		{name: "name too long", msg: SetProfileMessage{Name: "abcdefghijklmnopqrstuvwxyz"}, err: errProfileName},
+		{name: "name charset", msg: SetProfileMessage{Name: "<script>"}, err: errProfileName},
+		{name: "name blocklist", msg: SetProfileMessage{Name: "The Ad-Min"}, err: errProfileBlocked},
+		{name: "name blocklist leetspeak", msg: SetProfileMessage{Name: "Sh1t Head"}, err: errProfileBlocked},
+		{name: "name blocklist spelled out", msg: SetProfileMessage{Name: "a d m i n"}, err: errProfileBlocked},
+		{name: "allows Yamashita", msg: SetProfileMessage{Name: "Yamashita"}, want: Profile{Name: "Yamashita"}},
+		{name: "allows Kinoshita", msg: SetProfileMessage{Name: "Kinoshita"}, want: Profile{Name: "Kinoshita"}},
+		{name: "allows Observer", msg: SetProfileMessage{Name: "Observer"}, want: Profile{Name: "Observer"}},
+		{name: "allows Supporter", msg: SetProfileMessage{Name: "Supporter"}, want: Profile{Name: "Supporter"}},
+		{name: "allows Scunthorpe", msg: SetProfileMessage{Name: "Scunthorpe"}, want: Profile{Name: "Scunthorpe"}},
+		{name: "accepts flag", msg: SetProfileMessage{Emoji: "🇯🇵"}, want: Profile{Emoji: "🇯🇵"}},
+		{name: "accepts keycap", msg: SetProfileMessage{Emoji: "1️⃣"}, want: Profile{Emoji: "1️⃣"}},
+		{name: "accepts presentation selector", msg: SetProfileMessage{Emoji: "❤️"}, want: Profile{Emoji: "❤️"}},
+		{name: "accepts family", msg: SetProfileMessage{Emoji: "👨‍👩‍👧‍👦"}, want: Profile{Emoji: "👨‍👩‍👧‍👦"}},
+		{name: "accepts subdivision flag", msg: SetProfileMessage{Emoji: "🏴󠁧󠁢󠁳󠁣󠁴󠁿"}, want: Profile{Emoji: "🏴󠁧󠁢󠁳󠁣󠁴󠁿"}},
+		{name: "emoji text", msg: SetProfileMessage{Emoji: "ab"}, err: errProfileEmoji},
+		{name: "emoji starts with joiner", msg: SetProfileMessage{Emoji: "‍🦊"}, err: errProfileEmoji},
+		{name: "several emoji", msg: SetProfileMessage{Emoji: "🦊🦊"}, err: errProfileEmoji},
+		{name: "several flags", msg: SetProfileMessage{Emoji: "🇯🇵🇺🇸"}, err: errProfileEmoji},
+		{name: "lone skin tone", msg: SetProfileMessage{Emoji: "🏽"}, err: errProfileEmoji},
+		{name: "trailing joiner", msg: SetProfileMessage{Emoji: "🦊‍"}, err: errProfileEmoji},
+		{name: "color format", msg: SetProfileMessage{Color: "red"}, err: errProfileColor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateProfile(tt.msg)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if got != tt.want {
+				t.Fatalf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestClient_handleMessage_setProfileUpdatesPresence(t *testing.T) {
+	_, sender, receiver := setupHubWithClients(t)
+	drainChannel(receiver.send)
+
+	sender.handleMessage([]byte(`{"type":"set_profile","name":"Ada","emoji":"🦊","color":"#112233"}`))
+
+	p := lastPresence(t, receiver)
+	if len(p.Users) != 1 || p.Users[0].Profile == nil {
+		t.Fatalf("expected sender's profile in presence, got %+v", p.Users)
+	}
+	if want := (Profile{Name: "Ada", Emoji: "🦊", Color: "#112233"}); *p.Users[0].Profile != want {
+		t.Fatalf("expected %+v, got %+v", want, *p.Users[0].Profile)
+	}
+}
+
+func TestClient_handleMessage_setProfileRejectsAndRateLimits(t *testing.T) {
+	_, sender, _ := setupHubWithClients(t)
+	drainChannel(sender.send)
+
+	expectError := func(code string) {
+		t.Helper()
+
+		raw := readWithTimeout(sender.send, 200*time.Millisecond)
+		if raw == nil {
+			t.Fatalf("expected %s error", code)
+		}
+		var msg ErrorMessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if msg.Type != "error" || msg.Code != code || msg.Message == "" {
+			t.Fatalf("expected %s error, got %+v", code, msg)
+		}
+	}
+
+	sender.handleMessage([]byte(`{"type":"set_profile","color":"blue"}`))
+	expectError("invalid_profile")
+
+	sender.handleMessage([]byte(`{"type":"set_profile","name":"Ada"}`))
+	sender.handleMessage([]byte(`{"type":"set_profile","name":"Grace"}`))
+	for {
+		raw := readWithTimeout(sender.send, 200*time.Millisecond)
+		if raw == nil {
+			t.Fatalf("expected rate_limited error")
+		}
+		var msg ErrorMessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if msg.Type == "error" {
+			if msg.Code != "rate_limited" {
+				t.Fatalf("expected rate_limited, got %+v", msg)
+			}
+			break
+		}
+	}
+
+	if got := sender.Profile().Name; got != "Ada" {
+		t.Fatalf("expected the first change to stick, got %q", got)
+	}
+}
+
+func TestClient_handleMessage_setProfileIsGatedByMuteAndShadowBan(t *testing.T) {
+	h, sender, receiver := setupHubWithClients(t)
+	drainChannel(sender.send)
+	drainChannel(receiver.send)
+
+	if err := h.moderation.Add(Sanction{Kind: SanctionMute, Subject: subjectUser + sender.userID}); err != nil {
+		t.Fatalf("add mute: %v", err)
+	}
+	sender.handleMessage([]byte(`{"type":"set_profile","name":"Spam"}`))
+
+	var msg ErrorMessage
+	if err := json.Unmarshal(readWithTimeout(sender.send, 200*time.Millisecond), &msg); err != nil || msg.Code != "muted" {
+		t.Fatalf("expected a muted error, got %+v (%v)", msg, err)
+	}
+	if raw := readWithTimeout(receiver.send, 100*time.Millisecond); raw != nil {
+		t.Fatalf("expected no presence for a muted profile change, got %s", raw)
+	}
+
+	if _, err := h.moderation.Remove(SanctionMute, subjectUser+sender.userID); err != nil {
+		t.Fatalf("remove mute: %v", err)
+	}
+	if err := h.moderation.Add(Sanction{Kind: SanctionShadowBan, Subject: subjectUser + sender.userID}); err != nil {
+		t.Fatalf("add shadow-ban: %v", err)
+	}
+	sender.handleMessage([]byte(`{"type":"set_profile","name":"Spam"}`))
+
+	if self := lastPresence(t, sender).Self; self == nil || self.Profile == nil || self.Profile.Name != "Spam" {
+		t.Fatalf("expected the shadow-banned sender to see its own profile, got %+v", self)
+	}
+	if p := lastPresence(t, receiver); len(p.Users) != 1 || p.Users[0].Profile != nil {
+		t.Fatalf("expected others not to see the shadow-banned profile, got %+v", p.Users)
+	}
+}
@@ -0,0 +1,143 @@
+package realtime
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// DefaultProxyHeader is the forwarding header most reverse proxies write.
+const DefaultProxyHeader = "X-Forwarded-For"
+
+// TrustedProxies resolves the address of the client behind reverse proxies.
+// Forwarding headers are only believed when they were added by a proxy in
+// one of the trusted ranges, since anyone can send them.
+type TrustedProxies struct {
+	prefixes []netip.Prefix
+	// header is the one forwarding header the proxies write. Any other is
+	// passed through from the client untouched, so it is never read.
+	header string
+}
+
+// NewTrustedProxies trusts header, either Forwarded or an X-Forwarded-For
+// style list, as set by proxies in prefixes. Empty means DefaultProxyHeader.
+func NewTrustedProxies(prefixes []netip.Prefix, header string) *TrustedProxies {
+	if header == "" {
+		header = DefaultProxyHeader
+	}
+	return &TrustedProxies{prefixes: prefixes, header: http.CanonicalHeaderKey(header)}
+}
+
+// Handler records each request's client address for PeerFromRequest before
+// passing it to next.
+func (t *TrustedProxies) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := t.ClientIP(r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+	})
+}
+
+// ClientIP returns the address of the client that made r. Starting from the
+// connection's peer, it walks the forwarding chain from the nearest hop back
+// for as long as each hop is a trusted proxy, and returns the first address
+// that isn't. Only the configured header is read.
+func (t *TrustedProxies) ClientIP(r *http.Request) string {
+	ip, ok := parseHopAddr(r.RemoteAddr)
+	if !ok {
+		return hostOf(r.RemoteAddr)
+	}
+	if !t.trusts(ip) {
+		return ip.String()
+	}
+
+	var hops []string
+	if t.header == "Forwarded" {
+		hops = forwardedFor(r.Header.Values(t.header))
+	} else {
+		hops = xForwardedFor(r.Header.Values(t.header))
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHopAddr(hops[i])
+		if !ok {
+			// An obfuscated or garbled hop ends what can be trusted, so the
+			// last proxy that named it is the best we know.
+			break
+		}
+		ip = hop
+		if !t.trusts(ip) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+func (t *TrustedProxies) trusts(ip netip.Addr) bool {
+	for _, prefix := range t.prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the for= parameter of each element of RFC 7239
+// Forwarded headers, in order, or nil if there are none.
+func forwardedFor(headers []string) []string {
+	var hops []string
+	for _, header := range headers {
+		for element := range strings.SplitSeq(header, ",") {
+			for pair := range strings.SplitSeq(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// xForwardedFor returns the addresses in X-Forwarded-For style headers, in
+// order.
+func xForwardedFor(headers []string) []string {
+	var hops []string
+	for _, header := range headers {
+		for hop := range strings.SplitSeq(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// parseHopAddr parses an address as it appears in RemoteAddr or forwarding
+// headers: bare, with a port, or as a bracketed IPv6 address.
+func parseHopAddr(value string) (netip.Addr, bool) {
+	if ap, err := netip.ParseAddrPort(value); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(value, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+func hostOf(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+func clientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
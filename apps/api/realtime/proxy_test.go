@@ -0,0 +1,89 @@
+package realtime
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestTrustedProxies_ClientIP(t *testing.T) {
+	prefixes := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+	proxies := NewTrustedProxies(prefixes, "")
+	forwarded := NewTrustedProxies(prefixes, "forwarded")
+
+	tests := []struct {
+		name       string
+		proxies    *TrustedProxies
+		remoteAddr string
+		header     string
+		value      string
+		want       string
+	}{
+		{"direct client", proxies, "203.0.113.7:1000", "", "", "203.0.113.7"},
+		{"untrusted hop is ignored", proxies, "203.0.113.7:1000", "X-Forwarded-For", "198.51.100.1", "203.0.113.7"},
+		{"trusted hop", proxies, "10.0.0.1:1000", "X-Forwarded-For", "198.51.100.1", "198.51.100.1"},
+		{"spoofed prefix is skipped", proxies, "10.0.0.1:1000", "X-Forwarded-For", "1.2.3.4, 198.51.100.1", "198.51.100.1"},
+		{"chain of proxies", proxies, "10.0.0.1:1000", "X-Forwarded-For", "198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"all hops trusted", proxies, "10.0.0.1:1000", "X-Forwarded-For", "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+		{"no header", proxies, "10.0.0.1:1000", "", "", "10.0.0.1"},
+		{"garbled hop", proxies, "10.0.0.1:1000", "X-Forwarded-For", "198.51.100.1, nonsense", "10.0.0.1"},
+		{"forwarded", forwarded, "10.0.0.1:1000", "Forwarded", `for=198.51.100.1;proto=https`, "198.51.100.1"},
+		{"forwarded ipv6 with port", forwarded, "[2001:db8::1]:1000", "Forwarded", `for="[2001:db8:cafe::17]:4711"`, "2001:db8:cafe::17"},
+		{"forwarded chain", forwarded, "10.0.0.1:1000", "Forwarded", `for=198.51.100.1, for=10.0.0.2;by=10.0.0.1`, "198.51.100.1"},
+		{"forwarded obfuscated", forwarded, "10.0.0.1:1000", "Forwarded", `for=_hidden`, "10.0.0.1"},
+		{"forwarded is ignored when xff is trusted", proxies, "10.0.0.1:1000", "Forwarded", `for=198.51.100.1`, "10.0.0.1"},
+		{"xff is ignored when forwarded is trusted", forwarded, "10.0.0.1:1000", "X-Forwarded-For", "198.51.100.1", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/connect", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			if got := tt.proxies.ClientIP(req); got != tt.want {
+				t.Fatalf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrustedProxies_IgnoresSpoofedForwardedBesideXFF(t *testing.T) {
+	proxies := NewTrustedProxies([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, "")
+
+	// The proxy appends the real client to X-Forwarded-For but passes the
+	// client's own Forwarded header through.
+	req := httptest.NewRequest(http.MethodGet, "/connect", nil)
+	req.RemoteAddr = "10.0.0.1:1000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.Header.Set("Forwarded", "for=203.0.113.66")
+
+	if got := proxies.ClientIP(req); got != "198.51.100.1" {
+		t.Fatalf("ClientIP = %q, want the address the proxy wrote", got)
+	}
+}
+
+func TestTrustedProxies_HandlerSetsPeerIP(t *testing.T) {
+	proxies := NewTrustedProxies([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, "")
+
+	var peer Peer
+	handler := proxies.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer = PeerFromRequest(r)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/connect", nil)
+	req.RemoteAddr = "10.0.0.1:1000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if peer.IP() != "198.51.100.1" || peer.RemoteAddr != "10.0.0.1:1000" {
+		t.Fatalf("got peer %+v, want the forwarded address behind 10.0.0.1", peer)
+	}
+	if got := peer.subjects(); got[0] != "ip:198.51.100.1" {
+		t.Fatalf("subjects = %v, want bans to target the forwarded address", got)
+	}
+}
@@ -0,0 +1,467 @@
+package realtime
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gobwas/ws"
+)
+
+// frameReadTimeout bounds how long a worker waits for the rest of a frame once
+// its first bytes have arrived, so a slow sender can't pin a worker.
+const frameReadTimeout = 5 * time.Second
+
+var ErrNetpollUnsupported = errors.New("realtime: netpoll mode is not supported on this platform")
+
+// poller notifies the reactor when a connection has data to read. Each
+// registration fires once and must be re-armed with Resume.
+type poller interface {
+	Add(fd int, onReady func()) error
+	Resume(fd int) error
+	Remove(fd int) error
+	Close() error
+}
+
+// Reactor is the high-concurrency alternative to the goroutine-per-pump model.
+// Idle connections cost no goroutines and no private buffers: an epoll loop
+// reports readable sockets, a fixed worker pool reads one frame at a time and
+// flushes queued messages, and frame and write buffers are pooled.
+type Reactor struct {
+	hub    *Hub
+	poller poller
+	pool   *workerPool
+
+	mu    sync.Mutex
+	conns map[*pollConn]struct{}
+
+	done chan struct{}
+}
+
+func NewReactor(hub *Hub, workers int) (*Reactor, error) {
+	if workers <= 0 {
+		return nil, fmt.Errorf("realtime: reactor needs at least one worker, got %d", workers)
+	}
+
+	p, err := newPoller()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reactor{
+		hub:    hub,
+		poller: p,
+		pool:   newWorkerPool(workers),
+		conns:  make(map[*pollConn]struct{}),
+		done:   make(chan struct{}),
+	}
+	go r.keepalive()
+
+	return r, nil
+}
+
+// Upgrade completes the WebSocket handshake and hands the connection to the
+// reactor. Callers are responsible for origin checks.
+func (r *Reactor) Upgrade(w http.ResponseWriter, req *http.Request) error {
+	conn, rw, _, err := ws.UpgradeHTTP(req, w)
+	if err != nil {
+		return err
+	}
+
+	fd, err := socketFD(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	pc := &pollConn{
+		reactor: r,
+		client:  newClient(r.hub),
+		conn:    conn,
+		fd:      fd,
+		reader:  conn,
+	}
+	pc.lastRead.Store(time.Now().UnixNano())
+	pc.client.wake = pc.wake
+	pc.client.peer = PeerFromRequest(req)
+
+	// Bytes the HTTP server read past the handshake never show up in epoll,
+	// so they are consumed before the socket is armed.
+	if buffered := rw.Reader.Buffered(); buffered > 0 {
+		prefix, _ := rw.Reader.Peek(buffered)
+		pc.prefix = bytes.NewReader(bytes.Clone(prefix))
+		pc.reader = io.MultiReader(pc.prefix, conn)
+	}
+
+	r.mu.Lock()
+	r.conns[pc] = struct{}{}
+	r.mu.Unlock()
+
+	r.hub.Register(pc.client)
+
+	if pc.prefix == nil {
+		if err := pc.arm(); err != nil {
+			pc.close()
+			return err
+		}
+		return nil
+	}
+
+	r.pool.Schedule(func() {
+		for pc.prefix.Len() > 0 {
+			if err := pc.readFrame(); err != nil {
+				pc.close()
+				return
+			}
+		}
+		if err := pc.arm(); err != nil {
+			pc.close()
+		}
+	})
+
+	return nil
+}
+
+// Close stops the reactor and closes every connection it owns.
+func (r *Reactor) Close() error {
+	close(r.done)
+
+	r.mu.Lock()
+	conns := make([]*pollConn, 0, len(r.conns))
+	for pc := range r.conns {
+		conns = append(conns, pc)
+	}
+	r.mu.Unlock()
+
+	for _, pc := range conns {
+		pc.close()
+	}
+
+	r.pool.Close()
+	return r.poller.Close()
+}
+
+// keepalive replaces the per-connection ping tickers with a single sweep.
+func (r *Reactor) keepalive() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			deadline := time.Now().Add(-pongWait).UnixNano()
+
+			r.mu.Lock()
+			for pc := range r.conns {
+				if pc.lastRead.Load() < deadline {
+					r.pool.Schedule(pc.close)
+				} else {
+					r.pool.Schedule(pc.ping)
+				}
+			}
+			r.mu.Unlock()
+
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *Reactor) forget(pc *pollConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns, pc)
+}
+
+type pollConn struct {
+	reactor *Reactor
+	client  *Client
+	conn    net.Conn
+	fd      int
+	reader  io.Reader
+	prefix  *bytes.Reader
+
+	// partial accumulates a fragmented message; only touched by the single
+	// worker that holds the oneshot read registration.
+	partial []byte
+
+	writeMu  sync.Mutex
+	pending  atomic.Bool
+	flushing atomic.Bool
+	lastRead atomic.Int64
+
+	closeOnce sync.Once
+}
+
+func (pc *pollConn) arm() error {
+	r := pc.reactor
+	return r.poller.Add(pc.fd, func() { r.pool.Schedule(pc.onReadable) })
+}
+
+func (pc *pollConn) onReadable() {
+	if err := pc.readFrame(); err != nil {
+		if !errors.Is(err, io.EOF) {
+			log.Printf("Connection error for client %s: %v", pc.client.userID, err)
+		}
+		pc.close()
+		return
+	}
+
+	if err := pc.reactor.poller.Resume(pc.fd); err != nil {
+		pc.close()
+	}
+}
+
+func (pc *pollConn) readFrame() error {
+	pc.conn.SetReadDeadline(time.Now().Add(frameReadTimeout))
+
+	h, err := ws.ReadHeader(pc.reader)
+	if err != nil {
+		return closeAsEOF(err)
+	}
+	if h.Length > maxMessageSize || int64(len(pc.partial))+h.Length > maxMessageSize {
+		return ErrReadLimit
+	}
+
+	buf := acquireFrameBuffer(int(h.Length))
+	defer releaseFrameBuffer(buf)
+
+	payload := (*buf)[:h.Length]
+	if _, err := io.ReadFull(pc.reader, payload); err != nil {
+		return closeAsEOF(err)
+	}
+	if h.Masked {
+		ws.Cipher(payload, h.Mask, 0)
+	}
+
+	pc.lastRead.Store(time.Now().UnixNano())
+
+	switch h.OpCode {
+	case ws.OpPing:
+		return pc.writeFrame(ws.NewPongFrame(payload))
+
+	case ws.OpPong:
+		return nil
+
+	case ws.OpClose:
+		pc.writeFrame(ws.NewCloseFrame(nil))
+		return io.EOF
+
+	case ws.OpText, ws.OpBinary, ws.OpContinuation:
+		if !h.Fin {
+			pc.partial = append(pc.partial, payload...)
+			return nil
+		}
+
+		if pc.partial != nil {
+			payload = append(pc.partial, payload...)
+			pc.partial = nil
+		}
+
+		pc.client.handleMessage(payload)
+		return nil
+	}
+
+	return fmt.Errorf("unexpected opcode %d", h.OpCode)
+}
+
+// wake is called by the hub after it queues a message or closes the send
+// buffer. At most one flush is scheduled at a time; pending catches wake-ups
+// that race with a flush that is already draining.
+func (pc *pollConn) wake() {
+	pc.pending.Store(true)
+	if pc.flushing.CompareAndSwap(false, true) {
+		pc.reactor.pool.Schedule(pc.flush)
+	}
+}
+
+func (pc *pollConn) flush() {
+	for {
+		pc.pending.Store(false)
+
+		if closed, err := pc.drain(); closed || err != nil {
+			pc.close()
+			return
+		}
+
+		pc.flushing.Store(false)
+		if !pc.pending.Load() || !pc.flushing.CompareAndSwap(false, true) {
+			return
+		}
+	}
+}
+
+// drain writes every queued message, then the latest coalesced ones, in one
+// buffered write. It reports whether
+// the hub has closed the client's send buffer.
+func (pc *pollConn) drain() (closed bool, err error) {
+	pc.writeMu.Lock()
+	defer pc.writeMu.Unlock()
+
+	bw := acquireWriter(pc.conn)
+	defer releaseWriter(bw)
+
+	pc.conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+	for {
+		select {
+		case message, ok := <-pc.client.send:
+			if !ok {
+				ws.WriteFrame(bw, ws.NewCloseFrame(nil))
+				bw.Flush()
+				return true, nil
+			}
+
+			if err := ws.WriteFrame(bw, ws.NewTextFrame(message)); err != nil {
+				return false, err
+			}
+
+		default:
+			for _, message := range pc.client.takeCoalesced() {
+				if err := ws.WriteFrame(bw, ws.NewTextFrame(message)); err != nil {
+					return false, err
+				}
+			}
+			return false, bw.Flush()
+		}
+	}
+}
+
+func (pc *pollConn) ping() {
+	if err := pc.writeFrame(ws.NewPingFrame(nil)); err != nil {
+		pc.close()
+	}
+}
+
+func (pc *pollConn) writeFrame(f ws.Frame) error {
+	pc.writeMu.Lock()
+	defer pc.writeMu.Unlock()
+
+	pc.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return ws.WriteFrame(pc.conn, f)
+}
+
+func (pc *pollConn) close() {
+	pc.closeOnce.Do(func() {
+		pc.reactor.poller.Remove(pc.fd)
+		pc.reactor.forget(pc)
+		pc.conn.Close()
+		pc.client.hub.unregister <- pc.client
+	})
+}
+
+func closeAsEOF(err error) error {
+	if errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return io.EOF
+	}
+
+	return err
+}
+
+func socketFD(conn net.Conn) (int, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0, fmt.Errorf("realtime: %T does not expose a file descriptor", conn)
+	}
+
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var fd int
+	if err := raw.Control(func(f uintptr) { fd = int(f) }); err != nil {
+		return 0, err
+	}
+
+	return fd, nil
+}
+
+// workerPool runs reactor tasks on a fixed set of goroutines. Schedule never
+// blocks: when every worker is busy the task gets a goroutine of its own, so
+// the epoll loop and the hub are never stalled by a slow connection.
+type workerPool struct {
+	tasks chan func()
+	done  chan struct{}
+}
+
+func newWorkerPool(workers int) *workerPool {
+	p := &workerPool{
+		tasks: make(chan func(), workers*4),
+		done:  make(chan struct{}),
+	}
+
+	for range workers {
+		go p.work()
+	}
+
+	return p
+}
+
+func (p *workerPool) Schedule(task func()) {
+	select {
+	case p.tasks <- task:
+	case <-p.done:
+	default:
+		go task()
+	}
+}
+
+func (p *workerPool) Close() {
+	close(p.done)
+}
+
+func (p *workerPool) work() {
+	for {
+		select {
+		case task := <-p.tasks:
+			task()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+var frameBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 512)
+		return &b
+	},
+}
+
+func acquireFrameBuffer(size int) *[]byte {
+	buf := frameBuffers.Get().(*[]byte)
+	if cap(*buf) < size {
+		*buf = make([]byte, size)
+	}
+	*buf = (*buf)[:size]
+	return buf
+}
+
+func releaseFrameBuffer(buf *[]byte) {
+	frameBuffers.Put(buf)
+}
+
+var writers = sync.Pool{
+	New: func() any { return bufio.NewWriterSize(nil, 1024) },
+}
+
+func acquireWriter(w io.Writer) *bufio.Writer {
+	bw := writers.Get().(*bufio.Writer)
+	bw.Reset(w)
+	return bw
+}
+
+func releaseWriter(bw *bufio.Writer) {
+	bw.Reset(nil)
+	writers.Put(bw)
+}
@@ -0,0 +1,163 @@
+//go:build linux
+
+package realtime
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gorilla/websocket"
+)
+
+// TestLoad_IdleConnectionMemory compares the memory held per idle connection by
+// the goroutine-per-pump model and the netpoll reactor. It opens many sockets,
+// so it only runs on request:
+//
+//	REALTIME_LOADTEST=5000 go test -run TestLoad_IdleConnectionMemory -v ./realtime
+//
+// The hub's own bookkeeping is identical in both models, so a stub drains its
+// register and unregister channels; the numbers cover the transport alone
+// (plus the test's client sockets, which cost the same in both runs).
+func TestLoad_IdleConnectionMemory(t *testing.T) {
+	value := os.Getenv("REALTIME_LOADTEST")
+	if value == "" {
+		t.Skip("set REALTIME_LOADTEST=<connections> to run the idle connection load test")
+	}
+
+	conns, err := strconv.Atoi(value)
+	if err != nil || conns <= 0 {
+		t.Fatalf("REALTIME_LOADTEST must be a positive connection count, got %q", value)
+	}
+
+	goroutine := measureIdleConnections(t, conns, func(h *Hub) (http.Handler, func()) {
+		upgrader := websocket.Upgrader{
+			ReadBufferSize:    1024,
+			WriteBufferSize:   1024,
+			EnableCompression: true,
+			CheckOrigin:       func(*http.Request) bool { return true },
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+
+			client := NewClient(h, NewWebsocketConn(conn), Peer{RemoteAddr: conn.RemoteAddr().String()})
+			h.Register(client)
+
+			go client.WritePump()
+			go client.ReadPump()
+		}), func() {}
+	})
+
+	netpoll := measureIdleConnections(t, conns, func(h *Hub) (http.Handler, func()) {
+		r, err := NewReactor(h, runtime.NumCPU())
+		if err != nil {
+			t.Fatalf("new reactor: %v", err)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			r.Upgrade(w, req)
+		}), func() { r.Close() }
+	})
+
+	t.Logf("goroutine-per-pump: %6d bytes/conn, %.2f goroutines/conn", goroutine.bytesPerConn, goroutine.goroutinesPerConn)
+	t.Logf("netpoll reactor:    %6d bytes/conn, %.2f goroutines/conn", netpoll.bytesPerConn, netpoll.goroutinesPerConn)
+
+	if netpoll.bytesPerConn >= goroutine.bytesPerConn {
+		t.Fatalf("expected netpoll to use less memory per idle connection (%d >= %d)", netpoll.bytesPerConn, goroutine.bytesPerConn)
+	}
+}
+
+type idleConnectionCost struct {
+	bytesPerConn      uint64
+	goroutinesPerConn float64
+}
+
+func measureIdleConnections(t *testing.T, conns int, serve func(*Hub) (http.Handler, func())) idleConnectionCost {
+	t.Helper()
+
+	h := NewHub()
+	registered := make(chan struct{}, conns)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-h.register:
+				registered <- struct{}{}
+			case <-h.unregister:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	handler, shutdown := serve(h)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	defer shutdown()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	before := sampleMemory()
+
+	clients := make([]net.Conn, 0, conns)
+	defer func() {
+		for _, c := range clients {
+			c.Close()
+		}
+	}()
+
+	for range conns {
+		conn, _, _, err := ws.Dial(context.Background(), url)
+		if err != nil {
+			t.Fatalf("dial connection %d: %v", len(clients), err)
+		}
+		clients = append(clients, conn)
+	}
+
+	for range conns {
+		select {
+		case <-registered:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for registrations")
+		}
+	}
+
+	// Let handler goroutines finish unwinding before sampling.
+	time.Sleep(200 * time.Millisecond)
+	after := sampleMemory()
+
+	return idleConnectionCost{
+		bytesPerConn:      (after.bytes - min(before.bytes, after.bytes)) / uint64(conns),
+		goroutinesPerConn: float64(after.goroutines-before.goroutines) / float64(conns),
+	}
+}
+
+type memorySample struct {
+	bytes      uint64
+	goroutines int
+}
+
+func sampleMemory() memorySample {
+	runtime.GC()
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	return memorySample{
+		bytes:      m.HeapInuse + m.StackInuse,
+		goroutines: runtime.NumGoroutine(),
+	}
+}
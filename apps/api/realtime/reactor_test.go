@@ -0,0 +1,127 @@
+//go:build linux
+
+package realtime
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func newReactorTestServer(t *testing.T) (*Hub, string) {
+	t.Helper()
+
+	h := NewHub()
+	go h.Run()
+
+	r, err := NewReactor(h, 2)
+	if err != nil {
+		t.Fatalf("new reactor: %v", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := r.Upgrade(w, req); err != nil {
+			t.Errorf("upgrade: %v", err)
+		}
+	}))
+	t.Cleanup(func() {
+		srv.Close()
+		r.Close()
+	})
+
+	return h, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestReactor_RelaysTypingAndPresence(t *testing.T) {
+	h, url := newReactorTestServer(t)
+
+	receiver := newTestClient(h, "receiver", 10)
+	h.Register(receiver)
+	drainChannel(receiver.send)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	_, raw, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read presence: %v", err)
+	}
+
+	var presence PresenceMessage
+	if err := json.Unmarshal(raw, &presence); err != nil {
+		t.Fatalf("unmarshal presence: %v", err)
+	}
+	if len(presence.Users) != 1 || presence.Users[0].ID != receiver.userID {
+		t.Fatalf("expected presence with receiver, got %+v", presence.Users)
+	}
+	drainChannel(receiver.send)
+
+	if err := conn.WriteJSON(map[string]any{"type": "typing_update", "char": "n"}); err != nil {
+		t.Fatalf("write typing update: %v", err)
+	}
+
+	raw = readWithTimeout(receiver.send, 500*time.Millisecond)
+	if raw == nil {
+		t.Fatalf("expected relayed typing update, got none")
+	}
+
+	var msg RelayMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		t.Fatalf("unmarshal relay: %v", err)
+	}
+	if msg.Type != "typing_update" || msg.Char != "n" {
+		t.Fatalf("unexpected relay: %+v", msg)
+	}
+
+	h.BroadcastMessageExcept(receiver, map[string]string{"type": "custom"})
+
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	if _, raw, err = conn.ReadMessage(); err != nil {
+		t.Fatalf("read broadcast: %v", err)
+	}
+	if string(raw) != `{"type":"custom"}` {
+		t.Fatalf("unexpected broadcast: %s", string(raw))
+	}
+}
+
+func TestReactor_UnregistersOnClose(t *testing.T) {
+	h, url := newReactorTestServer(t)
+
+	observer := newTestClient(h, "observer", 10)
+	h.Register(observer)
+	drainChannel(observer.send)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	if raw := readWithTimeout(observer.send, 500*time.Millisecond); raw == nil {
+		t.Fatalf("expected presence after reactor client joined, got none")
+	}
+
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	conn.Close()
+
+	raw := readWithTimeout(observer.send, time.Second)
+	if raw == nil {
+		t.Fatalf("expected presence after reactor client left, got none")
+	}
+
+	var presence PresenceMessage
+	if err := json.Unmarshal(raw, &presence); err != nil {
+		t.Fatalf("unmarshal presence: %v", err)
+	}
+	if len(presence.Users) != 0 {
+		t.Fatalf("expected empty presence, got %+v", presence.Users)
+	}
+}
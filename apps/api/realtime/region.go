@@ -0,0 +1,388 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRegionSpan caps how many regions a viewport subscribes to along each
+	// axis. A viewport zoomed out past that only follows the regions around its
+	// center, so one client can't subscribe to an unbounded part of the canvas.
+	maxRegionSpan = 8
+
+	regionInboxSize = 256
+)
+
+type regionCommandKind int
+
+const (
+	regionSubscribe regionCommandKind = iota
+	regionUnsubscribe
+	regionJoin
+	regionLeave
+	regionDeliver
+	regionStop
+)
+
+type regionCommand struct {
+	kind   regionCommandKind
+	client *Client
+	req    broadcastRequest
+}
+
+// region is the actor for one fixed-size square of the canvas. Residents are
+// the clients whose bubble is anchored in it; subscribers are the clients
+// whose viewport overlaps it. Messages authored in a region are delivered to
+// its subscribers, and its presence lists only its residents, so no loop ever
+// has to touch the whole room.
+type region struct {
+	id     RegionID
+	bounds Rect
+	hub    *Hub
+	inbox  chan regionCommand
+
+	residents   map[*Client]struct{}
+	subscribers map[*Client]struct{}
+	presenceDue <-chan time.Time
+
+	// refs counts residents and subscribers as the registry sees them, so it
+	// can stop the region once nobody needs it. Guarded by the registry lock.
+	refs int
+}
+
+func (r *region) run() {
+	for {
+		select {
+		case cmd := <-r.inbox:
+			switch cmd.kind {
+			case regionSubscribe:
+				r.subscribers[cmd.client] = struct{}{}
+				r.sendPresence(cmd.client)
+
+			case regionUnsubscribe:
+				delete(r.subscribers, cmd.client)
+
+			case regionJoin:
+				r.residents[cmd.client] = struct{}{}
+				r.presenceChanged()
+
+			case regionLeave:
+				delete(r.residents, cmd.client)
+				r.presenceChanged()
+
+			case regionDeliver:
+				r.deliver(cmd.req)
+
+			case regionStop:
+				return
+			}
+
+		case <-r.presenceDue:
+			r.presenceDue = nil
+			for c := range r.subscribers {
+				r.sendPresence(c)
+			}
+		}
+	}
+}
+
+func (r *region) deliver(req broadcastRequest) {
+	for c := range r.subscribers {
+		req.deliverTo(c)
+	}
+}
+
+// presenceChanged mirrors the hub: send now, or once the debounce window
+// closes if one is configured.
+func (r *region) presenceChanged() {
+	if r.hub.presenceWindow == 0 {
+		for c := range r.subscribers {
+			r.sendPresence(c)
+		}
+		return
+	}
+
+	if r.presenceDue == nil {
+		r.presenceDue = time.After(r.hub.presenceWindow)
+	}
+}
+
+// sendPresence sends target the region's residents. As with the room-wide
+// presence, target is left out of the list and reported as self instead.
+func (r *region) sendPresence(target *Client) {
+	msg := RegionPresenceMessage{
+		Type:   "region_presence",
+		Region: r.id,
+		Bounds: r.bounds,
+		Users:  make([]PresenceUser, 0, len(r.residents)),
+		Total:  int(r.hub.population.Load()),
+	}
+	origin := target.Position()
+	for c := range r.residents {
+		if c == target {
+			self := c.selfPresence()
+			msg.Self = &self
+			continue
+		}
+
+		if target.blocks(c.userID) {
+			continue
+		}
+
+		user := c.presence()
+		if r.hub.proximity > 0 && !within(*user.Position, origin, r.hub.proximity) {
+			continue
+		}
+		msg.Users = append(msg.Users, user)
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshaling region presence for client %s: %v", target.userID, err)
+		return
+	}
+
+	target.trySend(data)
+}
+
+// regionRegistry partitions an unbounded canvas into square regions and
+// starts a region actor the first time one is needed. It tracks which region
+// each client lives in and which regions it watches, and stops a region once
+// it has neither residents nor subscribers.
+type regionRegistry struct {
+	hub  *Hub
+	size float64
+
+	mu            sync.RWMutex
+	regions       map[gridCell]*region
+	homes         map[*Client]gridCell
+	subscriptions map[*Client]map[gridCell]struct{}
+}
+
+func newRegionRegistry(hub *Hub, size float64) *regionRegistry {
+	return &regionRegistry{
+		hub:           hub,
+		size:          size,
+		regions:       make(map[gridCell]*region),
+		homes:         make(map[*Client]gridCell),
+		subscriptions: make(map[*Client]map[gridCell]struct{}),
+	}
+}
+
+// join makes c a resident of the region containing its bubble's anchor.
+func (g *regionRegistry) join(c *Client, p Point) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	cell := g.cellAt(p)
+	if home, ok := g.homes[c]; ok {
+		if home == cell {
+			// Moving within the region still changes who is in range.
+			g.sendLocked(cell, regionCommand{kind: regionJoin, client: c})
+			return
+		}
+		g.sendLocked(home, regionCommand{kind: regionLeave, client: c})
+		g.releaseLocked(home)
+	}
+
+	g.homes[c] = cell
+	g.acquireLocked(cell).inbox <- regionCommand{kind: regionJoin, client: c}
+}
+
+// subscribe points c's subscriptions at the regions its viewport can see,
+// subscribing to new ones and dropping the ones it panned away from.
+func (g *regionRegistry) subscribe(c *Client, viewport Rect) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.subscribeLocked(c, viewport)
+}
+
+// subscribeDefault subscribes c to the regions defaultViewport can see, unless
+// it has already reported a viewport of its own.
+func (g *regionRegistry) subscribeDefault(c *Client) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.subscriptions[c]; !ok {
+		g.subscribeLocked(c, defaultViewport)
+	}
+}
+
+func (g *regionRegistry) subscribeLocked(c *Client, viewport Rect) {
+	// The hub closes a client before dropping it from the registry, so a late
+	// viewport from a departing client can't re-add it.
+	if c.isClosed() {
+		return
+	}
+
+	wanted := g.cellsSeenBy(viewport)
+	current := g.subscriptions[c]
+
+	for cell := range current {
+		if _, ok := wanted[cell]; !ok {
+			g.sendLocked(cell, regionCommand{kind: regionUnsubscribe, client: c})
+			g.releaseLocked(cell)
+		}
+	}
+	for cell := range wanted {
+		if _, ok := current[cell]; !ok {
+			g.acquireLocked(cell).inbox <- regionCommand{kind: regionSubscribe, client: c}
+		}
+	}
+
+	g.subscriptions[c] = wanted
+}
+
+// remove drops c from its home region and every region it watches.
+func (g *regionRegistry) remove(c *Client) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if home, ok := g.homes[c]; ok {
+		delete(g.homes, c)
+		g.sendLocked(home, regionCommand{kind: regionLeave, client: c})
+		g.releaseLocked(home)
+	}
+
+	for cell := range g.subscriptions[c] {
+		g.sendLocked(cell, regionCommand{kind: regionUnsubscribe, client: c})
+		g.releaseLocked(cell)
+	}
+	delete(g.subscriptions, c)
+}
+
+// deliverFrom sends req to the subscribers of sender's home region. A sender's
+// messages all pass through that one inbox, so they stay in order.
+func (g *regionRegistry) deliverFrom(sender *Client, req broadcastRequest) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if home, ok := g.homes[sender]; ok {
+		g.sendLocked(home, regionCommand{kind: regionDeliver, req: req})
+	}
+}
+
+// sendSnapshot sends target, through each region it watches, the
+// compositions of that region's residents. Each part queues behind the typing
+// the region has yet to deliver, so target never sees a keystroke twice or
+// text older than the snapshot.
+func (g *regionRegistry) sendSnapshot(target *Client, compositions map[*Client]Composition) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	parts := make(map[gridCell]*TypingSnapshotMessage, len(g.subscriptions[target]))
+	for cell := range g.subscriptions[target] {
+		parts[cell] = &TypingSnapshotMessage{
+			Type:         "typing_snapshot",
+			Region:       &RegionID{X: cell.x, Y: cell.y},
+			Compositions: []Composition{},
+		}
+	}
+	for author, composition := range compositions {
+		cell, homed := g.homes[author]
+		if msg, watched := parts[cell]; homed && watched {
+			msg.Compositions = append(msg.Compositions, composition)
+		}
+	}
+
+	for cell, msg := range parts {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			log.Printf("Error marshaling typing snapshot for client %s: %v", target.userID, err)
+			continue
+		}
+		g.sendLocked(cell, regionCommand{kind: regionDeliver, req: broadcastRequest{
+			data:   data,
+			filter: func(c *Client) bool { return c == target },
+		}})
+	}
+}
+
+// deliverAt sends req to the subscribers of the region containing p.
+func (g *regionRegistry) deliverAt(p Point, req broadcastRequest) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	g.sendLocked(g.cellAt(p), regionCommand{kind: regionDeliver, req: req})
+}
+
+// sendLocked queues cmd on a running region. Regions nobody lives in or
+// watches don't exist, and there is nobody there to deliver to.
+func (g *regionRegistry) sendLocked(cell gridCell, cmd regionCommand) {
+	if r, ok := g.regions[cell]; ok {
+		r.inbox <- cmd
+	}
+}
+
+func (g *regionRegistry) acquireLocked(cell gridCell) *region {
+	r, ok := g.regions[cell]
+	if !ok {
+		r = &region{
+			id:          RegionID{X: cell.x, Y: cell.y},
+			bounds:      Rect{X: float64(cell.x) * g.size, Y: float64(cell.y) * g.size, Width: g.size, Height: g.size},
+			hub:         g.hub,
+			inbox:       make(chan regionCommand, regionInboxSize),
+			residents:   make(map[*Client]struct{}),
+			subscribers: make(map[*Client]struct{}),
+		}
+		g.regions[cell] = r
+		go r.run()
+	}
+
+	r.refs++
+	return r
+}
+
+func (g *regionRegistry) releaseLocked(cell gridCell) {
+	r, ok := g.regions[cell]
+	if !ok {
+		return
+	}
+
+	r.refs--
+	if r.refs <= 0 {
+		delete(g.regions, cell)
+		r.inbox <- regionCommand{kind: regionStop}
+	}
+}
+
+func (g *regionRegistry) cellAt(p Point) gridCell {
+	return gridCell{x: int(math.Floor(p.X / g.size)), y: int(math.Floor(p.Y / g.size))}
+}
+
+// cellsSeenBy returns the regions holding bubbles that could show up in
+// viewport. A bubble belongs to the region of its top-left corner, so the
+// viewport is stretched up and left by a bubble to catch ones hanging into it.
+func (g *regionRegistry) cellsSeenBy(viewport Rect) map[gridCell]struct{} {
+	area := Rect{
+		X:      viewport.X - bubbleWidth,
+		Y:      viewport.Y - bubbleHeight,
+		Width:  viewport.Width + bubbleWidth,
+		Height: viewport.Height + bubbleHeight,
+	}
+
+	minCell, maxCell := cellRangeSized(area, g.size)
+	minCell.x, maxCell.x = clampSpan(minCell.x, maxCell.x)
+	minCell.y, maxCell.y = clampSpan(minCell.y, maxCell.y)
+
+	cells := make(map[gridCell]struct{})
+	for y := minCell.y; y <= maxCell.y; y++ {
+		for x := minCell.x; x <= maxCell.x; x++ {
+			cells[gridCell{x: x, y: y}] = struct{}{}
+		}
+	}
+	return cells
+}
+
+// clampSpan narrows [lo, hi] to at most maxRegionSpan cells around its middle.
+func clampSpan(lo, hi int) (int, int) {
+	if hi-lo+1 <= maxRegionSpan {
+		return lo, hi
+	}
+
+	lo = lo + (hi-lo+1-maxRegionSpan)/2
+	return lo, lo + maxRegionSpan - 1
+}
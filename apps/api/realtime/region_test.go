@@ -0,0 +1,243 @@
+package realtime
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+const testRegionSize = 1000
+
+// readRegionPresence waits for the next region presence for region id on c,
+// skipping other messages.
+func readRegionPresence(t *testing.T, c *Client, id RegionID) RegionPresenceMessage {
+	t.Helper()
+
+	deadline := time.After(500 * time.Millisecond)
+	for {
+		select {
+		case raw := <-c.send:
+			var msg RegionPresenceMessage
+			if err := json.Unmarshal(raw, &msg); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if msg.Type == "region_presence" && msg.Region == id {
+				return msg
+			}
+		case <-deadline:
+			t.Fatalf("%s: expected region presence for %+v", c.userID, id)
+		}
+	}
+}
+
+func TestRegionRegistry_SubscriptionsFollowViewport(t *testing.T) {
+	h := NewHub(WithRegionSize(testRegionSize))
+	g := h.regions
+	c := newTestClient(h, "panner", 64)
+
+	g.subscribe(c, Rect{X: 300, Y: 300, Width: 400, Height: 400})
+	if _, ok := g.subscriptions[c][gridCell{x: -1, y: -1}]; ok {
+		t.Fatalf("expected no subscription outside the viewport's reach")
+	}
+	if len(g.subscriptions[c]) != 1 || len(g.regions) != 1 {
+		t.Fatalf("expected a single region, got %v", g.subscriptions[c])
+	}
+
+	// Bubbles anchored just up and left of the viewport can still hang into it.
+	g.subscribe(c, Rect{X: 1100, Y: 1050, Width: 400, Height: 400})
+	want := map[gridCell]struct{}{{x: 0, y: 0}: {}, {x: 1, y: 0}: {}, {x: 0, y: 1}: {}, {x: 1, y: 1}: {}}
+	if len(g.subscriptions[c]) != len(want) {
+		t.Fatalf("expected %v, got %v", want, g.subscriptions[c])
+	}
+	for cell := range want {
+		if _, ok := g.subscriptions[c][cell]; !ok {
+			t.Fatalf("expected subscription to %+v", cell)
+		}
+	}
+
+	g.subscribe(c, Rect{X: 5300, Y: 5300, Width: 400, Height: 400})
+	if len(g.regions) != 1 {
+		t.Fatalf("expected regions panned away from to stop, got %d", len(g.regions))
+	}
+
+	g.remove(c)
+	if len(g.regions) != 0 || len(g.subscriptions) != 0 {
+		t.Fatalf("expected empty registry, got %d regions", len(g.regions))
+	}
+}
+
+func TestRegionRegistry_ZoomedOutViewportIsCapped(t *testing.T) {
+	g := newRegionRegistry(NewHub(), testRegionSize)
+
+	cells := g.cellsSeenBy(Rect{X: 0, Y: 0, Width: 100 * testRegionSize, Height: 100 * testRegionSize})
+	if len(cells) != maxRegionSpan*maxRegionSpan {
+		t.Fatalf("expected %d regions, got %d", maxRegionSpan*maxRegionSpan, len(cells))
+	}
+}
+
+func TestHub_RegionModeRelaysTypingOnlyToRegionSubscribers(t *testing.T) {
+	h := NewHub(WithRegionSize(testRegionSize))
+	go h.Run()
+
+	author := newTestClient(h, "author", 64)
+	near := newTestClient(h, "near", 64)
+	far := newTestClient(h, "far", 64)
+	for _, c := range []*Client{author, near, far} {
+		h.Register(c)
+	}
+
+	home := RegionID{}
+	presence := readRegionPresence(t, near, home)
+	for len(presence.Users) < 2 {
+		presence = readRegionPresence(t, near, home)
+	}
+	if presence.Total != 3 {
+		t.Fatalf("expected a total of 3, got %d", presence.Total)
+	}
+
+	// far pans to a distant part of the canvas and stops watching home.
+	h.regions.subscribe(far, Rect{X: 50 * testRegionSize, Y: 50 * testRegionSize, Width: 400, Height: 400})
+	time.Sleep(20 * time.Millisecond)
+	drainChannel(near.send)
+	drainChannel(far.send)
+
+	author.handleMessage([]byte(`{"type":"typing_update","char":"h"}`))
+
+	got := readWithTimeout(near.send, 200*time.Millisecond)
+	if got == nil {
+		t.Fatalf("expected near to receive typing")
+	}
+	var msg RelayMessage
+	if err := json.Unmarshal(got, &msg); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if msg.Type != "typing_update" || msg.UserID != author.userID {
+		t.Fatalf("unexpected relay: %+v", msg)
+	}
+
+	if raw := readWithTimeout(far.send, 50*time.Millisecond); raw != nil {
+		t.Fatalf("expected far not to receive typing, got %s", raw)
+	}
+}
+
+func TestHub_RegionModeAnnouncesPopulation(t *testing.T) {
+	h := NewHub(WithRegionSize(testRegionSize))
+	go h.Run()
+
+	a := newTestClient(h, "a", 64)
+	b := newTestClient(h, "b", 64)
+	h.Register(a)
+	h.Register(b)
+
+	deadline := time.After(500 * time.Millisecond)
+	for {
+		select {
+		case raw := <-a.send:
+			var msg PopulationMessage
+			if err := json.Unmarshal(raw, &msg); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if msg.Type == "presence" {
+				t.Fatalf("expected no room-wide presence list in region mode, got %s", raw)
+			}
+			if msg.Type == "population" && msg.Total == 2 {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("expected a population of 2")
+		}
+	}
+}
+
+func TestHub_RegionModeSnapshotsTravelThroughEachWatchedRegion(t *testing.T) {
+	h := NewHub(WithRegionSize(testRegionSize))
+	go h.Run()
+
+	author := newTestClient(h, "author", 64)
+	watcher := newTestClient(h, "watcher", 64)
+	for _, c := range []*Client{author, watcher} {
+		h.Register(c)
+	}
+	home := RegionID{}
+	for len(readRegionPresence(t, watcher, home).Users) < 1 {
+	}
+
+	// The watcher also looks at an empty region next door.
+	h.regions.subscribe(watcher, Rect{X: 800, Y: 300, Width: 400, Height: 400})
+	watcher.handleMessage([]byte(`{"type":"visibility","state":"hidden"}`))
+	author.handleMessage([]byte(`{"type":"typing_update","char":"h"}`))
+	author.handleMessage([]byte(`{"type":"typing_update","char":"i"}`))
+	time.Sleep(20 * time.Millisecond)
+	drainChannel(watcher.send)
+
+	watcher.handleMessage([]byte(`{"type":"visibility","state":"visible"}`))
+	author.handleMessage([]byte(`{"type":"typing_update","char":"!"}`))
+
+	snapshots := make(map[RegionID][]Composition)
+	typed := false
+	deadline := time.After(500 * time.Millisecond)
+	for !typed || len(snapshots) < 2 {
+		select {
+		case raw := <-watcher.send:
+			var msg struct {
+				TypingSnapshotMessage
+				Char string `json:"char"`
+			}
+			if err := json.Unmarshal(raw, &msg); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			switch msg.Type {
+			case "typing_snapshot":
+				if msg.Region == nil {
+					t.Fatalf("expected region snapshots in region mode, got %s", raw)
+				}
+				snapshots[*msg.Region] = msg.Compositions
+			case "typing_update":
+				// The keystroke typed after the snapshot follows the home
+				// region's part of it.
+				want := []Composition{{UserID: author.userID, Text: "hi"}}
+				if got, ok := snapshots[home]; !ok || len(got) != 1 || got[0] != want[0] {
+					t.Fatalf("expected snapshot %+v before the next keystroke, got %+v", want, snapshots)
+				}
+				if msg.Char != "!" {
+					t.Fatalf("expected the keystroke after the snapshot, got %q", msg.Char)
+				}
+				typed = true
+			}
+		case <-deadline:
+			t.Fatalf("expected snapshots and a keystroke, got %+v (typed %v)", snapshots, typed)
+		}
+	}
+
+	if next := snapshots[RegionID{X: 1}]; len(next) != 0 {
+		t.Fatalf("expected an empty snapshot for the region next door, got %+v", next)
+	}
+}
+
+func TestHub_RegionViewportSentOnConnectOutlivesTheDefault(t *testing.T) {
+	h := NewHub(WithRegionSize(testRegionSize))
+	go h.Run()
+
+	// The hub's default mustn't replace a viewport that got in first.
+	early := newTestClient(h, "early", 64)
+	h.regions.subscribe(early, Rect{X: 5300, Y: 5300, Width: 400, Height: 400})
+	h.regions.subscribeDefault(early)
+	if _, ok := h.regions.subscriptions[early][gridCell{x: 5, y: 5}]; !ok || len(h.regions.subscriptions[early]) != 1 {
+		t.Fatalf("expected the default to leave an existing viewport alone, got %v", h.regions.subscriptions[early])
+	}
+	h.regions.remove(early)
+
+	c := newTestClient(h, "quick", 64)
+	h.Register(c)
+	c.handleMessage([]byte(`{"type":"viewport","x":5300,"y":5300,"width":400,"height":400}`))
+
+	var subscribed map[gridCell]struct{}
+	h.do(func() {
+		h.regions.mu.RLock()
+		subscribed = h.regions.subscriptions[c]
+		h.regions.mu.RUnlock()
+	})
+	if _, ok := subscribed[gridCell{x: 5, y: 5}]; !ok || len(subscribed) != 1 {
+		t.Fatalf("expected the client's own viewport to stand, got %v", subscribed)
+	}
+}
@@ -0,0 +1,199 @@
+package realtime
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxReportReasonRunes bounds the reason a client gives for a report.
+	maxReportReasonRunes = 200
+
+	// maxReportKeys bounds the reporters and targets tracked before those
+	// whose reports have all aged out are swept.
+	maxReportKeys = 4096
+)
+
+var (
+	errReport          = errors.New("a report needs another user's id and a reason of at most 200 characters")
+	errReportTarget    = errors.New("that user is not connected")
+	errReportRateLimit = errors.New("too many reports, try again later")
+)
+
+// ReportLimits tunes user reports. Once Threshold distinct reporters report a
+// user within Window, the user is muted for MuteFor. Each reporter may file
+// at most PerReporter reports per Window.
+type ReportLimits struct {
+	Threshold   int
+	Window      time.Duration
+	PerReporter int
+	MuteFor     time.Duration
+	// Exempt addresses are shared by too many people to hold reports
+	// against or mute.
+	Exempt []netip.Prefix
+}
+
+// DefaultReportLimits returns the limits a hub uses unless configured.
+func DefaultReportLimits() ReportLimits {
+	return ReportLimits{
+		Threshold:   3,
+		Window:      10 * time.Minute,
+		PerReporter: 5,
+		MuteFor:     10 * time.Minute,
+	}
+}
+
+type report struct {
+	reporter string
+	reason   string
+	at       time.Time
+}
+
+// reportTracker counts reports per target in a sliding window.
+type reportTracker struct {
+	limits ReportLimits
+	now    func() time.Time
+
+	mu      sync.Mutex
+	reports map[string][]report    // by target, keyed like reporters
+	filed   map[string][]time.Time // by reporter
+}
+
+func newReportTracker(limits ReportLimits) *reportTracker {
+	return &reportTracker{
+		limits:  limits,
+		now:     time.Now,
+		reports: make(map[string][]report),
+		filed:   make(map[string][]time.Time),
+	}
+}
+
+// add files a report by reporter against target. Once the target has
+// reports from enough distinct reporters it returns them and starts over, so
+// each crossing of the threshold is reported once.
+func (t *reportTracker) add(reporter, target, reason string) ([]report, error) {
+	now := t.now()
+	since := now.Add(-t.limits.Window)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.reports)+len(t.filed) >= maxReportKeys {
+		t.sweepLocked(since)
+	}
+
+	filed := slices.DeleteFunc(t.filed[reporter], func(at time.Time) bool { return at.Before(since) })
+	if len(filed) >= t.limits.PerReporter {
+		t.filed[reporter] = filed
+		return nil, errReportRateLimit
+	}
+	t.filed[reporter] = append(filed, now)
+
+	reports := slices.DeleteFunc(t.reports[target], func(r report) bool { return r.at.Before(since) })
+	// A reporter counts once per target; a repeat only refreshes the reason.
+	reports = slices.DeleteFunc(reports, func(r report) bool { return r.reporter == reporter })
+	reports = append(reports, report{reporter: reporter, reason: reason, at: now})
+
+	if len(reports) < t.limits.Threshold {
+		t.reports[target] = reports
+		return nil, nil
+	}
+
+	delete(t.reports, target)
+	return reports, nil
+}
+
+// sweepLocked drops reporters and targets with nothing filed since since.
+func (t *reportTracker) sweepLocked(since time.Time) {
+	for key, filed := range t.filed {
+		if len(filed) == 0 || filed[len(filed)-1].Before(since) {
+			delete(t.filed, key)
+		}
+	}
+	for key, reports := range t.reports {
+		if len(reports) == 0 || reports[len(reports)-1].at.Before(since) {
+			delete(t.reports, key)
+		}
+	}
+}
+
+// reporterKey is who a report counts as coming from. Reporters are told
+// apart by address when there is one, so one person reconnecting under new
+// IDs can't reach the threshold alone.
+func reporterKey(c *Client) string {
+	if ip := c.peer.IP(); ip != "" {
+		return subjectIP + ip
+	}
+	return subjectUser + c.userID
+}
+
+// targetKey is who a report is held against. Targets are told apart by
+// identity key, or else by an address that isn't exempt, so reports survive
+// the target reconnecting under a new ID without landing on everyone behind
+// a shared address.
+func (t *reportTracker) targetKey(c *Client) string {
+	if subject, ok := c.peer.sanctionSubject(t.limits.Exempt); ok {
+		return subject
+	}
+	return subjectUser + c.userID
+}
+
+func validReportReason(reason string) bool {
+	return reason != "" && utf8.RuneCountInString(reason) <= maxReportReasonRunes
+}
+
+// report files reporter's report against the user with targetID and mutes the
+// target once enough people have reported it. Like an abuse ban, the mute
+// also covers the target's identity key, or else its address unless that is
+// exempt, so reconnecting doesn't lift it.
+func (h *Hub) report(reporter *Client, targetID, reason string) error {
+	var target *Client
+	h.do(func() { target = h.client(targetID) })
+	if target == nil {
+		return errReportTarget
+	}
+
+	key := h.reports.targetKey(target)
+	reports, err := h.reports.add(reporterKey(reporter), key, reason)
+	if err != nil || reports == nil {
+		return err
+	}
+
+	subjects := []string{subjectUser + targetID}
+	if subject, ok := target.peer.sanctionSubject(h.reports.limits.Exempt); ok {
+		subjects = append(subjects, subject)
+	}
+	now := time.Now()
+	for _, subject := range subjects {
+		err = h.moderation.Add(Sanction{
+			Kind:      SanctionMute,
+			Subject:   subject,
+			Reason:    fmt.Sprintf("automatic: reported by %d users", len(reports)),
+			CreatedAt: now,
+			ExpiresAt: now.Add(h.reports.limits.MuteFor),
+		})
+		if err != nil {
+			log.Printf("Error saving report mute on %s: %v", subject, err)
+		}
+	}
+
+	reasons := make([]string, len(reports))
+	for i, r := range reports {
+		reasons[i] = r.reason
+	}
+	log.Printf("Muted %s (%s) after %d reports: %s", targetID, key, len(reports), strings.Join(reasons, "; "))
+	h.events.emit(AdminEvent{
+		Type:      EventAutoMute,
+		UserID:    targetID,
+		Reporters: len(reports),
+		Reasons:   reasons,
+	})
+	return nil
+}
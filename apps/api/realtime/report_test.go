@@ -0,0 +1,250 @@
+package realtime
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportTracker_NeedsDistinctReportersWithinTheWindow(t *testing.T) {
+	tracker := newReportTracker(ReportLimits{Threshold: 3, Window: time.Minute, PerReporter: 10, MuteFor: time.Minute})
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+
+	for _, reporter := range []string{"a", "a", "b"} {
+		if reports, err := tracker.add(reporter, "target", "spam"); err != nil || reports != nil {
+			t.Fatalf("report by %s: reports=%v err=%v, want neither", reporter, reports, err)
+		}
+	}
+
+	// a's first report has aged out by the time c reports.
+	now = now.Add(time.Minute + time.Second)
+	if reports, err := tracker.add("c", "target", "spam"); err != nil || reports != nil {
+		t.Fatalf("expected a and b to have expired, got reports=%v err=%v", reports, err)
+	}
+
+	tracker.add("d", "target", "rude")
+	reports, err := tracker.add("e", "target", "rude")
+	if err != nil || len(reports) != 3 {
+		t.Fatalf("expected the third distinct reporter to cross the threshold, got %v (err %v)", reports, err)
+	}
+
+	if reports, _ := tracker.add("f", "target", "rude"); reports != nil {
+		t.Fatal("expected the count to start over after crossing the threshold")
+	}
+}
+
+func TestReportTracker_LimitsReportsPerReporter(t *testing.T) {
+	tracker := newReportTracker(ReportLimits{Threshold: 100, Window: time.Minute, PerReporter: 2, MuteFor: time.Minute})
+
+	for i := range 2 {
+		if _, err := tracker.add("spammer", fmt.Sprintf("target-%d", i), "spam"); err != nil {
+			t.Fatalf("report %d: %v", i, err)
+		}
+	}
+	if _, err := tracker.add("spammer", "target-2", "spam"); err != errReportRateLimit {
+		t.Fatalf("expected the third report to be rate limited, got %v", err)
+	}
+}
+
+func TestHub_ReportsMuteTheTargetAndNotifyAdmins(t *testing.T) {
+	h := NewHub(WithReportLimits(ReportLimits{Threshold: 2, Window: time.Minute, PerReporter: 5, MuteFor: time.Minute}))
+	go h.Run()
+
+	target := newTestClient(h, "target", 10)
+	h.Register(target)
+
+	var reporters []*Client
+	for i := range 3 {
+		c := newTestClient(h, fmt.Sprintf("reporter-%d", i), 10)
+		c.peer = Peer{RemoteAddr: fmt.Sprintf("192.0.2.%d:4000", i%2+1)}
+		h.Register(c)
+		reporters = append(reporters, c)
+	}
+
+	reporters[0].handleMessage([]byte(`{"type":"report","userId":"target","reason":"spam"}`))
+	// Same address as the first reporter, so it doesn't count again.
+	reporters[2].handleMessage([]byte(`{"type":"report","userId":"target","reason":"spam again"}`))
+	if _, ok := target.sanction(SanctionMute); ok {
+		t.Fatal("expected reports from one address to count once")
+	}
+
+	reporters[1].handleMessage([]byte(`{"type":"report","userId":"target","reason":"slurs"}`))
+	if _, ok := target.sanction(SanctionMute); !ok {
+		t.Fatal("expected the target to be muted")
+	}
+
+	s := NewAdminServer(testAdminToken, h.moderation, NewIPList(), map[string]*Hub{"default": h})
+	rec := adminRequest(t, s, http.MethodGet, "/admin/rooms/default/events", "")
+	var events []AdminEvent
+	if err := json.Unmarshal(rec.Body.Bytes(), &events); err != nil {
+		t.Fatalf("failed to decode events: %v", err)
+	}
+	if len(events) != 1 || events[0].Type != EventAutoMute || events[0].UserID != "target" || events[0].Reporters != 2 {
+		t.Fatalf("expected one auto_mute event, got %+v", events)
+	}
+}
+
+func TestHub_ReportedUsersStayMutedAfterReconnecting(t *testing.T) {
+	h := NewHub(WithReportLimits(ReportLimits{Threshold: 2, Window: time.Minute, PerReporter: 5, MuteFor: time.Minute}))
+	go h.Run()
+
+	targetPeer := Peer{RemoteAddr: "203.0.113.9:5000", IdentityKey: "browser-1"}
+	connect := func(id string) *Client {
+		c := newTestClient(h, id, 10)
+		c.peer = targetPeer
+		h.Register(c)
+		return c
+	}
+
+	var reporters []*Client
+	for i := range 2 {
+		c := newTestClient(h, fmt.Sprintf("reporter-%d", i), 10)
+		c.peer = Peer{RemoteAddr: fmt.Sprintf("192.0.2.%d:4000", i+1)}
+		h.Register(c)
+		reporters = append(reporters, c)
+	}
+
+	// Reports against one connection still count after it reconnects.
+	connect("target-1")
+	reporters[0].handleMessage([]byte(`{"type":"report","userId":"target-1","reason":"spam"}`))
+	h.Kick("target-1")
+
+	target := connect("target-2")
+	if _, ok := target.sanction(SanctionMute); ok {
+		t.Fatal("expected one report not to mute")
+	}
+	reporters[1].handleMessage([]byte(`{"type":"report","userId":"target-2","reason":"spam"}`))
+	if _, ok := target.sanction(SanctionMute); !ok {
+		t.Fatal("expected reports across reconnects to add up to a mute")
+	}
+
+	h.Kick("target-2")
+	if _, ok := connect("target-3").sanction(SanctionMute); !ok {
+		t.Fatal("expected the mute to outlast a reconnect")
+	}
+}
+
+func TestHub_ReportMutesSpareOthersOnTheTargetsAddress(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		target, neighbor Peer
+	}{
+		{
+			name:     "by identity",
+			target:   Peer{RemoteAddr: "203.0.113.9:5000", IdentityKey: "browser-1"},
+			neighbor: Peer{RemoteAddr: "203.0.113.9:5001", IdentityKey: "browser-2"},
+		},
+		{
+			name:     "exempt address",
+			target:   Peer{RemoteAddr: "198.51.100.9:5000"},
+			neighbor: Peer{RemoteAddr: "198.51.100.9:5001"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHub(WithReportLimits(ReportLimits{
+				Threshold:   2,
+				Window:      time.Minute,
+				PerReporter: 5,
+				MuteFor:     time.Minute,
+				Exempt:      []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")},
+			}))
+			go h.Run()
+
+			target := newTestClient(h, "target", 10)
+			target.peer = tc.target
+			neighbor := newTestClient(h, "neighbor", 10)
+			neighbor.peer = tc.neighbor
+			h.Register(target)
+			h.Register(neighbor)
+
+			for i := range 2 {
+				c := newTestClient(h, fmt.Sprintf("reporter-%d", i), 10)
+				c.peer = Peer{RemoteAddr: fmt.Sprintf("192.0.2.%d:4000", i+1)}
+				h.Register(c)
+				c.handleMessage([]byte(`{"type":"report","userId":"target","reason":"spam"}`))
+			}
+
+			if _, ok := target.sanction(SanctionMute); !ok {
+				t.Fatal("expected the reported user to be muted")
+			}
+			if s, ok := neighbor.sanction(SanctionMute); ok {
+				t.Fatalf("expected a user sharing the address not to be muted, got %+v", s)
+			}
+			for _, s := range h.moderation.List() {
+				if strings.HasPrefix(s.Subject, subjectIP) {
+					t.Fatalf("expected no mute on the shared address, got %+v", s)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_RejectsInvalidReports(t *testing.T) {
+	_, sender, _ := setupHubWithClients(t)
+	drainChannel(sender.send)
+
+	for _, msg := range []string{
+		`{"type":"report","userId":"sender-1","reason":"me"}`,
+		`{"type":"report","userId":"receiver-1","reason":"   "}`,
+		`{"type":"report","userId":"nobody","reason":"spam"}`,
+	} {
+		sender.handleMessage([]byte(msg))
+
+		var reply ErrorMessage
+		if err := json.Unmarshal(readWithTimeout(sender.send, 200*time.Millisecond), &reply); err != nil {
+			t.Fatalf("%s: failed to decode reply: %v", msg, err)
+		}
+		if reply.Code != "invalid_report" {
+			t.Fatalf("%s: expected invalid_report, got %+v", msg, reply)
+		}
+	}
+}
+
+func TestAdminServer_StreamsEvents(t *testing.T) {
+	h := NewHub()
+	h.events.emit(AdminEvent{Type: EventAutoMute, UserID: "earlier"})
+
+	srv := httptest.NewServer(NewAdminServer(testAdminToken, h.moderation, NewIPList(), map[string]*Hub{"default": h}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/admin/rooms/default/events", nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func() AdminEvent {
+		t.Helper()
+		for lines.Scan() {
+			if data, ok := strings.CutPrefix(lines.Text(), "data: "); ok {
+				var e AdminEvent
+				if err := json.Unmarshal([]byte(data), &e); err != nil {
+					t.Fatalf("decode event: %v", err)
+				}
+				return e
+			}
+		}
+		t.Fatalf("stream ended: %v", lines.Err())
+		return AdminEvent{}
+	}
+
+	if e := next(); e.UserID != "earlier" || e.Seq != 1 {
+		t.Fatalf("expected the backlog first, got %+v", e)
+	}
+
+	h.events.emit(AdminEvent{Type: EventAutoMute, UserID: "later"})
+	if e := next(); e.UserID != "later" || e.Seq != 2 {
+		t.Fatalf("expected the new event, got %+v", e)
+	}
+}
@@ -0,0 +1,65 @@
+package realtime
+
+type membershipChange struct {
+	client *Client
+	join   bool
+}
+
+// shard owns delivery to a subset of the hub's clients. Membership changes
+// come from the hub loop and broadcasts straight from senders, so shards fan
+// a broadcast out in parallel with each other.
+type shard struct {
+	clients    map[*Client]bool
+	membership chan membershipChange
+	broadcast  chan broadcastRequest
+}
+
+func newShards(n int) []*shard {
+	shards := make([]*shard, n)
+	for i := range shards {
+		shards[i] = &shard{
+			clients:    make(map[*Client]bool),
+			membership: make(chan membershipChange, 64),
+			broadcast:  make(chan broadcastRequest, 256),
+		}
+	}
+
+	return shards
+}
+
+func (s *shard) run() {
+	for {
+		select {
+		case change := <-s.membership:
+			s.apply(change)
+
+		case req := <-s.broadcast:
+			// A join queued before this broadcast was sent must see it, but
+			// select doesn't prefer one ready channel over another.
+			s.applyPending()
+
+			for client := range s.clients {
+				req.deliverTo(client)
+			}
+		}
+	}
+}
+
+func (s *shard) apply(change membershipChange) {
+	if change.join {
+		s.clients[change.client] = true
+	} else {
+		delete(s.clients, change.client)
+	}
+}
+
+func (s *shard) applyPending() {
+	for {
+		select {
+		case change := <-s.membership:
+			s.apply(change)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package realtime
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"unicode/utf16"
+)
+
+// Canvas and bubble dimensions mirror the web client's defaults in
+// apps/web/lib/spatial.ts and ephemeral-app.tsx.
+const (
+	canvasWidth   = 1200
+	canvasHeight  = 720
+	canvasPadding = 80
+
+	bubbleWidth  = 240
+	bubbleHeight = 136
+
+	// maxViewportSpan bounds reported viewports to something a screen could
+	// plausibly show, even zoomed out, and maxCanvasCoord keeps coordinates
+	// well inside the range grid cells can index.
+	maxViewportSpan = 1 << 20
+	maxCanvasCoord  = 1 << 30
+)
+
+// defaultViewport is what a client is assumed to see until it reports a
+// viewport of its own: the whole default canvas.
+var defaultViewport = Rect{Width: canvasWidth, Height: canvasHeight}
+
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+type Rect struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+func (r Rect) Intersects(o Rect) bool {
+	return !(r.X+r.Width <= o.X ||
+		o.X+o.Width <= r.X ||
+		r.Y+r.Height <= o.Y ||
+		o.Y+o.Height <= r.Y)
+}
+
+func validViewport(r Rect) bool {
+	for _, v := range []float64{r.X, r.Y, r.Width, r.Height} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+
+	return math.Abs(r.X) <= maxCanvasCoord && math.Abs(r.Y) <= maxCanvasCoord &&
+		r.Width > 0 && r.Height > 0 && r.Width <= maxViewportSpan && r.Height <= maxViewportSpan
+}
+
+func validCanvasPoint(p Point) bool {
+	return !math.IsNaN(p.X) && !math.IsNaN(p.Y) &&
+		math.Abs(p.X) <= maxCanvasCoord && math.Abs(p.Y) <= maxCanvasCoord
+}
+
+// clampToCanvas keeps a bubble anchored at p fully on the default canvas.
+func clampToCanvas(p Point) Point {
+	return Point{
+		X: math.Min(math.Max(p.X, 0), canvasWidth-bubbleWidth),
+		Y: math.Min(math.Max(p.Y, 0), canvasHeight-bubbleHeight),
+	}
+}
+
+// within reports whether a and b are at most radius apart.
+func within(a, b Point, radius float64) bool {
+	dx, dy := a.X-b.X, a.Y-b.Y
+	return dx*dx+dy*dy <= radius*radius
+}
+
+// bubbleRect is the area a user's composition occupies on the canvas.
+func bubbleRect(p Point) Rect {
+	return Rect{X: p.X, Y: p.Y, Width: bubbleWidth, Height: bubbleHeight}
+}
+
+// pointRect is a unit square at p, so a point can be matched against viewports
+// with Intersects.
+func pointRect(p Point) Rect {
+	return Rect{X: p.X, Y: p.Y, Width: 1, Height: 1}
+}
+
+// hashString is the xmur3-style hash from spatial.ts, over UTF-16 code units
+// like String.prototype.charCodeAt.
+func hashString(s string) uint32 {
+	units := utf16.Encode([]rune(s))
+
+	h := uint32(1779033703) ^ uint32(len(units))
+	for _, u := range units {
+		h = (h ^ uint32(u)) * 3432918353
+		h = h<<13 | h>>19
+	}
+	h = (h ^ h>>16) * 2246822507
+	h = (h ^ h>>13) * 3266489909
+
+	return h ^ h>>16
+}
+
+// mulberry32 is the PRNG from spatial.ts returning floats in [0, 1).
+func mulberry32(seed uint32) func() float64 {
+	a := seed
+	return func() float64 {
+		a += 0x6d2b79f5
+		t := (a ^ a>>15) * (1 | a)
+		t = (t + (t^t>>7)*(61|t)) ^ t
+		return float64(t^t>>14) / 4294967296
+	}
+}
+
+func randomSeed() uint32 {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint32(b[:])
+}
+
+// formatSeed renders seed the way JavaScript template literals render numbers.
+func formatSeed(seed uint32) string {
+	return strconv.FormatUint(uint64(seed), 10)
+}
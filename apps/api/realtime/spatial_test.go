@@ -0,0 +1,62 @@
+package realtime
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHashStringMatchesWebClient(t *testing.T) {
+	// Reference values from hashString/mulberry32 in apps/web/lib/spatial.ts.
+	if got := hashString("42:user-1"); got != 1579521638 {
+		t.Fatalf("expected hash 1579521638, got %d", got)
+	}
+
+	rand := mulberry32(1579521638)
+	for _, want := range []float64{0.1722546552773565, 0.8734917622059584} {
+		if got := rand(); math.Abs(got-want) > 1e-15 {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestRectIntersects(t *testing.T) {
+	base := Rect{X: 0, Y: 0, Width: 100, Height: 100}
+
+	cases := []struct {
+		name  string
+		other Rect
+		want  bool
+	}{
+		{"overlapping", Rect{X: 50, Y: 50, Width: 100, Height: 100}, true},
+		{"contained", Rect{X: 10, Y: 10, Width: 10, Height: 10}, true},
+		{"touching edge", Rect{X: 100, Y: 0, Width: 10, Height: 10}, false},
+		{"far away", Rect{X: 500, Y: 500, Width: 10, Height: 10}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := base.Intersects(tc.other); got != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestValidViewport(t *testing.T) {
+	if !validViewport(Rect{X: -100, Y: 20, Width: 1280, Height: 720}) {
+		t.Fatal("expected ordinary viewport to be valid")
+	}
+
+	for _, r := range []Rect{
+		{Width: 0, Height: 100},
+		{Width: 100, Height: -1},
+		{Width: math.Inf(1), Height: 100},
+		{X: math.NaN(), Width: 100, Height: 100},
+		{X: 1 << 40, Width: 100, Height: 100},
+		{Width: 1 << 21, Height: 100},
+	} {
+		if validViewport(r) {
+			t.Fatalf("expected %+v to be rejected", r)
+		}
+	}
+}
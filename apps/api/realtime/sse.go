@@ -0,0 +1,269 @@
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// sendOrderWait is how long a posted message numbered ahead of its session's
+// next one waits for the messages before it, in case they were lost.
+const sendOrderWait = time.Second
+
+var errStaleSend = errors.New("message already handled or skipped")
+
+// SSEServer is the fallback transport for networks that break WebSocket
+// upgrades. Clients receive hub messages over a long-lived Server-Sent Events
+// stream and send their own messages as individual POST requests, identified by
+// the session ID announced as the first event on the stream.
+type SSEServer struct {
+	hub *Hub
+
+	mu       sync.Mutex
+	sessions map[string]*sseSession
+}
+
+// sseSession is one stream's client and the state that puts its posted
+// messages in order. Browsers may send POSTs in parallel, so messages are
+// handled one at a time, and those numbered with a "seq" query parameter are
+// handled in that order.
+type sseSession struct {
+	client *Client
+
+	// handleMu is held around handleMessage, standing in for a WebSocket
+	// client's single read loop.
+	handleMu sync.Mutex
+
+	mu    sync.Mutex
+	next  uint64
+	turns map[uint64]chan struct{}
+}
+
+func NewSSEServer(hub *Hub) *SSEServer {
+	return &SSEServer{
+		hub:      hub,
+		sessions: make(map[string]*sseSession),
+	}
+}
+
+// ServeEvents streams hub messages to the client until the request is
+// cancelled or the hub closes the client's send buffer.
+func (s *SSEServer) ServeEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	client := newClient(s.hub)
+	client.peer = PeerFromRequest(r)
+	sessionID := uuid.New().String()
+
+	hello, err := json.Marshal(SessionMessage{Type: "session", SessionID: sessionID})
+	if err != nil {
+		log.Printf("Error marshaling SSE session for client %s: %v", client.userID, err)
+		return
+	}
+	if err := writeEvent(w, rc, hello); err != nil {
+		return
+	}
+
+	s.addSession(sessionID, client)
+	s.hub.Register(client)
+	defer func() {
+		s.removeSession(sessionID)
+		s.hub.unregister <- client
+	}()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message, ok := <-client.send:
+			if !ok {
+				return
+			}
+
+			if err := writeEvent(w, rc, message); err != nil {
+				return
+			}
+
+		case <-client.coalescedReady:
+			for _, message := range client.takeCoalesced() {
+				if err := writeEvent(w, rc, message); err != nil {
+					return
+				}
+			}
+
+		case <-ticker.C:
+			// Comment lines keep proxies from timing out an idle stream.
+			rc.SetWriteDeadline(time.Now().Add(writeWait))
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// ServeSend accepts a single client message for the session named by the
+// "session" query parameter and handles it exactly as if it had arrived over a
+// WebSocket. Clients that may have several posts in flight number them from
+// zero with the "seq" query parameter so they are handled in order.
+func (s *SSEServer) ServeSend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := s.session(r.URL.Query().Get("session"))
+	if session == nil {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+
+	seq, sequenced := uint64(0), r.URL.Query().Has("seq")
+	if sequenced {
+		var err error
+		if seq, err = strconv.ParseUint(r.URL.Query().Get("seq"), 10, 64); err != nil {
+			http.Error(w, "invalid seq", http.StatusBadRequest)
+			return
+		}
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageSize))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "message too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	if sequenced {
+		if err := session.waitTurn(seq); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		defer session.endTurn(seq)
+	}
+
+	session.handleMu.Lock()
+	session.client.handleMessage(body)
+	session.handleMu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// waitTurn blocks until every message numbered before seq has been handled,
+// or until sendOrderWait passes, after which the missing ones are skipped.
+func (s *sseSession) waitTurn(seq uint64) error {
+	s.mu.Lock()
+	if _, waiting := s.turns[seq]; seq < s.next || waiting {
+		s.mu.Unlock()
+		return errStaleSend
+	}
+	if seq == s.next {
+		s.mu.Unlock()
+		return nil
+	}
+	turn := make(chan struct{})
+	s.turns[seq] = turn
+	s.mu.Unlock()
+
+	timer := time.NewTimer(sendOrderWait)
+	defer timer.Stop()
+
+	select {
+	case <-turn:
+		return nil
+	case <-timer.C:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.turns, seq)
+	select {
+	case <-turn:
+		// Our turn came while we took the lock.
+		return nil
+	default:
+	}
+	if seq < s.next {
+		return errStaleSend
+	}
+	s.next = seq
+	return nil
+}
+
+// endTurn lets the message after seq go.
+func (s *sseSession) endTurn(seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.next = max(s.next, seq+1)
+	if turn, ok := s.turns[s.next]; ok {
+		delete(s.turns, s.next)
+		close(turn)
+	}
+}
+
+func (s *SSEServer) addSession(id string, c *Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[id] = &sseSession{client: c, turns: make(map[uint64]chan struct{})}
+}
+
+func (s *SSEServer) removeSession(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+func (s *SSEServer) session(id string) *sseSession {
+	if id == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[id]
+}
+
+// writeEvent writes data as a single SSE event. Hub messages are compact JSON,
+// so they never contain the newlines that would split an event.
+func writeEvent(w io.Writer, rc *http.ResponseController, data []byte) error {
+	rc.SetWriteDeadline(time.Now().Add(writeWait))
+	if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+
+	return rc.Flush()
+}
@@ -0,0 +1,239 @@
+package realtime
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type sseStream struct {
+	resp   *http.Response
+	events chan []byte
+}
+
+func openSSEStream(t *testing.T, url string) *sseStream {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("open event stream: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected text/event-stream content type, got %q", ct)
+	}
+
+	events := make(chan []byte, 16)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				events <- []byte(data)
+			}
+		}
+		close(events)
+	}()
+
+	return &sseStream{resp: resp, events: events}
+}
+
+func newSSETestServer(t *testing.T) (*Hub, *httptest.Server) {
+	t.Helper()
+
+	h := NewHub()
+	go h.Run()
+
+	s := NewSSEServer(h)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", s.ServeEvents)
+	mux.HandleFunc("/send", s.ServeSend)
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return h, srv
+}
+
+func TestSSEServer_StreamsSessionThenPresence(t *testing.T) {
+	_, srv := newSSETestServer(t)
+
+	stream := openSSEStream(t, srv.URL+"/events")
+
+	raw := readWithTimeout(stream.events, 500*time.Millisecond)
+	if raw == nil {
+		t.Fatalf("expected session event, got none")
+	}
+
+	var session SessionMessage
+	if err := json.Unmarshal(raw, &session); err != nil {
+		t.Fatalf("unmarshal session: %v", err)
+	}
+	if session.Type != "session" || session.SessionID == "" {
+		t.Fatalf("unexpected session event: %+v", session)
+	}
+
+	raw = readWithTimeout(stream.events, 500*time.Millisecond)
+	if raw == nil {
+		t.Fatalf("expected presence event, got none")
+	}
+
+	var presence PresenceMessage
+	if err := json.Unmarshal(raw, &presence); err != nil {
+		t.Fatalf("unmarshal presence: %v", err)
+	}
+	if presence.Type != "presence" || len(presence.Users) != 0 {
+		t.Fatalf("expected empty presence, got %+v", presence)
+	}
+}
+
+func TestSSEServer_PostedTypingRelaysToOtherClients(t *testing.T) {
+	h, srv := newSSETestServer(t)
+
+	receiver := newTestClient(h, "receiver", 10)
+	h.Register(receiver)
+	drainChannel(receiver.send)
+
+	stream := openSSEStream(t, srv.URL+"/events")
+
+	var session SessionMessage
+	if err := json.Unmarshal(readWithTimeout(stream.events, 500*time.Millisecond), &session); err != nil {
+		t.Fatalf("unmarshal session: %v", err)
+	}
+
+	// Presence announcing the SSE client to the existing receiver.
+	if raw := readWithTimeout(receiver.send, 200*time.Millisecond); raw == nil {
+		t.Fatalf("expected presence update for receiver, got none")
+	}
+
+	resp, err := http.Post(
+		srv.URL+"/send?session="+session.SessionID,
+		"application/json",
+		strings.NewReader(`{"type":"typing_update","char":"s"}`),
+	)
+	if err != nil {
+		t.Fatalf("post typing update: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", resp.StatusCode)
+	}
+
+	raw := readWithTimeout(receiver.send, 200*time.Millisecond)
+	if raw == nil {
+		t.Fatalf("expected relayed typing update, got none")
+	}
+
+	var msg RelayMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		t.Fatalf("unmarshal relay: %v", err)
+	}
+	if msg.Type != "typing_update" || msg.Char != "s" || msg.UserID == "" {
+		t.Fatalf("unexpected relay: %+v", msg)
+	}
+}
+
+func TestSSEServer_SendRejectsUnknownSession(t *testing.T) {
+	_, srv := newSSETestServer(t)
+
+	resp, err := http.Post(srv.URL+"/send?session=missing", "application/json", strings.NewReader(`{"type":"typing_clear"}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestSSEServer_UnregistersWhenStreamCloses(t *testing.T) {
+	h, srv := newSSETestServer(t)
+
+	observer := newTestClient(h, "observer", 10)
+	h.Register(observer)
+	drainChannel(observer.send)
+
+	stream := openSSEStream(t, srv.URL+"/events")
+	readWithTimeout(stream.events, 500*time.Millisecond)
+
+	if raw := readWithTimeout(observer.send, 200*time.Millisecond); raw == nil {
+		t.Fatalf("expected presence after SSE client joined, got none")
+	}
+
+	stream.resp.Body.Close()
+
+	raw := readWithTimeout(observer.send, 500*time.Millisecond)
+	if raw == nil {
+		t.Fatalf("expected presence after SSE client left, got none")
+	}
+
+	var presence PresenceMessage
+	if err := json.Unmarshal(raw, &presence); err != nil {
+		t.Fatalf("unmarshal presence: %v", err)
+	}
+	if len(presence.Users) != 0 {
+		t.Fatalf("expected empty presence after SSE client left, got %+v", presence.Users)
+	}
+}
+
+func TestSSEServer_SequencedPostsApplyInOrder(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	s := NewSSEServer(h)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", s.ServeEvents)
+	mux.HandleFunc("/send", s.ServeSend)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	stream := openSSEStream(t, srv.URL+"/events")
+	var session SessionMessage
+	if err := json.Unmarshal(readWithTimeout(stream.events, 500*time.Millisecond), &session); err != nil {
+		t.Fatalf("unmarshal session: %v", err)
+	}
+
+	post := func(seq int, char string) int {
+		resp, err := http.Post(
+			fmt.Sprintf("%s/send?session=%s&seq=%d", srv.URL, session.SessionID, seq),
+			"application/json",
+			strings.NewReader(`{"type":"typing_update","char":"`+char+`"}`),
+		)
+		if err != nil {
+			t.Errorf("post %d: %v", seq, err)
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Post the keystrokes in parallel, the later ones first.
+	const text = "hello"
+	var wg sync.WaitGroup
+	for i := len(text) - 1; i >= 0; i-- {
+		wg.Go(func() {
+			if code := post(i, text[i:i+1]); code != http.StatusNoContent {
+				t.Errorf("post %d status = %d, want %d", i, code, http.StatusNoContent)
+			}
+		})
+	}
+	wg.Wait()
+
+	if got := s.session(session.SessionID).client.Composition(); got != text {
+		t.Fatalf("composition = %q, want %q", got, text)
+	}
+
+	if code := post(2, "x"); code != http.StatusConflict {
+		t.Fatalf("replayed post status = %d, want %d", code, http.StatusConflict)
+	}
+}
@@ -0,0 +1,40 @@
+package realtime
+
+import "time"
+
+// Presence statuses, from most to least present.
+const (
+	StatusActive = "active"
+	StatusIdle   = "idle"
+	StatusAway   = "away"
+)
+
+const (
+	defaultIdleAfter = time.Minute
+	defaultAwayAfter = 5 * time.Minute
+
+	// maxStatusTick bounds how stale a status can get before the hub notices
+	// a client went quiet.
+	maxStatusTick = 15 * time.Second
+	minStatusTick = 10 * time.Millisecond
+)
+
+// statusAt works out a client's status from how long it has been quiet and
+// whether its tab is hidden. A hidden tab is away straight away.
+func statusAt(now, lastActive time.Time, hidden bool, idleAfter, awayAfter time.Duration) string {
+	quiet := now.Sub(lastActive)
+
+	switch {
+	case hidden || quiet >= awayAfter:
+		return StatusAway
+	case quiet >= idleAfter:
+		return StatusIdle
+	default:
+		return StatusActive
+	}
+}
+
+// statusTick is how often the hub re-checks statuses for the given thresholds.
+func statusTick(idleAfter, awayAfter time.Duration) time.Duration {
+	return min(max(min(idleAfter, awayAfter)/4, minStatusTick), maxStatusTick)
+}
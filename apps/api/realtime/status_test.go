@@ -0,0 +1,139 @@
+package realtime
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStatusAt(t *testing.T) {
+	now := time.Now()
+	idle, away := time.Minute, 5*time.Minute
+
+	tests := []struct {
+		name   string
+		quiet  time.Duration
+		hidden bool
+		want   string
+	}{
+		{name: "recent activity", quiet: time.Second, want: StatusActive},
+		{name: "quiet past idle", quiet: 2 * time.Minute, want: StatusIdle},
+		{name: "quiet past away", quiet: 10 * time.Minute, want: StatusAway},
+		{name: "hidden tab", quiet: time.Second, hidden: true, want: StatusAway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := statusAt(now, now.Add(-tt.quiet), tt.hidden, idle, away); got != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+// waitForStatus polls presence on observer until subject shows status.
+func waitForStatus(t *testing.T, observer, subject *Client, status string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		raw := readWithTimeout(observer.send, 50*time.Millisecond)
+		if raw == nil {
+			continue
+		}
+
+		var msg PresenceMessage
+		if err := json.Unmarshal(raw, &msg); err != nil || msg.Type != "presence" {
+			continue
+		}
+		for _, u := range msg.Users {
+			if u.ID == subject.userID && u.Status == status {
+				return
+			}
+		}
+	}
+
+	t.Fatalf("expected %s to become %s", subject.userID, status)
+}
+
+func TestHub_StatusDropsWithInactivityAndRecovers(t *testing.T) {
+	h := NewHub(WithIdleThresholds(60*time.Millisecond, 180*time.Millisecond))
+	go h.Run()
+
+	subject := newTestClient(h, "subject", 64)
+	observer := newTestClient(h, "observer", 64)
+	h.Register(subject)
+	h.Register(observer)
+
+	waitForStatus(t, observer, subject, StatusActive)
+	waitForStatus(t, observer, subject, StatusIdle)
+	waitForStatus(t, observer, subject, StatusAway)
+
+	subject.handleMessage([]byte(`{"type":"typing_update","char":"a"}`))
+	waitForStatus(t, observer, subject, StatusActive)
+}
+
+func TestHub_HiddenTabIsAway(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+
+	subject := newTestClient(h, "subject", 64)
+	observer := newTestClient(h, "observer", 64)
+	h.Register(subject)
+	h.Register(observer)
+
+	subject.handleMessage([]byte(`{"type":"visibility","state":"hidden"}`))
+	waitForStatus(t, observer, subject, StatusAway)
+
+	subject.handleMessage([]byte(`{"type":"visibility","state":"visible"}`))
+	waitForStatus(t, observer, subject, StatusActive)
+}
+
+func TestHub_HiddenClientSkipsTypingAndResyncsOnReturn(t *testing.T) {
+	_, author, watcher := setupHubWithClients(t)
+
+	watcher.handleMessage([]byte(`{"type":"visibility","state":"hidden"}`))
+	waitForPresence := func() {
+		t.Helper()
+		if raw := readWithTimeout(watcher.send, 200*time.Millisecond); raw == nil {
+			t.Fatalf("expected a presence update for the status change")
+		}
+	}
+	waitForPresence()
+
+	for _, msg := range []string{
+		`{"type":"typing_update","char":"h"}`,
+		`{"type":"typing_update","char":"i"}`,
+	} {
+		author.handleMessage([]byte(msg))
+	}
+
+	if raw := readWithTimeout(watcher.send, 50*time.Millisecond); raw != nil {
+		t.Fatalf("expected no typing while hidden, got %s", raw)
+	}
+
+	watcher.handleMessage([]byte(`{"type":"visibility","state":"visible"}`))
+
+	deadline := time.After(500 * time.Millisecond)
+	for {
+		select {
+		case raw := <-watcher.send:
+			var msg TypingSnapshotMessage
+			if err := json.Unmarshal(raw, &msg); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if msg.Type != "typing_snapshot" {
+				continue
+			}
+
+			want := []Composition{{UserID: author.userID, Text: "hi"}}
+			if len(msg.Compositions) != 1 || msg.Compositions[0] != want[0] {
+				t.Fatalf("expected %+v, got %+v", want, msg.Compositions)
+			}
+			return
+
+		case <-deadline:
+			t.Fatalf("expected a typing snapshot on return")
+		}
+	}
+}
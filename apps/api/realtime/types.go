@@ -13,10 +13,160 @@ type RelayMessage struct {
 }
 
 type PresenceUser struct {
-	ID string `json:"id"`
+	ID       string `json:"id"`
+	Name     string `json:"name,omitempty"`
+	Color    string `json:"color,omitempty"`
+	Position *Point `json:"position,omitempty"`
+	Status   string `json:"status,omitempty"` // "active", "idle" or "away"
+
+	// Profile is what the user chose to show, overriding Name and Color.
+	Profile *Profile `json:"profile,omitempty"`
 }
 
 type PresenceMessage struct {
 	Type  string         `json:"type"` // "presence"
 	Users []PresenceUser `json:"users"`
+	Self  *PresenceUser  `json:"self,omitempty"`
+}
+
+// RegionID names a square of the canvas in region mode, counted in regions
+// from the origin.
+type RegionID struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// RegionPresenceMessage lists the users living in one region the client's
+// viewport overlaps, along with the number of users on the whole canvas.
+type RegionPresenceMessage struct {
+	Type   string         `json:"type"` // "region_presence"
+	Region RegionID       `json:"region"`
+	Bounds Rect           `json:"bounds"`
+	Users  []PresenceUser `json:"users"`
+	Self   *PresenceUser  `json:"self,omitempty"`
+	Total  int            `json:"total"`
+}
+
+// PopulationMessage replaces the room-wide presence list in region mode.
+type PopulationMessage struct {
+	Type  string `json:"type"` // "population"
+	Total int    `json:"total"`
+}
+
+// SetProfileMessage sets the client's display profile. Empty fields are unset;
+// an all-empty message clears the profile.
+type SetProfileMessage struct {
+	Type  string `json:"type"` // "set_profile"
+	Name  string `json:"name"`
+	Emoji string `json:"emoji"`
+	Color string `json:"color"`
+}
+
+// TypingReplaceMessage replaces a user's whole composition, as when the word
+// filter masks a word they typed.
+type TypingReplaceMessage struct {
+	Type   string `json:"type"` // "typing_replace"
+	UserID string `json:"userId"`
+	Text   string `json:"text"`
+}
+
+// Composition is the text a user is currently typing.
+type Composition struct {
+	UserID string `json:"userId"`
+	Text   string `json:"text"`
+}
+
+// TypingSnapshotMessage replaces a returning client's view of everyone's
+// compositions, since it missed the keystrokes while its tab was hidden. In
+// region mode each region sends its own, replacing only the compositions of
+// users living in Region.
+type TypingSnapshotMessage struct {
+	Type         string        `json:"type"` // "typing_snapshot"
+	Region       *RegionID     `json:"region,omitempty"`
+	Compositions []Composition `json:"compositions"`
+}
+
+// VisibilityMessage reports whether the client's tab is showing, mirroring
+// document.visibilityState.
+type VisibilityMessage struct {
+	Type  string `json:"type"`  // "visibility"
+	State string `json:"state"` // "visible" or "hidden"
+}
+
+// ErrorMessage tells a client why one of its messages was rejected.
+type ErrorMessage struct {
+	Type    string `json:"type"` // "error"
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// HelloAckMessage answers a client's hello with the identity the server
+// assigned it.
+type HelloAckMessage struct {
+	Type  string `json:"type"` // "hello_ack"
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// SessionMessage is the first event on a Server-Sent Events stream. Clients
+// echo the session ID back when posting their own messages.
+type SessionMessage struct {
+	Type      string `json:"type"` // "session"
+	SessionID string `json:"sessionId"`
+}
+
+// ViewportMessage reports the canvas area a client is looking at. Clients set
+// Publish to share their viewport with followers; the server stamps UserID on
+// the copies it forwards.
+type ViewportMessage struct {
+	Type    string  `json:"type"` // "viewport"
+	UserID  string  `json:"userId,omitempty"`
+	X       float64 `json:"x"`
+	Y       float64 `json:"y"`
+	Width   float64 `json:"width"`
+	Height  float64 `json:"height"`
+	Publish bool    `json:"publish,omitempty"`
+}
+
+// MoveMessage asks the server to move the client's bubble in proximity mode.
+type MoveMessage struct {
+	Type string  `json:"type"` // "move"
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+}
+
+// FollowMessage subscribes a client to another user's published viewport.
+type FollowMessage struct {
+	Type   string `json:"type"` // "follow"
+	UserID string `json:"userId"`
+}
+
+// ReportMessage reports another user to the moderators.
+type ReportMessage struct {
+	Type   string `json:"type"` // "report"
+	UserID string `json:"userId"`
+	Reason string `json:"reason"`
+}
+
+// BlockMessage hides, or with type "unblock" shows again, another user's
+// typing, cursor and presence.
+type BlockMessage struct {
+	Type   string `json:"type"` // "block" or "unblock"
+	UserID string `json:"userId"`
+}
+
+// CursorMessage carries a client's pointer position in canvas coordinates.
+// Clients send it without a userId; the server stamps the author when relaying.
+type CursorMessage struct {
+	Type   string  `json:"type"` // "cursor"
+	UserID string  `json:"userId,omitempty"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+}
+
+// AnnouncementMessage is a system notice operators broadcast to the room.
+type AnnouncementMessage struct {
+	Type string `json:"type"` // "announcement"
+	Text string `json:"text"`
 }
@@ -57,6 +57,9 @@ export default function Composition({
   const caretAnimationTimeoutRef = useRef<NodeJS.Timeout | null>(null);
 
   const setText = useSetAtom(textAtom);
+  // What the composition shows, so a replacement can be turned into the
+  // fewest deletions and additions.
+  const textRef = useRef("");
 
   const showCaretWhileTyping = useCallback(() => {
     if (!caretRef.current) return;
@@ -91,17 +94,37 @@ export default function Composition({
 
         switch (action.kind) {
           case "char":
-            setText((prev) => prev + action.char);
+            textRef.current += action.char;
             animator.addChar(action.char);
             break;
           case "back":
+            textRef.current = Array.from(textRef.current)
+              .slice(0, -1)
+              .join("");
             animator.deleteChar();
             break;
           case "clear":
+            textRef.current = "";
             animator.blurAll();
-            setText("");
             break;
+          case "replace": {
+            const current = Array.from(textRef.current);
+            const next = Array.from(action.text);
+            let kept = 0;
+            while (
+              kept < current.length &&
+              kept < next.length &&
+              current[kept] === next[kept]
+            ) {
+              kept++;
+            }
+            for (let i = kept; i < current.length; i++) animator.deleteChar();
+            for (const char of next.slice(kept)) animator.addChar(char);
+            textRef.current = action.text;
+            break;
+          }
         }
+        setText(textRef.current);
 
         showCaretWhileTyping();
       },
@@ -12,8 +12,8 @@ import LocalEphemeral from "@/components/ephemeral/local-ephemeral";
 import RemoteEphemeral from "@/components/ephemeral/remote-ephemeral";
 import useVisibleViewport from "@/hooks/useVisibleViewport";
 import type { Point, Rect, Size } from "@/lib/spatial";
-import { spatial } from "@/lib/spatial";
-import { connectedUsersAtom } from "@/stores/stores";
+import { fromSharedCanvas, overlapsRects, spatial } from "@/lib/spatial";
+import { connectedUsersAtom, selfUserAtom } from "@/stores/stores";
 import { isKeyboardOpenAtom, visibleViewportRectAtom } from "@/stores/viewport";
 
 import InfoOverlay from "../info-overlay/info-overlay";
@@ -82,6 +82,7 @@ export default function EphemeralApp() {
 
 function EphemeralLayer() {
   const connectedUsers = useAtomValue(connectedUsersAtom);
+  const selfPosition = useAtomValue(selfUserAtom)?.position;
   const isKeyboardOpen = useAtomValue(isKeyboardOpenAtom);
   const viewportRect = useAtomValue(visibleViewportRectAtom);
   const viewportSize = useMemo(() => getRectSize(viewportRect), [viewportRect]);
@@ -93,21 +94,47 @@ function EphemeralLayer() {
     () => createEphemeralSlotSize(viewportSize),
     [viewportSize],
   );
+  // While the keyboard is open the local composition sits above it instead
+  // of at its spot on the shared map.
   const localRect = useMemo(
-    () => createLocalRect(viewportSize, slotSize, isKeyboardOpen),
-    [isKeyboardOpen, slotSize, viewportSize],
-  );
-  const placements = useMemo(
     () =>
-      spatial.layoutUsers({
-        userIds: connectedUsers.map((user) => user.id),
-        bounds: canvasBounds,
-        itemSize: slotSize,
-        reservedRects: [localRect],
-        gap: EPHEMERAL_GAP,
-      }),
-    [canvasBounds, connectedUsers, localRect, slotSize],
+      selfPosition && !isKeyboardOpen
+        ? {
+            ...fromSharedCanvas(selfPosition, canvasBounds, slotSize),
+            ...slotSize,
+          }
+        : createLocalRect(viewportSize, slotSize, isKeyboardOpen),
+    [canvasBounds, isKeyboardOpen, selfPosition, slotSize, viewportSize],
   );
+  const placements = useMemo(() => {
+    // The server places everyone on one shared canvas. Users it sent no
+    // position for, or whose spot would cover someone else on a screen too
+    // small for the whole map, are fitted around the rest locally.
+    const placements = new Map<string, Point>();
+    const reservedRects: Rect[] = [localRect];
+    const unplacedIds: string[] = [];
+    for (const user of [...connectedUsers].sort(byId)) {
+      const point = user.position
+        ? fromSharedCanvas(user.position, canvasBounds, slotSize)
+        : null;
+      if (!point || overlapsRects({ ...point, ...slotSize }, reservedRects)) {
+        unplacedIds.push(user.id);
+        continue;
+      }
+      placements.set(user.id, point);
+      reservedRects.push({ ...point, ...slotSize });
+    }
+
+    const unplaced = spatial.layoutUsers({
+      userIds: unplacedIds,
+      bounds: canvasBounds,
+      itemSize: slotSize,
+      reservedRects,
+      gap: EPHEMERAL_GAP,
+    });
+    for (const [userId, point] of unplaced) placements.set(userId, point);
+    return placements;
+  }, [canvasBounds, connectedUsers, localRect, slotSize]);
 
   return (
     <>
@@ -267,6 +294,10 @@ function createLocalRect(
   };
 }
 
+function byId(a: { id: string }, b: { id: string }): number {
+  return a.id < b.id ? -1 : a.id > b.id ? 1 : 0;
+}
+
 function getRectSize(rect: Rect): Size {
   return {
     width: rect.width,
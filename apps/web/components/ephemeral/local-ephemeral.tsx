@@ -5,7 +5,7 @@ import { useEffect, useRef } from "react";
 
 import { atom, useAtomValue } from "jotai";
 
-import type { TypingAction } from "@/lib/types";
+import type { LocalTypingAction } from "@/lib/types";
 import { actionToClientMessage, inputEventToAction } from "@/lib/typing";
 import { wsClientAtom } from "@/stores/stores";
 import { hasOnScreenKeyboardAtom, isKeyboardOpenAtom } from "@/stores/viewport";
@@ -17,13 +17,13 @@ const localText = atom("");
 
 export default function LocalEphemeral() {
   const compositionRef = useRef<CompositionHandle>(null);
-  const lastAcceptedActionRef = useRef<TypingAction | null>(null);
+  const lastAcceptedActionRef = useRef<LocalTypingAction | null>(null);
   const hasOnScreenKeyboard = useAtomValue(hasOnScreenKeyboardAtom);
   const isKeyboardOpen = useAtomValue(isKeyboardOpenAtom);
   const wsClient = useAtomValue(wsClientAtom);
   const showStartTypingButton = hasOnScreenKeyboard && !isKeyboardOpen;
 
-  const applyAction = (action: TypingAction) => {
+  const applyAction = (action: LocalTypingAction) => {
     lastAcceptedActionRef.current = action;
     compositionRef.current?.apply(action);
     wsClient?.send(actionToClientMessage(action));
@@ -99,8 +99,8 @@ export default function LocalEphemeral() {
 }
 
 function shouldSuppressMobileSpaceInput(
-  action: TypingAction,
-  lastAcceptedAction: TypingAction | null,
+  action: LocalTypingAction,
+  lastAcceptedAction: LocalTypingAction | null,
 ) {
   if (action.kind !== "char") return false;
   if (!lastActionWasSpace(lastAcceptedAction)) return false;
@@ -114,6 +114,6 @@ function startsWithSpace(value: string) {
   return value.startsWith(" ") || value.startsWith("\u00a0");
 }
 
-function lastActionWasSpace(action: TypingAction | null) {
+function lastActionWasSpace(action: LocalTypingAction | null) {
   return action?.kind === "char" && startsWithSpace(action.char);
 }
@@ -22,6 +22,13 @@ export type LayoutUsersOptions = {
 
 const DEFAULT_WIDTH = 1200;
 const DEFAULT_HEIGHT = 720;
+
+/**
+ * The canvas the server lays users out on (apps/api/realtime/spatial.go),
+ * and the bubble size it keeps clear around each of them.
+ */
+export const SHARED_CANVAS: Size = { width: 1200, height: 720 };
+export const SHARED_BUBBLE: Size = { width: 240, height: 136 };
 const DEFAULT_PADDING = 80;
 const DEFAULT_GAP = 32;
 const MAX_RANDOM_CANDIDATES = 160;
@@ -137,6 +144,32 @@ export class SpatialIndex {
   }
 }
 
+/**
+ * Maps a server-assigned position on the shared canvas into bounds, keeping
+ * each bubble at the same relative spot so every client draws the same map
+ * whatever the size of its screen.
+ */
+export function fromSharedCanvas(
+  position: Point,
+  bounds: Rect,
+  itemSize: Size,
+): Point {
+  const spanX = SHARED_CANVAS.width - SHARED_BUBBLE.width;
+  const spanY = SHARED_CANVAS.height - SHARED_BUBBLE.height;
+  const fx = clampUnit(position.x / spanX);
+  const fy = clampUnit(position.y / spanY);
+
+  return {
+    x: bounds.x + fx * Math.max(0, bounds.width - itemSize.width),
+    y: bounds.y + fy * Math.max(0, bounds.height - itemSize.height),
+  };
+}
+
+/** Whether rect covers any of rects. */
+export function overlapsRects(rect: Rect, rects: Rect[]): boolean {
+  return overlapsAny(rect, rects, 0);
+}
+
 export function createSpatialIndex(options?: SpatialOptions): SpatialIndex {
   return new SpatialIndex(options);
 }
@@ -177,6 +210,10 @@ function randomSeed(): number {
   return Math.floor(Math.random() * 0xffffffff) >>> 0;
 }
 
+function clampUnit(value: number): number {
+  return Number.isFinite(value) ? Math.min(Math.max(value, 0), 1) : 0;
+}
+
 function rectFromPoint(point: Point, size: Size): Rect {
   return { x: point.x, y: point.y, width: size.width, height: size.height };
 }
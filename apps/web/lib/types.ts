@@ -1,12 +1,31 @@
+export type PresenceUser = {
+  id: string;
+  name?: string;
+  color?: string;
+  /** Top-left of the user's bubble on the shared canvas, set by the server. */
+  position?: { x: number; y: number };
+  status?: "active" | "idle" | "away";
+};
+
 export type Presence = {
-  users: { id: string }[];
+  users: PresenceUser[];
+  self?: PresenceUser;
 };
 
-export type TypingAction =
+/** What a user does to their own composition. */
+export type LocalTypingAction =
   | { kind: "char"; char: string }
   | { kind: "back" }
   | { kind: "clear" };
 
+/**
+ * Anything that changes a composition, including the server replacing a
+ * remote one wholesale, as when its word filter masks a word.
+ */
+export type TypingAction =
+  | LocalTypingAction
+  | { kind: "replace"; text: string };
+
 export type TypingUpdate = {
   userId: string;
   char: string;
@@ -20,12 +39,18 @@ export type TypingBack = {
   userId: string;
 };
 
+export type TypingReplace = {
+  userId: string;
+  text: string;
+};
+
 // Server -> Client messages (flat, include userId when applicable)
 export type ServerMessage =
   | ({ type: "presence" } & Presence)
   | ({ type: "typing_update" } & TypingUpdate)
   | ({ type: "typing_clear" } & TypingClear)
-  | ({ type: "typing_back" } & TypingBack);
+  | ({ type: "typing_back" } & TypingBack)
+  | ({ type: "typing_replace" } & TypingReplace);
 
 // Client -> Server messages (flat, no userId)
 export type ClientTypingUpdate = {
@@ -1,4 +1,9 @@
-import { ClientMessage, ServerMessage, TypingAction } from "./types";
+import {
+  ClientMessage,
+  LocalTypingAction,
+  ServerMessage,
+  TypingAction,
+} from "./types";
 
 const PRINTABLE_ASCII_START = 0x20;
 const PRINTABLE_ASCII_END = 0x7e;
@@ -34,7 +39,7 @@ export function sanitizeKeyboardText(
 }
 
 /** Keyboard adapter: decode a raw `InputEvent` into a typing action. */
-export function inputEventToAction(e: InputEvent): TypingAction | null {
+export function inputEventToAction(e: InputEvent): LocalTypingAction | null {
   // Enter / paragraph insertion clears the composition.
   if (e.inputType === "insertParagraph") return { kind: "clear" };
   // Backspace deletes the last character.
@@ -66,13 +71,18 @@ export function serverMessageToAction(
     case "typing_back":
       if (msg.userId !== userId) return null;
       return { kind: "back" };
+    case "typing_replace":
+      if (msg.userId !== userId) return null;
+      return { kind: "replace", text: msg.text };
     default:
       return null;
   }
 }
 
 /** Encode a local typing action into the client-to-server wire message. */
-export function actionToClientMessage(action: TypingAction): ClientMessage {
+export function actionToClientMessage(
+  action: LocalTypingAction,
+): ClientMessage {
   switch (action.kind) {
     case "char":
       return { type: "typing_update", char: action.char };
@@ -3,6 +3,11 @@ import { ClientMessage, ServerMessage } from "./types";
 export type ConnectionStatus = "connecting" | "open" | "closed";
 
 const CONNECT_PATH = "/connect";
+const CHALLENGE_PATH = "/challenge";
+// How many nonces to hash at once while solving a challenge.
+const SOLVE_BATCH = 256;
+
+type Challenge = { challenge: string; difficulty: number; expiresAt: string };
 
 export function getWebSocketUrl() {
   const configuredWsUrl = process.env.NEXT_PUBLIC_WS_URL;
@@ -31,6 +36,85 @@ function webSocketUrlFromApiUrl(value: string) {
   return url.toString();
 }
 
+function challengeUrlFromWebSocketUrl(value: string) {
+  const url = new URL(value);
+  url.protocol = url.protocol === "wss:" ? "https:" : "http:";
+  const base = url.pathname.replace(/\/$/, "").replace(/\/connect$/, "");
+  url.pathname = `${base}${CHALLENGE_PATH}`;
+  url.search = "";
+  return url.toString();
+}
+
+/**
+ * Adds a solved proof-of-work challenge to the connect URL when the server
+ * hands one out. A server with the challenge off answers 204, and the URL is
+ * used as it is.
+ */
+async function withSolvedChallenge(wsUrl: string) {
+  const challenge = await fetchChallenge(wsUrl);
+  if (!challenge) return wsUrl;
+
+  const url = new URL(wsUrl);
+  url.searchParams.set("challenge", challenge.challenge);
+  url.searchParams.set(
+    "nonce",
+    await solveChallenge(challenge.challenge, challenge.difficulty),
+  );
+  return url.toString();
+}
+
+/**
+ * Fetches a challenge to solve, or null when there is none. A failed request
+ * counts as none too, so the connect goes ahead and reports whatever is
+ * wrong, and a server that does want a challenge turns it away with 428.
+ */
+async function fetchChallenge(wsUrl: string): Promise<Challenge | null> {
+  try {
+    const res = await fetch(challengeUrlFromWebSocketUrl(wsUrl), {
+      cache: "no-store",
+    });
+    if (res.status !== 200) return null;
+    return (await res.json()) as Challenge;
+  } catch (e) {
+    console.warn("WebSocket challenge unavailable", e);
+    return null;
+  }
+}
+
+/**
+ * Finds a nonce for which SHA-256 of `<challenge>:<nonce>` starts with
+ * difficulty zero bits.
+ */
+async function solveChallenge(challenge: string, difficulty: number) {
+  const encoder = new TextEncoder();
+  for (let start = 0; ; start += SOLVE_BATCH) {
+    const nonces = Array.from({ length: SOLVE_BATCH }, (_, i) =>
+      String(start + i),
+    );
+    const sums = await Promise.all(
+      nonces.map((nonce) =>
+        crypto.subtle.digest(
+          "SHA-256",
+          encoder.encode(`${challenge}:${nonce}`),
+        ),
+      ),
+    );
+    const i = sums.findIndex(
+      (sum) => leadingZeroBits(new Uint8Array(sum)) >= difficulty,
+    );
+    if (i >= 0) return nonces[i];
+  }
+}
+
+function leadingZeroBits(bytes: Uint8Array) {
+  let n = 0;
+  for (const b of bytes) {
+    if (b !== 0) return n + Math.clz32(b) - 24;
+    n += 8;
+  }
+  return n;
+}
+
 export class WSClient {
   private ws: WebSocket | null = null;
   private url = getWebSocketUrl();
@@ -39,6 +123,9 @@ export class WSClient {
   private connectionTimeoutId: ReturnType<typeof setTimeout> | null = null;
   private reconnectTimeoutId: ReturnType<typeof setTimeout> | null = null;
   private intentionallyClosed = false;
+  // Bumped on every connect and disconnect, so a challenge still being
+  // solved for an abandoned attempt doesn't open a socket.
+  private attempt = 0;
 
   private messageListeners: Array<(msg: ServerMessage) => void> = [];
   private statusListeners: Array<(status: ConnectionStatus) => void> = [];
@@ -48,7 +135,20 @@ export class WSClient {
     this.intentionallyClosed = false;
     this.setStatus("connecting");
 
-    const ws = new WebSocket(this.url);
+    const attempt = ++this.attempt;
+    withSolvedChallenge(this.url).then(
+      (url) => {
+        if (attempt === this.attempt) this.open(url);
+      },
+      (e) => {
+        console.error("WebSocket challenge error", e);
+        if (attempt === this.attempt) this.reconnect();
+      },
+    );
+  }
+
+  private open(url: string) {
+    const ws = new WebSocket(url);
     this.ws = ws;
 
     // Set a timeout to close the connection if it doesn't open in time
@@ -69,15 +169,7 @@ export class WSClient {
       this.setStatus("open");
     };
 
-    ws.onclose = () => {
-      this.setStatus("closed");
-      if (this.intentionallyClosed) return;
-      this.reconnectTimeoutId = setTimeout(
-        () => this.connect(),
-        this.reconnectDelayMs,
-      );
-      this.reconnectDelayMs = Math.min(8000, this.reconnectDelayMs * 2);
-    };
+    ws.onclose = () => this.reconnect();
 
     ws.onerror = (e) => {
       console.error("WebSocket error", e);
@@ -105,6 +197,7 @@ export class WSClient {
   /** Tear down the socket and stop the reconnect loop. */
   disconnect() {
     this.intentionallyClosed = true;
+    this.attempt++;
     if (this.connectionTimeoutId) {
       clearTimeout(this.connectionTimeoutId);
       this.connectionTimeoutId = null;
@@ -147,6 +240,16 @@ export class WSClient {
     }
   }
 
+  private reconnect() {
+    this.setStatus("closed");
+    if (this.intentionallyClosed) return;
+    this.reconnectTimeoutId = setTimeout(
+      () => this.connect(),
+      this.reconnectDelayMs,
+    );
+    this.reconnectDelayMs = Math.min(8000, this.reconnectDelayMs * 2);
+  }
+
   private setStatus(status: ConnectionStatus) {
     if (this.status === status) return;
     this.status = status;
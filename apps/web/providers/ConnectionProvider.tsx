@@ -8,6 +8,7 @@ import { WSClient } from "@/lib/ws";
 import {
   connectedUsersAtom,
   connectionStatusAtom,
+  selfUserAtom,
   wsClientAtom,
 } from "@/stores/stores";
 
@@ -18,13 +19,16 @@ export const ConnectionProvider = ({
 }) => {
   const setWsClient = useSetAtom(wsClientAtom);
   const setConnectedUsers = useSetAtom(connectedUsersAtom);
+  const setSelfUser = useSetAtom(selfUserAtom);
   const setStatus = useSetAtom(connectionStatusAtom);
 
   useEffect(() => {
     const ws = new WSClient();
 
     const unsubscribeMessage = ws.onMessage((msg) => {
-      if (msg.type === "presence") setConnectedUsers(msg.users);
+      if (msg.type !== "presence") return;
+      setConnectedUsers(msg.users);
+      setSelfUser(msg.self ?? null);
     });
     const unsubscribeStatus = ws.onStatus(setStatus);
 
@@ -37,7 +41,7 @@ export const ConnectionProvider = ({
       ws.disconnect();
       setWsClient(null);
     };
-  }, [setWsClient, setConnectedUsers, setStatus]);
+  }, [setWsClient, setConnectedUsers, setSelfUser, setStatus]);
 
   return children;
 };
@@ -4,6 +4,7 @@ import { Presence } from "@/lib/types";
 import { ConnectionStatus, WSClient } from "@/lib/ws";
 
 export const connectedUsersAtom = atom<Presence["users"]>([]);
+export const selfUserAtom = atom<Presence["self"] | null>(null);
 export const connectionStatusAtom = atom<ConnectionStatus>("closed");
 
 export const wsClientAtom = atom<WSClient | null>(null);
@@ -1,4 +1,5 @@
 import { expect, test } from "@playwright/test";
+import { closeUser, openUser, remoteCompositions } from "../fixtures/app";
 
 test("websocket upgrade rejects disallowed origins", async ({ request }) => {
   const apiPort = process.env.E2E_API_PORT ?? "18080";
@@ -14,3 +15,37 @@ test("websocket upgrade rejects disallowed origins", async ({ request }) => {
 
   expect(response.status()).toBe(403);
 });
+
+test("challenge endpoint answers cross-origin while challenges are off", async ({
+  baseURL,
+  request,
+}) => {
+  const apiPort = process.env.E2E_API_PORT ?? "18080";
+  const origin = new URL(baseURL ?? "http://127.0.0.1:13000").origin;
+  const response = await request.get(`http://127.0.0.1:${apiPort}/challenge`, {
+    headers: { Origin: origin },
+  });
+
+  expect(response.status()).toBe(204);
+  expect(response.headers()["access-control-allow-origin"]).toBe(origin);
+});
+
+test("the app connects while challenges are off", async ({ browser }) => {
+  const context = await browser.newContext();
+  const page = await context.newPage();
+  const challengeStatuses: number[] = [];
+  page.on("response", (response) => {
+    if (new URL(response.url()).pathname === "/challenge") {
+      challengeStatuses.push(response.status());
+    }
+  });
+
+  await page.goto("/");
+  const other = await openUser(browser);
+
+  await expect(remoteCompositions(page)).toHaveCount(1);
+  expect(challengeStatuses).toEqual([204]);
+
+  await closeUser(other);
+  await context.close();
+});
@@ -67,6 +67,59 @@ test("connected user slots do not overlap the local slot", async ({
   }
 });
 
+test("every client draws a user at the same spot on the shared map", async ({
+  browser,
+  isMobile,
+}) => {
+  test.skip(isMobile, "phones are too narrow to fit the whole shared map");
+
+  const { first, second } = await openTwoUsers(browser);
+
+  try {
+    // Both pages share a screen size, so the server's layout maps to the
+    // same pixels in each.
+    await expect
+      .poll(async () => {
+        const [firstLocal, secondRemote] = await Promise.all([
+          slotRect(first.page, "local-composition-slot"),
+          slotRect(second.page, "remote-composition-slot"),
+        ]);
+        return sameRect(firstLocal, secondRemote);
+      })
+      .toBe(true);
+    await expect
+      .poll(async () => {
+        const [secondLocal, firstRemote] = await Promise.all([
+          slotRect(second.page, "local-composition-slot"),
+          slotRect(first.page, "remote-composition-slot"),
+        ]);
+        return sameRect(secondLocal, firstRemote);
+      })
+      .toBe(true);
+  } finally {
+    await closeUser(second);
+    await closeUser(first);
+  }
+});
+
+async function slotRect(page: Page, testId: string): Promise<Rect> {
+  return page
+    .getByTestId(testId)
+    .evaluate((slot) => {
+      const rect = slot.getBoundingClientRect();
+      return { x: rect.x, y: rect.y, width: rect.width, height: rect.height };
+    });
+}
+
+function sameRect(a: Rect, b: Rect) {
+  return (
+    Math.abs(a.x - b.x) < 1 &&
+    Math.abs(a.y - b.y) < 1 &&
+    Math.abs(a.width - b.width) < 1 &&
+    Math.abs(a.height - b.height) < 1
+  );
+}
+
 async function expectSlotsToNotOverlap(page: Page) {
   await expect
     .poll(